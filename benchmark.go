@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type benchmarkTimingsKey struct{}
+
+// Collects the duration of every individual dataset query made during a benchmark run
+type BenchmarkTimings struct {
+	mutex	sync.Mutex
+	queries	map[string][]time.Duration
+}
+
+func (timings *BenchmarkTimings) record(table string, duration time.Duration) {
+	timings.mutex.Lock()
+	defer timings.mutex.Unlock()
+
+	timings.queries[table] = append(timings.queries[table], duration)
+}
+
+// Behaves exactly like the wrapped span, but also reports its duration to the benchmark timings
+type timedSpan struct {
+	trace.Span
+	table	string
+	start	time.Time
+	timings	*BenchmarkTimings
+}
+
+func (span timedSpan) End(options ...trace.SpanEndOption) {
+	span.timings.record(span.table, time.Since(span.start))
+	span.Span.End(options...)
+}
+
+func benchmarkTimeSpan(ctx context.Context, table string, span trace.Span) trace.Span {
+	timings, ok := ctx.Value(benchmarkTimingsKey{}).(*BenchmarkTimings)
+	if !ok {
+		return span
+	}
+
+	return timedSpan{ span, table, time.Now(), timings }
+}
+
+func benchmarkValidate(options BenchmarkOptions) error {
+	if options.Times < 1 && options.Duration <= 0 && len(options.Corpus) == 0 {
+		return errors.New("a positive number of times, a duration or a corpus is required")
+	}
+
+	if options.Times > config().BenchmarkMaxTimes {
+		return errors.New("times may not exceed " + strconv.Itoa(config().BenchmarkMaxTimes))
+	}
+
+	if options.Warmup < 0 || options.Warmup > config().BenchmarkMaxTimes {
+		return errors.New("warmup must be between 0 and " + strconv.Itoa(config().BenchmarkMaxTimes))
+	}
+
+	if options.Concurrency < 1 || options.Concurrency > config().BenchmarkMaxConcurrency {
+		return errors.New("concurrency must be between 1 and " + strconv.Itoa(config().BenchmarkMaxConcurrency))
+	}
+
+	if options.Duration > config().BenchmarkMaxDuration {
+		return errors.New("duration may not exceed " + config().BenchmarkMaxDuration.String())
+	}
+
+	return nil
+}
+
+// Test addresses are all generated up front so that generation isn't part of the benchmark
+func benchmarkIps(ctx context.Context, rng *rand.Rand, options BenchmarkOptions, count int) ([]string, error) {
+	var ips []string
+	for i := 0; i < count; i++ {
+		ipString, err := sampleOrRandomIp(ctx, rng, options.IpVersion, options.Filter)
+		if err != nil {
+			return nil, err
+		}
+
+		ips = append(ips, ipString)
+	}
+
+	return ips, nil
+}
+
+// Corpus files are plain lists of addresses (one per line, `#` for comments) kept in `BENCHMARK_CORPUS_DIR`
+func benchmarkCorpus(name string) ([]string, error) {
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, errors.New("corpus must be a file name within the corpus directory")
+	}
+
+	file, err := os.Open(filepath.Join(config().BenchmarkCorpusDir, name))
+	if err != nil {
+		return nil, errors.New("corpus " + name + " could not be opened")
+	}
+	defer file.Close()
+
+	var ips []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() && len(ips) < config().BenchmarkMaxTimes {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			ips = append(ips, line)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, errors.New("corpus " + name + " contains no addresses")
+	}
+
+	return ips, nil
+}
+
+// Cycles through the list so that any number of addresses can be taken from it
+func benchmarkCycle(ips []string, count int) []string {
+	var cycled []string
+	for i := 0; i < count; i++ {
+		cycled = append(cycled, ips[i % len(ips)])
+	}
+
+	return cycled
+}
+
+func benchmarkRun(ctx context.Context, options BenchmarkOptions) (*BenchmarkReport, error) {
+	err := benchmarkValidate(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// A fixed seed makes the generated addresses (and so the whole run) reproducible
+	if options.Seed == 0 {
+		options.Seed = rand.Uint64()
+	}
+	rng := rand.New(rand.NewPCG(options.Seed, options.Seed))
+
+	// Duration based runs cycle through a pool of addresses and stop at the cap regardless
+	limit := options.Times
+	if options.Duration > 0 {
+		limit = config().BenchmarkMaxTimes
+	}
+
+	var ips, warmup []string
+	if len(options.Corpus) > 0 {
+		ips, err = benchmarkCorpus(options.Corpus)
+		if err != nil {
+			return nil, err
+		}
+
+		if limit == 0 {
+			limit = len(ips)
+		}
+		warmup = benchmarkCycle(ips, options.Warmup)
+	} else {
+		poolSize := limit
+		if options.Duration > 0 && poolSize > 10000 {
+			poolSize = 10000
+		}
+
+		ips, err = benchmarkIps(ctx, rng, options, poolSize)
+		if err != nil {
+			return nil, err
+		}
+
+		warmup, err = benchmarkIps(ctx, rng, options, options.Warmup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Warm up connections / caches without recording anything
+	benchmarkWorkers(ctx, options.Concurrency, warmup, len(warmup), 0, nil, nil)
+
+	timings		:= &BenchmarkTimings{ queries: map[string][]time.Duration{} }
+	latencies	:= make([]time.Duration, limit)
+	errorCount	:= 0
+
+	start := time.Now()
+	completed := benchmarkWorkers(context.WithValue(ctx, benchmarkTimingsKey{}, timings), options.Concurrency, ips, limit, options.Duration, latencies, &errorCount)
+	elapsed := time.Since(start)
+
+	latencies = latencies[:completed]
+
+	report := NewBenchmarkReport(options, completed, errorCount, elapsed)
+	report.Latency = benchmarkLatency(latencies)
+	for table, durations := range timings.queries {
+		report.Queries[table] = benchmarkLatency(durations)
+	}
+
+	return report, nil
+}
+
+// Runs lookups across `concurrency` workers until `limit` lookups are done or `duration` (if positive) elapses
+func benchmarkWorkers(ctx context.Context, concurrency int, ips []string, limit int, duration time.Duration, latencies []time.Duration, errorCount *int) int {
+	if len(ips) == 0 || limit == 0 {
+		return 0
+	}
+
+	var deadline time.Time
+	if duration > 0 {
+		deadline = time.Now().Add(duration)
+	}
+
+	var mutex sync.Mutex
+	var waitGroup sync.WaitGroup
+	next := 0
+
+	for worker := 0; worker < concurrency; worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+
+			for {
+				mutex.Lock()
+				if next >= limit || (!deadline.IsZero() && time.Now().After(deadline)) || ctx.Err() != nil {
+					mutex.Unlock()
+					return
+				}
+				index := next
+				next++
+				mutex.Unlock()
+
+				start := time.Now()
+				_, err := fetchIP(ctx, ips[index % len(ips)])
+				taken := time.Since(start)
+
+				if latencies != nil {
+					latencies[index] = taken
+				}
+
+				if err != nil && errorCount != nil {
+					mutex.Lock()
+					*errorCount++
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	waitGroup.Wait()
+
+	if next > limit {
+		return limit
+	}
+
+	return next
+}
+
+func benchmarkLatency(durations []time.Duration) BenchmarkLatency {
+	latency := BenchmarkLatency{ Count: len(durations) }
+	if len(durations) == 0 {
+		return latency
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, duration := range sorted {
+		total += duration
+	}
+
+	latency.Mean	= microseconds(total / time.Duration(len(sorted)))
+	latency.P50		= microseconds(percentile(sorted, 50))
+	latency.P90		= microseconds(percentile(sorted, 90))
+	latency.P99		= microseconds(percentile(sorted, 99))
+	latency.Max		= microseconds(sorted[len(sorted) - 1])
+
+	return latency
+}
+
+// Nearest-rank percentile of an already sorted slice
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank - 1]
+}
+
+func microseconds(duration time.Duration) float64 {
+	return math.Round(float64(duration.Nanoseconds()) / 10) / 100
+}
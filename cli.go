@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+var cliCommands = []string{ "serve", "load", "lookup", "export", "status", "check-config" }
+
+// The first argument picks the command, `serve` when there isn't one (or it's a flag)
+func cliCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "serve", args
+	}
+
+	if !slices.Contains(cliCommands, args[0]) {
+		fmt.Fprintln(os.Stderr, "unknown command " + args[0] + ", expected one of: " + strings.Join(cliCommands, ", "))
+		os.Exit(2)
+	}
+
+	return args[0], args[1:]
+}
+
+func cliFlags(command string) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ip-location-api " + command + cliUsage(command) + " [flags]")
+		flags.PrintDefaults()
+	}
+
+	if command == "lookup" {
+		flags.String("lang", "", "comma separated languages for the place names, e.g. de,fr")
+		flags.String("as-of", "", "look up in the data live at this date / time (see RETAIN_VERSIONS)")
+	}
+
+	flags.String("config", "", "configuration file (YAML or TOML), overrides CONFIG_FILE")
+	for _, setting := range configKeys() {
+		flags.String(cliFlagName(setting), "", "overrides " + setting)
+	}
+
+	return flags
+}
+
+// Flags may come before or after the arguments, e.g. `lookup 1.2.3.4 --lang=de`
+func cliParse(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+
+		positional	= append(positional, flags.Arg(0))
+		args		= flags.Args()[1:]
+	}
+}
+
+func cliUsage(command string) string {
+	switch command {
+		case "lookup":	return " <ip>..."
+		case "export":	return " <csv|mmdb|sqlite> [source...]"
+	}
+
+	return ""
+}
+
+func cliFlagName(setting string) string {
+	return strings.ReplaceAll(strings.ToLower(setting), "_", "-")
+}
+
+// Only the flags actually given override the configuration file and environment
+func cliFlagValues(flags *flag.FlagSet) map[string]string {
+	values := map[string]string{}
+	flags.Visit(func(given *flag.Flag) {
+		if given.Name == "config" {
+			values["CONFIG_FILE"] = given.Value.String()
+		}
+
+		for _, setting := range configKeys() {
+			if given.Name == cliFlagName(setting) {
+				values[setting] = given.Value.String()
+			}
+		}
+	})
+
+	return values
+}
+
+// Commands that print their results keep stdout for them, logging to stderr instead
+func cliLogOutput(command string) io.Writer {
+	switch command {
+		case "lookup", "status", "check-config":	return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+// Runs an update once, exiting with an error if it fails
+func cliLoad() {
+	prepare()
+
+	initialised, missing := loadCheckInitialised()
+	if initialised {
+		missing = []string{}
+	}
+
+	if !upgrade(missing) {
+		os.Exit(1)
+	}
+}
+
+// One JSON result per line, in the order given
+func cliLookup(flags *flag.FlagSet, ipStrings []string) {
+	if len(ipStrings) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	prepare()
+
+	ctx := context.Background()
+	if asOfString := flags.Lookup("as-of").Value.String(); len(asOfString) > 0 {
+		asOf, err := versionParseAsOf(asOfString)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		ctx = versionContext(ctx, asOf)
+	}
+
+	var languages []string
+	for _, language := range strings.Split(flags.Lookup("lang").Value.String(), ",") {
+		language = strings.TrimSpace(language)
+		if len(language) > 0 {
+			languages = append(languages, language)
+		}
+	}
+
+	failed := false
+	for _, ipString := range ipStrings {
+		jsonBytes, err := fetchIPJson(ctx, ipString, languages)
+		if err != nil {
+			jsonBytes, _	= json.Marshal(map[string]string{ "ip": ipString, "error": err.Error() })
+			failed			= true
+		}
+
+		fmt.Println(string(jsonBytes))
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func cliStatus() {
+	prepare()
+
+	status := NewStatus(config().DbType)
+	for _, key := range []string{ "COUNTRY", "CITY", "ASN" } {
+		for _, source := range datasetSources(key) {
+			status.add(key, source)
+		}
+	}
+
+	if hasReputationDatabase() {
+		status.add("REPUTATION", "")
+	}
+
+	if hasGeofeedDatabase() {
+		status.add("GEOFEED", "")
+	}
+
+	if hasConsensusDatabase() {
+		status.add("CONSENSUS", "")
+	}
+
+	jsonBytes, err := json.MarshalIndent(status, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(string(jsonBytes))
+}
+
+func (status *Status) add(key string, source string) {
+	table		:= "ip_" + strings.ToLower(key)
+	versions	:= append(versionsOf(table, source, 4), versionsOf(table, source, 6)...)
+	if versions == nil {
+		versions = []DatasetVersion{}
+	}
+
+	status.Datasets = append(status.Datasets, DatasetStatus{ datasetName(table, source), key, dbInitialised(key, source), versions })
+}
+
+// Reports every problem found by loading the configuration, without connecting to the database
+func cliCheckConfig(problems []string) {
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Println("configuration is valid")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Sources from the `available` registry that hold data, worked out on first use and discarded whenever new data is loaded
+var compareSources	[]string
+var compareMutex	sync.Mutex
+
+func compareReset() {
+	compareMutex.Lock()
+	defer compareMutex.Unlock()
+
+	compareSources = nil
+}
+
+// Configured sources come first (in priority order), followed by any others left loaded from earlier configurations
+func compareLoadedSources() []string {
+	compareMutex.Lock()
+	defer compareMutex.Unlock()
+
+	if compareSources == nil {
+		compareSources = []string{}
+		for _, key := range []string{ "COUNTRY", "CITY", "ASN" } {
+			for _, source := range compareOrder(key) {
+				if dbInitialised(key, source) {
+					compareSources = append(compareSources, source)
+				}
+			}
+		}
+	}
+
+	return compareSources
+}
+
+func compareOrder(key string) []string {
+	sources := datasetSources(key)
+
+	var others []string
+	for name, download := range availableSources() {
+		if download.Type == key && !slices.Contains(sources, name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+
+	return append(sources, others...)
+}
+
+func compareIp(ctx context.Context, ipString string) (*Comparison, error) {
+	start := time.Now()
+
+	address, err := netip.ParseAddr(ipString)
+	if err != nil || address.Zone() != "" {
+		return nil, errors.New("invalid IP address passed (" + ipString + ")")
+	}
+
+	effective, _ := addressEmbeddedIpv4(address)
+	addressType, isGlobal := addressClassify(effective)
+	if !isGlobal {
+		return nil, errors.New("special-purpose (" + addressType + ") IP ranges have no location data to compare")
+	}
+
+	ip			:= net.IP(effective.AsSlice())
+	loaded		:= compareLoadedSources()
+	comparison	:= NewComparison(ipString, effective.String())
+
+	for _, source := range loaded {
+		download	:= availableSources()[source]
+		result		:= ComparisonResult{ Source: source, Type: download.Type, Configured: slices.Contains(datasetSources(download.Type), source) }
+
+		switch download.Type {
+			case "COUNTRY":
+				country, ok := dbCountry(ctx, ip, source)
+				result.Found		= ok && len(country.CountryCode) > 0
+				result.CountryCode	= country.CountryCode
+			case "CITY":
+				city, ok := dbCity(ctx, ip, source)
+				result.Found		= ok && (len(city.CountryCode) > 0 || len(city.City) > 0)
+				result.CountryCode	= city.CountryCode
+				result.State1		= city.State1
+				result.City			= city.City
+				result.Latitude		= city.Latitude
+				result.Longitude	= city.Longitude
+			case "ASN":
+				asn, ok := dbASN(ctx, ip, source)
+				result.Found			= ok && (asn.AsNumber > 0 || len(asn.AsOrganisation) > 0)
+				result.AsNumber			= asn.AsNumber
+				result.AsOrganisation	= asn.AsOrganisation
+		}
+
+		comparison.Results = append(comparison.Results, result)
+	}
+
+	for name := range availableSources() {
+		if !slices.Contains(loaded, name) {
+			comparison.NotLoaded = append(comparison.NotLoaded, name)
+		}
+	}
+	sort.Strings(comparison.NotLoaded)
+
+	comparison.Agreement["country"]	= compareAgreement(comparison.Results, func(result ComparisonResult) string { return result.CountryCode })
+	comparison.Agreement["city"]	= compareAgreement(comparison.Results, func(result ComparisonResult) string {
+		if len(result.City) == 0 {
+			return ""
+		}
+
+		return result.City + ", " + result.CountryCode
+	})
+	comparison.Agreement["asn"]		= compareAgreement(comparison.Results, func(result ComparisonResult) string {
+		if result.AsNumber == 0 {
+			return ""
+		}
+
+		return strconv.Itoa(result.AsNumber)
+	})
+
+	comparison.Milliseconds = time.Now().Sub(start).Milliseconds()
+	comparison.Microseconds = time.Now().Sub(start).Microseconds()
+
+	return comparison, nil
+}
+
+// Sources without an answer are left out, ties for the most common value go to the highest priority source
+func compareAgreement(results []ComparisonResult, value func(ComparisonResult) string) ComparisonAgreement {
+	agreement := ComparisonAgreement{ Values: map[string][]string{} }
+
+	var order []string
+	for _, result := range results {
+		answer := value(result)
+		if !result.Found || len(answer) == 0 {
+			continue
+		}
+
+		// Names differ in case between datasets (e.g. `Frankfurt am Main` and `Frankfurt Am Main`)
+		for existing := range agreement.Values {
+			if strings.EqualFold(existing, answer) {
+				answer = existing
+				break
+			}
+		}
+
+		if _, ok := agreement.Values[answer]; !ok {
+			order = append(order, answer)
+		}
+		agreement.Values[answer] = append(agreement.Values[answer], result.Source)
+		agreement.Answered++
+	}
+
+	for _, answer := range order {
+		if len(agreement.Values[answer]) > agreement.Agreeing {
+			agreement.Value		= answer
+			agreement.Agreeing	= len(agreement.Values[answer])
+		}
+	}
+	agreement.Unanimous = agreement.Answered > 0 && len(order) == 1
+
+	return agreement
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// Every setting may be given in the configuration file, as an environment variable or as a flag, e.g. `--db-type=sqlite`
+var configSettings = []string{
+	"SERVER_HOST", "SERVER_PORT", "API_KEY", "ADMIN_API_KEY",
+	"DB_TYPE", "DB_HOST", "DB_PORT", "DB_NAME", "DB_SCHEMA", "DB_USER", "DB_PASS", "DB_FILE",
+	"SOURCES", "COUNTRY", "CITY", "ASN", "REPUTATION", "GEOFEED", "COUNTRY_CONSENSUS", "COUNTRY_CONSENSUS_TRUST",
+	"UPDATE_TIME", "LOAD_LOG_FREQ", "RETAIN_VERSIONS", "RETAIN_DAYS", "DIFF_REPORTS", "DIFF_DIR",
+	"WEBHOOK_URL", "WEBHOOK_SECRET", "WEBHOOK_EVENTS", "WEBHOOK_RETRIES", "EXPORT_DIR",
+	"REJECT_SPECIAL_ADDRESSES", "TIMEZONE_INFER", "LOG_LEVEL", "LOG_FORMAT",
+	"BENCHMARK_MAX_TIMES", "BENCHMARK_MAX_CONCURRENCY", "BENCHMARK_MAX_DURATION", "BENCHMARK_CORPUS_DIR",
+	"TRACE_ENDPOINT", "TRACE_INSECURE", "TRACE_SERVICE_NAME", "TRACE_SAMPLE_RATIO",
+}
+
+// Secrets may instead be read from a file with a `_FILE` setting, e.g. `DB_PASS_FILE=/run/secrets/db_pass`
+var configSecrets = []string{ "API_KEY", "ADMIN_API_KEY", "DB_USER", "DB_PASS", "WEBHOOK_URL", "WEBHOOK_SECRET" }
+
+// Searched for in the working directory when neither `--config` nor `CONFIG_FILE` name a file
+var configFileNames = []string{ "config.yaml", "config.yml", "config.toml" }
+
+// Settings only read when starting (the server, database connection, update schedule and tracing), a reload keeps their current values
+var configRestartSettings = []string{
+	"SERVER_HOST", "SERVER_PORT", "DB_TYPE", "DB_HOST", "DB_PORT", "DB_NAME", "DB_SCHEMA", "DB_USER", "DB_PASS", "DB_FILE",
+	"UPDATE_TIME", "TRACE_ENDPOINT", "TRACE_INSECURE", "TRACE_SERVICE_NAME", "TRACE_SAMPLE_RATIO",
+}
+
+// Changing any of these may need data loading
+var configDatasetSettings = []string{ "SOURCES", "COUNTRY", "CITY", "ASN", "REPUTATION", "GEOFEED", "COUNTRY_CONSENSUS", "COUNTRY_CONSENSUS_TRUST" }
+
+var configCurrent		atomic.Pointer[Config]
+var configFlags			map[string]string
+var configReloadMutex	sync.Mutex
+
+func config() *Config {
+	return configCurrent.Load()
+}
+
+// Every setting, including the `_FILE` variants of the secrets
+func configKeys() []string {
+	keys := slices.Clone(configSettings)
+	for _, secret := range configSecrets {
+		keys = append(keys, secret + "_FILE")
+	}
+
+	return keys
+}
+
+// The configuration file is overridden by the environment (which a .env file adds to), which is overridden by the flags given.
+// Every problem found is returned rather than stopping at the first.
+func configLoad(flagValues map[string]string) (*Config, []string) {
+	var problems []string
+
+	environment, err := configEnvironment()
+	if err != nil {
+		problems = append(problems, ".env: " + err.Error())
+	}
+
+	fileValues, fileProblems := configReadFile(configFilePath(flagValues, environment))
+	problems = append(problems, fileProblems...)
+
+	values := map[string]string{}
+	for _, layer := range []map[string]string{ fileValues, environment, flagValues } {
+		layerProblems := configReadSecrets(layer)
+		problems = append(problems, layerProblems...)
+
+		for key, value := range layer {
+			values[key] = value
+		}
+	}
+
+	loaded, parseProblems := configParse(values)
+
+	return loaded, append(problems, parseProblems...)
+}
+
+func configFilePath(flagValues map[string]string, environment map[string]string) string {
+	if len(flagValues["CONFIG_FILE"]) > 0 {
+		return flagValues["CONFIG_FILE"]
+	}
+
+	if len(environment["CONFIG_FILE"]) > 0 {
+		return environment["CONFIG_FILE"]
+	}
+
+	for _, fileName := range configFileNames {
+		if fileExists(fileName) {
+			return fileName
+		}
+	}
+
+	return ""
+}
+
+// Keys are the setting names in any case, e.g. `db_type: sqlite`, and lists may be written as arrays
+func configReadFile(filePath string) (map[string]string, []string) {
+	values := map[string]string{}
+	if len(filePath) == 0 {
+		return values, nil
+	}
+
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return values, []string{ err.Error() }
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(filePath)) {
+		case ".yaml", ".yml":	err = yaml.Unmarshal(contents, &raw)
+		case ".toml":			err = toml.Unmarshal(contents, &raw)
+		default:				err = errors.New("must be a .yaml, .yml or .toml file")
+	}
+
+	if err != nil {
+		return values, []string{ filePath + ": " + err.Error() }
+	}
+
+	var problems []string
+	for key, value := range raw {
+		setting := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if !slices.Contains(configKeys(), setting) {
+			problems = append(problems, filePath + ": " + key + " is not a known setting")
+			continue
+		}
+
+		values[setting] = configFileValue(value)
+	}
+
+	return values, problems
+}
+
+func configFileValue(value any) string {
+	switch typed := value.(type) {
+		case nil:
+			return ""
+		case []any:
+			var items []string
+			for _, item := range typed {
+				items = append(items, configFileValue(item))
+			}
+
+			return strings.Join(items, ",")
+		case map[string]any:
+			// e.g. the `sources` definitions, which are JSON when given as an environment variable or flag
+			jsonBytes, err := json.Marshal(typed)
+			if err != nil {
+				panic(err)
+			}
+
+			return string(jsonBytes)
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Variables override the .env file, which is read afresh (rather than added to the environment) so a reload sees its changes.
+// Empty values count as unset, so an image can declare every setting without overriding the configuration file.
+func configEnvironment() (map[string]string, error) {
+	dotEnv, err := godotenv.Read()
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+
+	values := map[string]string{}
+	for _, key := range append(configKeys(), "CONFIG_FILE") {
+		if value := os.Getenv(key); len(value) > 0 {
+			values[key] = value
+		} else if len(dotEnv[key]) > 0 {
+			values[key] = dotEnv[key]
+		}
+	}
+
+	return values, err
+}
+
+// Replaces the `_FILE` settings with the contents of the files they name, without the trailing line break
+func configReadSecrets(values map[string]string) []string {
+	var problems []string
+	for _, secret := range configSecrets {
+		filePath, ok := values[secret + "_FILE"]
+		delete(values, secret + "_FILE")
+		if !ok || len(filePath) == 0 {
+			continue
+		}
+
+		if len(values[secret]) > 0 {
+			problems = append(problems, secret + "_FILE: only one of " + secret + " and " + secret + "_FILE may be set")
+			continue
+		}
+
+		contents, err := os.ReadFile(filePath)
+		if err != nil {
+			problems = append(problems, secret + "_FILE: " + err.Error())
+			continue
+		}
+
+		values[secret] = strings.TrimRight(string(contents), "\r\n")
+	}
+
+	return problems
+}
+
+func configParse(values map[string]string) (*Config, []string) {
+	parsed := NewConfig()
+
+	var problems []string
+	check := func(setting string, parse func(value string) error) bool {
+		value := strings.TrimSpace(values[setting])
+		if len(value) == 0 {
+			return true
+		}
+
+		if err := parse(value); err != nil {
+			problems = append(problems, setting + ": " + err.Error())
+			return false
+		}
+
+		return true
+	}
+
+	text := func(setting string, field *string) {
+		check(setting, func(value string) error {
+			*field = value
+			return nil
+		})
+	}
+
+	boolean := func(setting string, field *bool) {
+		check(setting, func(value string) error {
+			parsedBool, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.New("must be true or false")
+			}
+			*field = parsedBool
+
+			return nil
+		})
+	}
+
+	count := func(setting string, field *int, minimum int) {
+		check(setting, func(value string) error {
+			number, err := strconv.Atoi(value)
+			if err != nil || number < minimum {
+				return errors.New("must be a whole number of at least " + strconv.Itoa(minimum))
+			}
+			*field = number
+
+			return nil
+		})
+	}
+
+	port := func(setting string, field *int) {
+		check(setting, func(value string) error {
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 1 || number > 65535 {
+				return errors.New("must be a port number")
+			}
+			*field = number
+
+			return nil
+		})
+	}
+
+	parsed.values = values
+
+	text("SERVER_HOST", &parsed.ServerHost)
+	port("SERVER_PORT", &parsed.ServerPort)
+	text("API_KEY", &parsed.ApiKey)
+	text("ADMIN_API_KEY", &parsed.AdminApiKey)
+
+	text("DB_TYPE", &parsed.DbType)
+	if !slices.Contains([]string{ "postgres", "mysql", "sqlite", "mmdb" }, parsed.DbType) {
+		problems = append(problems, "DB_TYPE: must be one of postgres, mysql, sqlite or mmdb")
+	}
+
+	switch parsed.DbType {
+		case "postgres":	parsed.DbPort = 5432
+		case "mysql":		parsed.DbPort = 3306
+	}
+
+	text("DB_HOST", &parsed.DbHost)
+	port("DB_PORT", &parsed.DbPort)
+	text("DB_NAME", &parsed.DbName)
+	text("DB_SCHEMA", &parsed.DbSchema)
+	text("DB_USER", &parsed.DbUser)
+	text("DB_PASS", &parsed.DbPass)
+	text("DB_FILE", &parsed.DbFile)
+
+	if parsed.DbType == "sqlite" && len(parsed.DbFile) == 0 {
+		problems = append(problems, "DB_FILE: is required for sqlite")
+	}
+
+	if (parsed.DbType == "postgres" || parsed.DbType == "mysql") && len(parsed.DbHost) == 0 {
+		problems = append(problems, "DB_HOST: is required for " + parsed.DbType)
+	}
+
+	if sources := strings.TrimSpace(values["SOURCES"]); len(sources) > 0 {
+		var sourceProblems []string
+		parsed.Sources, sourceProblems = sourcesParse(sources)
+		problems = append(problems, sourceProblems...)
+	}
+
+	sources			:= availableWith(parsed.Sources)
+	countryValid	:= check("COUNTRY", func(value string) (err error) {
+		parsed.Country, err = datasetSourcesParse("COUNTRY", value, sources)
+		return err
+	})
+	check("CITY", func(value string) (err error) {
+		parsed.City, err = datasetSourcesParse("CITY", value, sources)
+		return err
+	})
+	check("ASN", func(value string) (err error) {
+		parsed.ASN, err = datasetSourcesParse("ASN", value, sources)
+		return err
+	})
+	check("REPUTATION", func(value string) (err error) {
+		parsed.Reputation, err = reputationSourcesParse(value)
+		return err
+	})
+	check("GEOFEED", func(value string) (err error) {
+		parsed.Geofeed, err = geofeedSourcesParse(value)
+		return err
+	})
+	boolean("COUNTRY_CONSENSUS", &parsed.CountryConsensus)
+
+	// Weights can only be checked against valid country sources
+	parsed.CountryConsensusTrust, _ = consensusTrustParse("", parsed.Country)
+	if countryValid {
+		check("COUNTRY_CONSENSUS_TRUST", func(value string) (err error) {
+			parsed.CountryConsensusTrust, err = consensusTrustParse(value, parsed.Country)
+			return err
+		})
+	}
+
+	check("UPDATE_TIME", func(value string) error {
+		if !regexp.MustCompile(`^([01]?[0-9]|2[0-3]):[0-5][0-9]$`).MatchString(value) {
+			return errors.New("must be a time of day, e.g. 01:30")
+		}
+		parsed.UpdateTime = value
+
+		return nil
+	})
+	count("LOAD_LOG_FREQ", &parsed.LoadLogFreq, 1)
+	count("RETAIN_VERSIONS", &parsed.RetainVersions, 0)
+	count("RETAIN_DAYS", &parsed.RetainDays, 0)
+	boolean("DIFF_REPORTS", &parsed.DiffReports)
+	text("DIFF_DIR", &parsed.DiffDir)
+
+	check("WEBHOOK_URL", func(value string) error {
+		parsed.WebhookUrl = configList(value)
+		return nil
+	})
+	text("WEBHOOK_SECRET", &parsed.WebhookSecret)
+	check("WEBHOOK_EVENTS", func(value string) error {
+		for _, event := range configList(value) {
+			if !slices.Contains(webhookEventNames, event) {
+				return errors.New(event + " is not one of " + strings.Join(webhookEventNames, ", "))
+			}
+		}
+		parsed.WebhookEvents = configList(value)
+
+		return nil
+	})
+	count("WEBHOOK_RETRIES", &parsed.WebhookRetries, 0)
+	text("EXPORT_DIR", &parsed.ExportDir)
+
+	boolean("REJECT_SPECIAL_ADDRESSES", &parsed.RejectSpecialAddresses)
+	boolean("TIMEZONE_INFER", &parsed.TimezoneInfer)
+	check("LOG_LEVEL", func(value string) error {
+		if !slices.Contains([]string{ "debug", "info", "warn", "warning", "error" }, strings.ToLower(value)) {
+			return errors.New("must be one of debug, info, warn or error")
+		}
+		parsed.LogLevel = value
+
+		return nil
+	})
+	check("LOG_FORMAT", func(value string) error {
+		if !slices.Contains([]string{ "text", "json" }, strings.ToLower(value)) {
+			return errors.New("must be text or json")
+		}
+		parsed.LogFormat = value
+
+		return nil
+	})
+
+	count("BENCHMARK_MAX_TIMES", &parsed.BenchmarkMaxTimes, 1)
+	count("BENCHMARK_MAX_CONCURRENCY", &parsed.BenchmarkMaxConcurrency, 1)
+	check("BENCHMARK_MAX_DURATION", func(value string) error {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return errors.New("must be a duration, e.g. 30s")
+		}
+		parsed.BenchmarkMaxDuration = duration
+
+		return nil
+	})
+	text("BENCHMARK_CORPUS_DIR", &parsed.BenchmarkCorpusDir)
+
+	text("TRACE_ENDPOINT", &parsed.TraceEndpoint)
+	boolean("TRACE_INSECURE", &parsed.TraceInsecure)
+	text("TRACE_SERVICE_NAME", &parsed.TraceServiceName)
+	check("TRACE_SAMPLE_RATIO", func(value string) error {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return errors.New("must be a number between 0 and 1")
+		}
+		parsed.TraceSampleRatio = ratio
+
+		return nil
+	})
+
+	return parsed, problems
+}
+
+// Comma separated values, ignoring empty ones
+func configList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Written before logging is set up, as the logging settings may be among the problems
+func configReport(problems []string) {
+	fmt.Fprintln(os.Stderr, "invalid configuration:")
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, "  " + problem)
+	}
+}
+
+// Reloads the settings (on SIGHUP or `POST /admin/reload`), applying what can change while serving and keeping the current values of the rest.
+// Nothing changes if the new settings have problems.
+func configReload() (*ConfigReload, []string) {
+	configReloadMutex.Lock()
+	defer configReloadMutex.Unlock()
+
+	current				:= config()
+	loaded, problems	:= configLoad(configFlags)
+	if len(problems) > 0 {
+		slog.Error("configuration not reloaded", "problems", problems)
+		return nil, problems
+	}
+
+	reload := NewConfigReload()
+	for _, setting := range configSettings {
+		if loaded.values[setting] == current.values[setting] {
+			continue
+		}
+
+		if slices.Contains(configRestartSettings, setting) {
+			slog.Warn("setting not reloaded, changing it requires a restart", "setting", setting)
+			reload.Rejected			= append(reload.Rejected, setting)
+			loaded.values[setting]	= current.values[setting]
+			continue
+		}
+
+		reload.Applied = append(reload.Applied, setting)
+	}
+
+	loaded.ServerHost, loaded.ServerPort, loaded.UpdateTime = current.ServerHost, current.ServerPort, current.UpdateTime
+	loaded.DbType, loaded.DbHost, loaded.DbPort, loaded.DbName = current.DbType, current.DbHost, current.DbPort, current.DbName
+	loaded.DbSchema, loaded.DbUser, loaded.DbPass, loaded.DbFile = current.DbSchema, current.DbUser, current.DbPass, current.DbFile
+	loaded.TraceEndpoint, loaded.TraceInsecure, loaded.TraceServiceName, loaded.TraceSampleRatio = current.TraceEndpoint, current.TraceInsecure, current.TraceServiceName, current.TraceSampleRatio
+
+	configCurrent.Store(loaded)
+
+	if slices.Contains(reload.Applied, "LOG_LEVEL") || slices.Contains(reload.Applied, "LOG_FORMAT") {
+		loggerInit(loggerOutput)
+	}
+
+	slog.Info("configuration reloaded", "applied", reload.Applied, "rejected", reload.Rejected)
+
+	changedDatasets := false
+	for _, setting := range configDatasetSettings {
+		changedDatasets = changedDatasets || slices.Contains(reload.Applied, setting)
+	}
+
+	if changedDatasets {
+		samplerReset()
+		compareReset()
+		reload.Loading = configReloadData(slices.Contains(reload.Applied, "COUNTRY_CONSENSUS_TRUST"))
+	}
+
+	return reload, nil
+}
+
+// Newly selected datasets are loaded straight away, as is the consensus when its weights change
+func configReloadData(trustChanged bool) []string {
+	_, missing := loadCheckInitialised()
+	if trustChanged && hasConsensusDatabase() && !slices.Contains(missing, "CONSENSUS") {
+		missing = append(missing, "CONSENSUS")
+	}
+
+	if len(missing) == 0 {
+		return []string{}
+	}
+
+	if !upgradeOrQueue(missing) {
+		slog.Info("an update is already running, the newly configured data source(s) will be loaded once it finishes", "missing", missing)
+		return missing
+	}
+
+	slog.Info("loading newly configured data source(s)", "missing", missing)
+
+	return missing
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigParseProblems(t *testing.T) {
+	tests := []struct {
+		values		map[string]string
+		expected	[]string
+	}{
+		{ map[string]string{}, nil },
+		{ map[string]string{ "SERVER_PORT": "http" }, []string{ "SERVER_PORT: must be a port number" } },
+		{ map[string]string{ "SERVER_PORT": "70000" }, []string{ "SERVER_PORT: must be a port number" } },
+		{ map[string]string{ "DB_TYPE": "oracle" }, []string{ "DB_TYPE: must be one of postgres, mysql, sqlite or mmdb" } },
+		{ map[string]string{ "DB_TYPE": "sqlite", "DB_FILE": "" }, []string{ "DB_FILE: is required for sqlite" } },
+		{ map[string]string{ "DB_TYPE": "postgres" }, []string{ "DB_HOST: is required for postgres" } },
+		{ map[string]string{ "DB_TYPE": "mysql", "DB_HOST": "db", "DB_PORT": "0" }, []string{ "DB_PORT: must be a port number" } },
+		{ map[string]string{ "COUNTRY": "dbip-city" }, []string{ "COUNTRY: dbip-city is not a valid COUNTRY option" } },
+		{ map[string]string{ "CITY": "dbip-city,nowhere" }, []string{ "CITY: nowhere is not a valid CITY option" } },
+		{ map[string]string{ "ASN": "dbip-country" }, []string{ "ASN: dbip-country is not a valid ASN option" } },
+		{ map[string]string{ "REPUTATION": "tor-exit,unknown" }, []string{ "REPUTATION: unknown is not a valid REPUTATION option" } },
+		{ map[string]string{ "REPUTATION": "proxy:mine=list.txt" }, []string{ "REPUTATION: proxy:mine=list.txt is not a valid REPUTATION option (expected tor|vpn|hosting:name=file-or-url)" } },
+		{ map[string]string{ "GEOFEED": "=feed.csv" }, []string{ "GEOFEED: =feed.csv is not a valid GEOFEED option (expected file-or-url or name=file-or-url)" } },
+		{ map[string]string{ "COUNTRY_CONSENSUS": "maybe" }, []string{ "COUNTRY_CONSENSUS: must be true or false" } },
+		{ map[string]string{ "COUNTRY": "dbip-country", "COUNTRY_CONSENSUS_TRUST": "geolite2-country=2" }, []string{ "COUNTRY_CONSENSUS_TRUST: geolite2-country=2 is not a valid COUNTRY_CONSENSUS_TRUST option (expected country-source=weight for a source in COUNTRY)" } },
+		{ map[string]string{ "COUNTRY": "dbip-country", "COUNTRY_CONSENSUS_TRUST": "dbip-country=-1" }, []string{ "COUNTRY_CONSENSUS_TRUST: dbip-country=-1 is not a valid COUNTRY_CONSENSUS_TRUST option (expected country-source=weight for a source in COUNTRY)" } },
+		// The weights aren't checked against an invalid COUNTRY
+		{ map[string]string{ "COUNTRY": "nowhere", "COUNTRY_CONSENSUS_TRUST": "nowhere=2" }, []string{ "COUNTRY: nowhere is not a valid COUNTRY option" } },
+		{ map[string]string{ "UPDATE_TIME": "25:00" }, []string{ "UPDATE_TIME: must be a time of day, e.g. 01:30" } },
+		{ map[string]string{ "LOAD_LOG_FREQ": "0" }, []string{ "LOAD_LOG_FREQ: must be a whole number of at least 1" } },
+		{ map[string]string{ "RETAIN_VERSIONS": "-1" }, []string{ "RETAIN_VERSIONS: must be a whole number of at least 0" } },
+		{ map[string]string{ "RETAIN_DAYS": "a week" }, []string{ "RETAIN_DAYS: must be a whole number of at least 0" } },
+		{ map[string]string{ "DIFF_REPORTS": "yes" }, []string{ "DIFF_REPORTS: must be true or false" } },
+		{ map[string]string{ "WEBHOOK_EVENTS": "started,exploded" }, []string{ "WEBHOOK_EVENTS: exploded is not one of started, completed, failed, no_change" } },
+		{ map[string]string{ "WEBHOOK_RETRIES": "-2" }, []string{ "WEBHOOK_RETRIES: must be a whole number of at least 0" } },
+		{ map[string]string{ "REJECT_SPECIAL_ADDRESSES": "sometimes" }, []string{ "REJECT_SPECIAL_ADDRESSES: must be true or false" } },
+		{ map[string]string{ "TIMEZONE_INFER": "2" }, []string{ "TIMEZONE_INFER: must be true or false" } },
+		{ map[string]string{ "LOG_LEVEL": "loud" }, []string{ "LOG_LEVEL: must be one of debug, info, warn or error" } },
+		{ map[string]string{ "LOG_FORMAT": "xml" }, []string{ "LOG_FORMAT: must be text or json" } },
+		{ map[string]string{ "BENCHMARK_MAX_TIMES": "0" }, []string{ "BENCHMARK_MAX_TIMES: must be a whole number of at least 1" } },
+		{ map[string]string{ "BENCHMARK_MAX_CONCURRENCY": "many" }, []string{ "BENCHMARK_MAX_CONCURRENCY: must be a whole number of at least 1" } },
+		{ map[string]string{ "BENCHMARK_MAX_DURATION": "-5s" }, []string{ "BENCHMARK_MAX_DURATION: must be a duration, e.g. 30s" } },
+		{ map[string]string{ "TRACE_INSECURE": "no way" }, []string{ "TRACE_INSECURE: must be true or false" } },
+		{ map[string]string{ "TRACE_SAMPLE_RATIO": "1.5" }, []string{ "TRACE_SAMPLE_RATIO: must be a number between 0 and 1" } },
+		{ map[string]string{ "SOURCES": "[]" }, []string{ "SOURCES: must map each source name to its options" } },
+		// Every problem is reported, in the order of the settings
+		{
+			map[string]string{ "SERVER_PORT": "0", "LOG_LEVEL": "loud", "COUNTRY": "nowhere", "TRACE_SAMPLE_RATIO": "2" },
+			[]string{ "SERVER_PORT: must be a port number", "COUNTRY: nowhere is not a valid COUNTRY option", "LOG_LEVEL: must be one of debug, info, warn or error", "TRACE_SAMPLE_RATIO: must be a number between 0 and 1" },
+		},
+	}
+
+	for _, test := range tests {
+		values := map[string]string{ "DB_TYPE": "sqlite", "DB_FILE": "ip.db" }
+		for key, value := range test.values {
+			values[key] = value
+		}
+
+		_, problems := configParse(values)
+		if !reflect.DeepEqual(problems, test.expected) {
+			t.Errorf("%v:\n got      %q\n expected %q", test.values, problems, test.expected)
+		}
+	}
+}
+
+func TestSourcesParseProblems(t *testing.T) {
+	valid := `"type": "country", "url": "https://example.com/country.csv"`
+
+	tests := []struct {
+		setting		string
+		expected	[]string
+	}{
+		{ `{ "mine": { ` + valid + ` } }`, nil },
+		{ `{ "mine": { "type": "city", "url": "https://example.com/city-ipv{ip_version}.tsv.gz", "compression": "gz", "delimiter": "\t", "header": true,
+			"columns": { "ip_range_start": "from", "ip_range_end": 1, "country_code": 2, "city.de": "stadt" } } }`, nil },
+		{ `["mine"]`, []string{ "SOURCES: must map each source name to its options" } },
+		{ `{ "Mine": { ` + valid + ` } }`, []string{ "SOURCES: Mine: names may only contain lowercase letters, digits, dots, dashes and underscores" } },
+		{ `{ "dbip-country": { ` + valid + ` } }`, []string{ "SOURCES: dbip-country: is already used by a built-in source" } },
+		{ `{ "geofeed": { ` + valid + ` } }`, []string{ "SOURCES: geofeed: is already used by a built-in source" } },
+		{ `{ "mine": { ` + valid + `, "format": "csv" } }`, []string{ "SOURCES: mine: format is not one of " + "type, url, compression, delimiter, header, columns, licenses" } },
+		{ `{ "mine": { "type": 1, "url": "https://example.com/country.csv" } }`, []string{ "SOURCES: mine: type must be text" } },
+		{ `{ "mine": { "type": "region", "url": "https://example.com/country.csv" } }`, []string{ "SOURCES: mine: type must be country, city or asn" } },
+		{ `{ "mine": { "type": "country", "url": "ftp://example.com/country.csv" } }`, []string{ "SOURCES: mine: url must be an http(s) URL, with {ip_version} in place of 4 or 6 when there's a file per IP version" } },
+		{ `{ "mine": { ` + valid + `, "compression": "zip" } }`, []string{ "SOURCES: mine: compression must be none or gz" } },
+		{ `{ "mine": { ` + valid + `, "delimiter": ";;" } }`, []string{ `SOURCES: mine: delimiter must be a single character, e.g. "," or "\t"` } },
+		{ `{ "mine": { ` + valid + `, "header": "yes" } }`, []string{ "SOURCES: mine: header must be true or false" } },
+		{ `{ "mine": { ` + valid + `, "licenses": "LICENSE.txt" } }`, []string{ "SOURCES: mine: licenses must be a list of file names" } },
+		{ `{ "mine": { ` + valid + `, "licenses": [ "LICENSE.txt", 2 ] } }`, []string{ "SOURCES: mine: licenses must be a list of file names" } },
+		{ `{ "mine": { ` + valid + `, "licenses": [ "" ] } }`, []string{ "SOURCES: mine: licenses must be a list of file names" } },
+		{ `{ "mine": { ` + valid + `, "columns": [ 0, 1, 2 ] } }`, []string{ "SOURCES: mine: columns must map fields to column numbers or names" } },
+		{ `{ "mine": { ` + valid + `, "columns": { "city": 3 } } }`, []string{ "SOURCES: mine: city is not a country field, expected one of ip_range_start, ip_range_end, country_code" } },
+		{ `{ "mine": { ` + valid + `, "columns": { "ip_range_start": -1 } } }`, []string{ "SOURCES: mine: the ip_range_start column number must be a whole number of at least 0" } },
+		{ `{ "mine": { ` + valid + `, "columns": { "ip_range_start": "start" } } }`, []string{ "SOURCES: mine: the ip_range_start column can only be named when header is true" } },
+		{ `{ "mine": { ` + valid + `, "columns": { "ip_range_start": true } } }`, []string{ "SOURCES: mine: the ip_range_start column must be a number or name" } },
+		{ `{ "mine": { ` + valid + `, "columns": { "ip_range_start": 0, "ip_range_end": 1 } } }`, []string{ "SOURCES: mine: columns must include country_code" } },
+		{ `{ "mine": { "type": "asn", "url": "https://example.com/asn.csv", "columns": { "ip_range_start": 0, "ip_range_end": 1, "country_code": 2 } } }`, []string{ "SOURCES: mine: country_code is not a asn field, expected one of ip_range_start, ip_range_end, as_number, as_organisation" } },
+		// Every invalid source is reported, in name order
+		{ `{ "b": { "type": "asn" }, "a": { "type": "city" }, "c": { ` + valid + ` } }`, []string{
+			"SOURCES: a: url must be an http(s) URL, with {ip_version} in place of 4 or 6 when there's a file per IP version",
+			"SOURCES: b: url must be an http(s) URL, with {ip_version} in place of 4 or 6 when there's a file per IP version",
+		} },
+	}
+
+	for _, test := range tests {
+		_, problems := sourcesParse(test.setting)
+		if !reflect.DeepEqual(problems, test.expected) {
+			t.Errorf("%s:\n got      %q\n expected %q", test.setting, problems, test.expected)
+		}
+	}
+}
+
+func TestSourcesParseLicenses(t *testing.T) {
+	sources, problems := sourcesParse(`{
+		"licensed": { "type": "country", "url": "https://example.com/country.csv", "licenses": [ "LICENSE.txt", " EULA.txt " ] },
+		"unlicensed": { "type": "country", "url": "https://example.com/country.csv" }
+	}`)
+	if len(problems) > 0 {
+		t.Fatalf("unexpected problems: %q", problems)
+	}
+
+	if !reflect.DeepEqual(sources["licensed"].Licenses, []string{ "LICENSE.txt", "EULA.txt" }) {
+		t.Errorf("licensed: got %q", sources["licensed"].Licenses)
+	}
+
+	if !reflect.DeepEqual(sources["unlicensed"].Licenses, []string{}) {
+		t.Errorf("unlicensed: got %q", sources["unlicensed"].Licenses)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Starts or ends one source's answer for a range during the vote
+type consensusEvent struct {
+	position	*big.Int
+	source		string
+	countryCode	string
+}
+
+func hasConsensusDatabase() bool {
+	return config().CountryConsensus && hasCountryDatabase()
+}
+
+func consensusTrust() map[string]float64 {
+	return config().CountryConsensusTrust
+}
+
+// Comma separated `source=weight` pairs, any of the country `sources` left out has a weight of 1
+func consensusTrustParse(setting string, sources []string) (map[string]float64, error) {
+	trust := map[string]float64{}
+	for _, source := range sources {
+		trust[source] = 1
+	}
+
+	for _, value := range strings.Split(setting, ",") {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+
+		source, weightString, ok := strings.Cut(value, "=")
+		weight, err := strconv.ParseFloat(strings.TrimSpace(weightString), 64)
+		if _, configured := trust[strings.TrimSpace(source)]; !ok || err != nil || weight < 0 || !configured {
+			return nil, errors.New(value + " is not a valid COUNTRY_CONSENSUS_TRUST option (expected country-source=weight for a source in COUNTRY)")
+		}
+
+		trust[strings.TrimSpace(source)] = weight
+	}
+
+	return trust, nil
+}
+
+// Recalculated after any country source is loaded, always after the sources themselves
+func consensusDataToLoad(dataToLoad []DataToLoad, missing []string) []DataToLoad {
+	if !hasConsensusDatabase() {
+		return []DataToLoad{}
+	}
+
+	changed := slices.Contains(missing, "CONSENSUS")
+	for _, item := range dataToLoad {
+		changed = changed || item.Download.Type == "COUNTRY"
+	}
+
+	if !changed {
+		return []DataToLoad{}
+	}
+
+	download := Download{ "consensus", "", "CONSENSUS", "", []string{}, nil }
+
+	return []DataToLoad{ DataToLoad{ download, "", 4 }, DataToLoad{ download, "", 6 } }
+}
+
+func loadConsensus(ctx context.Context, dataToLoad DataToLoad) {
+	ctx, span := traceLoad(ctx, "ip_consensus", dataToLoad)
+	defer span.End()
+
+	version		:= dbQueryMaxVersion("ip_consensus", dataToLoad.Version) + 1
+	progress	:= NewLoadProgress("ip_consensus", "", dataToLoad.Version, version)
+	slog.Info("rebuilding", "table", "ip_consensus", "ip_version", dataToLoad.Version, "db_version", version)
+
+	var events []consensusEvent
+	for _, source := range datasetSources("COUNTRY") {
+		for _, sampleRange := range dbSampleRanges("ip_country", source, dataToLoad.Version) {
+			events = append(events,
+				consensusEvent{ sampleRange.Start, source, sampleRange.Value },
+				consensusEvent{ new(big.Int).Add(sampleRange.Start, sampleRange.Size), source, "" },
+			)
+		}
+	}
+
+	consensuses := consensusVote(events, consensusTrust(), dataToLoad.Version, version)
+	for start := 0; start < len(consensuses); start += 100 {
+		batch := consensuses[start:min(start + 100, len(consensuses))]
+		loadBatch(ctx, "ip_consensus", batch, dbSaveConsensuses)
+		progress.Saved(len(batch))
+	}
+
+	progress.Complete()
+
+	dbDropOld("ip_consensus", "", dataToLoad.Version, version)
+}
+
+// Sweeps across every source's ranges, splitting them wherever any source's answer changes and voting on each piece
+func consensusVote(events []consensusEvent, trust map[string]float64, ipVersion int, dbVersion int) []IpConsensus {
+	// Ends sort before starts at the same position, as each source's ranges are contiguous
+	sort.SliceStable(events, func(i, j int) bool {
+		if compared := events[i].position.Cmp(events[j].position); compared != 0 {
+			return compared < 0
+		}
+
+		return len(events[i].countryCode) < len(events[j].countryCode)
+	})
+
+	total := 0.0
+	for _, weight := range trust {
+		total += weight
+	}
+
+	consensuses	:= []IpConsensus{}
+	active		:= map[string]string{}
+	var previous *big.Int
+	var previousEnd *big.Int
+
+	for i := 0; i < len(events); {
+		position := events[i].position
+
+		if previous != nil && len(active) > 0 && previous.Cmp(position) < 0 {
+			end			:= new(big.Int).Sub(position, big.NewInt(1))
+			consensus	:= consensusFromAnswers(active, trust, total)
+			consensus.IpRangeStart	= reputationIpString(previous, ipVersion)
+			consensus.IpRangeEnd	= reputationIpString(end, ipVersion)
+			consensus.IpVersion		= ipVersion
+			consensus.DbVersion		= dbVersion
+
+			// Adjacent ranges with the same outcome are combined
+			last := len(consensuses) - 1
+			if last >= 0 && consensusSameOutcome(consensuses[last], consensus) && new(big.Int).Add(previousEnd, big.NewInt(1)).Cmp(previous) == 0 {
+				consensuses[last].IpRangeEnd = consensus.IpRangeEnd
+			} else {
+				consensuses = append(consensuses, consensus)
+			}
+			previousEnd = end
+		}
+
+		for ; i < len(events) && events[i].position.Cmp(position) == 0; i++ {
+			if len(events[i].countryCode) > 0 {
+				active[events[i].source] = events[i].countryCode
+			} else {
+				delete(active, events[i].source)
+			}
+		}
+
+		previous = position
+	}
+
+	return consensuses
+}
+
+func consensusSameOutcome(a IpConsensus, b IpConsensus) bool {
+	return a.CountryCode == b.CountryCode && a.Confidence == b.Confidence && a.Dissent == b.Dissent
+}
+
+// Confidence is the winning share of the trust of every source, so sources without an answer count against it too
+func consensusFromAnswers(active map[string]string, trust map[string]float64, total float64) IpConsensus {
+	votes := map[string]float64{}
+	for source, countryCode := range active {
+		votes[countryCode] += trust[source]
+	}
+
+	// Ties go to the country of the highest priority source
+	var winner string
+	for _, source := range datasetSources("COUNTRY") {
+		countryCode, ok := active[source]
+		if ok && (len(winner) == 0 || votes[countryCode] > votes[winner]) {
+			winner = countryCode
+		}
+	}
+
+	var dissent []string
+	for _, source := range datasetSources("COUNTRY") {
+		if countryCode, ok := active[source]; ok && countryCode != winner {
+			dissent = append(dissent, source)
+		}
+	}
+
+	confidence := 0.0
+	if total > 0 {
+		confidence = math.Round(votes[winner] / total * 10000) / 10000
+	}
+
+	return IpConsensus{ CountryCode: winner, Confidence: confidence, Dissent: strings.Join(dissent, ",") }
+}
+
+// The voted country replaces the individual sources' answer, along with any city data placing the address elsewhere
+func (ip *Ip) enrichConsensus(ctx context.Context, address net.IP) {
+	if !hasConsensusDatabase() {
+		return
+	}
+
+	consensus, ok := dbConsensus(ctx, address)
+	if !ok || len(consensus.CountryCode) == 0 {
+		return
+	}
+
+	if len(ip.CountryCode) > 0 && ip.CountryCode != consensus.CountryCode {
+		ip.clearCity()
+	}
+
+	ip.CountryCode			= consensus.CountryCode
+	ip.Sources.Country		= "consensus"
+	ip.Confidence			= consensus.Confidence
+	ip.DissentingSources	= []string{}
+	if len(consensus.Dissent) > 0 {
+		ip.DissentingSources = strings.Split(consensus.Dissent, ",")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math/big"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+type consensusTestRange struct {
+	source		string
+	start		string
+	end			string
+	countryCode	string
+}
+
+// Events as loadConsensus builds them from each source's ranges
+func consensusTestEvents(ranges []consensusTestRange) []consensusEvent {
+	var events []consensusEvent
+	for _, testRange := range ranges {
+		sampleRange := NewSampleRangeFromStrings(testRange.start, testRange.end, testRange.countryCode)
+		events = append(events,
+			consensusEvent{ sampleRange.Start, testRange.source, sampleRange.Value },
+			consensusEvent{ new(big.Int).Add(sampleRange.Start, sampleRange.Size), testRange.source, "" },
+		)
+	}
+
+	return events
+}
+
+func TestConsensusVote(t *testing.T) {
+	tests := []struct {
+		name		string
+		ipVersion	int
+		sources		[]string
+		trust		map[string]float64
+		ranges		[]consensusTestRange
+		expected	[]IpConsensus
+	}{
+		{
+			"single source", 4, []string{ "a" }, map[string]float64{ "a": 1 },
+			[]consensusTestRange{
+				{ "a", "1.0.0.0", "1.0.0.255", "US" },
+				{ "a", "1.0.1.0", "1.0.1.255", "US" },
+				{ "a", "1.0.3.0", "1.0.3.255", "CA" },
+			},
+			[]IpConsensus{
+				// Adjacent ranges with the same answer are combined, gaps stay gaps
+				{ "1.0.0.0", "1.0.1.255", "US", 1, "", 4, 1 },
+				{ "1.0.3.0", "1.0.3.255", "CA", 1, "", 4, 1 },
+			},
+		},
+		{
+			"overlapping sources", 4, []string{ "a", "b", "c" }, map[string]float64{ "a": 1, "b": 1, "c": 1 },
+			[]consensusTestRange{
+				{ "a", "1.0.0.0", "1.0.0.255", "US" },
+				{ "b", "1.0.0.128", "1.0.1.255", "CA" },
+				{ "c", "1.0.0.0", "1.0.1.255", "US" },
+			},
+			[]IpConsensus{
+				{ "1.0.0.0", "1.0.0.127", "US", 0.6667, "", 4, 1 },
+				{ "1.0.0.128", "1.0.0.255", "US", 0.6667, "b", 4, 1 },
+				// A tie goes to the highest priority source with an answer
+				{ "1.0.1.0", "1.0.1.255", "CA", 0.3333, "c", 4, 1 },
+			},
+		},
+		{
+			"weighted sources", 4, []string{ "a", "b", "c" }, map[string]float64{ "a": 1, "b": 3, "c": 1 },
+			[]consensusTestRange{
+				{ "a", "2.0.0.0", "2.0.0.255", "FR" },
+				{ "b", "2.0.0.0", "2.0.0.255", "BE" },
+				{ "c", "2.0.0.0", "2.0.0.255", "FR" },
+			},
+			[]IpConsensus{
+				{ "2.0.0.0", "2.0.0.255", "BE", 0.6, "a,c", 4, 1 },
+			},
+		},
+		{
+			"tie on weight", 4, []string{ "b", "a" }, map[string]float64{ "a": 2, "b": 2 },
+			[]consensusTestRange{
+				{ "a", "3.0.0.0", "3.0.0.255", "GB" },
+				{ "b", "3.0.0.0", "3.0.0.255", "IE" },
+			},
+			[]IpConsensus{
+				{ "3.0.0.0", "3.0.0.255", "IE", 0.5, "a", 4, 1 },
+			},
+		},
+		{
+			"IPv6 up to the last address", 6, []string{ "a", "b" }, map[string]float64{ "a": 1, "b": 1 },
+			[]consensusTestRange{
+				{ "a", "8000::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "NL" },
+				{ "b", "fff0::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "DE" },
+			},
+			[]IpConsensus{
+				{ "8000::", "ffef:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "NL", 0.5, "", 6, 1 },
+				{ "fff0::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "NL", 0.5, "b", 6, 1 },
+			},
+		},
+		{
+			"IPv6 from the first address", 6, []string{ "a" }, map[string]float64{ "a": 1 },
+			[]consensusTestRange{
+				{ "a", "::", "::ffff", "ZZ" },
+			},
+			[]IpConsensus{
+				{ "::", "::ffff", "ZZ", 1, "", 6, 1 },
+			},
+		},
+		{
+			"no ranges", 4, []string{ "a" }, map[string]float64{ "a": 1 }, nil, []IpConsensus{},
+		},
+	}
+
+	for _, test := range tests {
+		testConfig := NewConfig()
+		testConfig.Country = test.sources
+		configCurrent.Store(testConfig)
+
+		consensuses := consensusVote(consensusTestEvents(test.ranges), test.trust, test.ipVersion, 1)
+		for i := range consensuses {
+			consensuses[i].IpRangeStart	= netip.MustParseAddr(consensuses[i].IpRangeStart).String()
+			consensuses[i].IpRangeEnd	= netip.MustParseAddr(consensuses[i].IpRangeEnd).String()
+		}
+
+		if !reflect.DeepEqual(consensuses, test.expected) {
+			t.Errorf("%s:\n got      %v\n expected %v", test.name, consensuses, test.expected)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"sort"
+	"strings"
+)
+
+//go:embed data/countries.csv
+var countriesCsv []byte
+
+var countries, countryCodes = countriesParse()
+
+var continentNames = map[string]string{
+	"AF": "Africa",
+	"AN": "Antarctica",
+	"AS": "Asia",
+	"EU": "Europe",
+	"NA": "North America",
+	"OC": "Oceania",
+	"SA": "South America",
+}
+
+func countriesParse() (map[string]Country, []string) {
+	records, err := csv.NewReader(bytes.NewReader(countriesCsv)).ReadAll()
+	if err != nil {
+		panic(err)
+	}
+
+	parsed	:= map[string]Country{}
+	codes	:= []string{}
+	for _, record := range records[1:] {
+		languages := []string{}
+		if len(record[6]) > 0 {
+			languages = strings.Split(record[6], ",")
+		}
+
+		parsed[record[0]] = Country{ record[0], record[1], record[2], continentNames[record[2]], record[3] == "1", record[4], record[5], languages }
+		codes = append(codes, record[0])
+	}
+	sort.Strings(codes)
+
+	return parsed, codes
+}
+
+func countryList() []Country {
+	list := []Country{}
+	for _, code := range countryCodes {
+		list = append(list, countries[code])
+	}
+
+	return list
+}
+
+// Fills in the reference data for whichever country the lookup resolved to
+func (ip *Ip) enrichCountry() {
+	country, ok := countries[strings.ToUpper(ip.CountryCode)]
+	if !ok {
+		return
+	}
+
+	ip.CountryName		= country.CountryName
+	ip.ContinentCode	= country.ContinentCode
+	ip.ContinentName	= country.ContinentName
+	ip.IsEu				= country.IsEu
+	ip.Currency			= country.Currency
+	ip.CallingCode		= country.CallingCode
+	ip.Languages		= country.Languages
+}
@@ -1,16 +1,17 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"embed"
 	"net"
-	"os"
 )
 
 //go:embed structure/*.sql
 var dbStructures embed.FS
 
 func dbConnect() {
-	switch os.Getenv("DB_TYPE") {
+	switch config().DbType {
 		case "postgres": 	postgresConnect()
 		case "mysql": 		mysqlConnect()
 		case "sqlite": 		sqliteConnect()
@@ -19,7 +20,7 @@ func dbConnect() {
 }
 
 func dbClose() {
-	switch os.Getenv("DB_TYPE") {
+	switch config().DbType {
 		case "postgres": 	postgresClose()
 		case "mysql": 		mysqlClose()
 		case "sqlite": 		sqliteClose()
@@ -27,39 +28,209 @@ func dbClose() {
 	}
 }
 
-func dbInitialised(key string) bool {
-	switch os.Getenv("DB_TYPE") {
-		case "postgres": 	return postgresInitialised(key)
-		case "mysql": 		return mysqlInitialised(key)
-		case "sqlite": 		return sqliteInitialised(key)
-		case "mmdb": 		return mmdbInitialised(key)
+// `source` is the dataset name for `COUNTRY`, `CITY` and `ASN`, which share a table per type
+func dbInitialised(key string, source string) bool {
+	switch config().DbType {
+		case "postgres": 	return postgresInitialised(key, source)
+		case "mysql": 		return mysqlInitialised(key, source)
+		case "sqlite": 		return sqliteInitialised(key, source)
+		case "mmdb": 		return mmdbInitialised(key, source)
 	}
 
 	return false
 }
 
-func dbIp(ip net.IP) *Ip {
-	switch os.Getenv("DB_TYPE") {
-		case "postgres":	return postgresIp(ip)
-		case "mysql": 		return mysqlIp(ip)
-		case "sqlite": 		return sqliteIp(ip)
-		case "mmdb":		return mmdbIp(ip)
+// Each type's sources are tried in order, falling through to the next one when a source has no match
+func dbIp(ctx context.Context, ip net.IP) *Ip {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	ipStruct	:= NewIp(ipString, ipVersion)
+
+	for _, source := range datasetSources("CITY") {
+		city, ok := dbCity(ctx, ip, source)
+		if !ok {
+			continue
+		}
+
+		// A city source without a city for the address may still know its country
+		if len(city.City) == 0 {
+			if len(ipStruct.CountryCode) == 0 && len(city.CountryCode) > 0 {
+				ipStruct.CountryCode		= city.CountryCode
+				ipStruct.Sources.Country	= source
+			}
+			continue
+		}
+
+		ipStruct.CountryCode		= city.CountryCode
+		ipStruct.State1				= city.State1
+		ipStruct.State2				= city.State2
+		ipStruct.City				= city.City
+		ipStruct.Postcode			= city.Postcode
+		ipStruct.Latitude			= city.Latitude
+		ipStruct.Longitude			= city.Longitude
+		ipStruct.Timezone			= city.Timezone
+		ipStruct.names				= city.Names
+		ipStruct.FoundCity			= true
+		ipStruct.Sources.City		= source
+		ipStruct.Sources.Country	= source
+		break
+	}
+
+	ipStruct.enrichConsensus(ctx, ip)
+
+	if len(ipStruct.CountryCode) == 0 {
+		for _, source := range datasetSources("COUNTRY") {
+			country, ok := dbCountry(ctx, ip, source)
+			if ok && len(country.CountryCode) > 0 {
+				ipStruct.CountryCode		= country.CountryCode
+				ipStruct.Sources.Country	= source
+				break
+			}
+		}
+	}
+
+	if len(ipStruct.CountryCode) > 0 {
+		ipStruct.FoundCountry = true
+	} else {
+		ipStruct.Sources.Country = ""
+	}
+
+	for _, source := range datasetSources("ASN") {
+		asn, ok := dbASN(ctx, ip, source)
+		if ok && (asn.AsNumber > 0 || len(asn.AsOrganisation) > 0) {
+			ipStruct.OrganisationNumber	= int64(asn.AsNumber)
+			ipStruct.OrganisationName	= asn.AsOrganisation
+			ipStruct.FoundASN			= true
+			ipStruct.Sources.ASN		= source
+			break
+		}
+	}
+
+	return ipStruct
+}
+
+func dbCity(ctx context.Context, ip net.IP, source string) (IpCity, bool) {
+	dbVersion, ok := versionLive(ctx, "ip_city", source, getIpVersion(ip.String()))
+	if !ok {
+		return IpCity{ Source: source }, false
+	}
+
+	switch config().DbType {
+		case "postgres":	return postgresCity(ctx, ip, source, dbVersion)
+		case "mysql": 		return mysqlCity(ctx, ip, source, dbVersion)
+		case "sqlite": 		return sqliteCity(ctx, ip, source, dbVersion)
+		case "mmdb":		return mmdbCity(ctx, ip, source, dbVersion)
+	}
+
+	return IpCity{}, false
+}
+
+func dbCountry(ctx context.Context, ip net.IP, source string) (IpCountry, bool) {
+	dbVersion, ok := versionLive(ctx, "ip_country", source, getIpVersion(ip.String()))
+	if !ok {
+		return IpCountry{ Source: source }, false
+	}
+
+	switch config().DbType {
+		case "postgres":	return postgresCountry(ctx, ip, source, dbVersion)
+		case "mysql": 		return mysqlCountry(ctx, ip, source, dbVersion)
+		case "sqlite": 		return sqliteCountry(ctx, ip, source, dbVersion)
+		case "mmdb":		return mmdbCountry(ctx, ip, source, dbVersion)
+	}
+
+	return IpCountry{}, false
+}
+
+func dbASN(ctx context.Context, ip net.IP, source string) (IpASN, bool) {
+	dbVersion, ok := versionLive(ctx, "ip_asn", source, getIpVersion(ip.String()))
+	if !ok {
+		return IpASN{ Source: source }, false
 	}
 
-	return NewIp("0.0.0.0", 4)
+	switch config().DbType {
+		case "postgres":	return postgresASN(ctx, ip, source, dbVersion)
+		case "mysql": 		return mysqlASN(ctx, ip, source, dbVersion)
+		case "sqlite": 		return sqliteASN(ctx, ip, source, dbVersion)
+		case "mmdb":		return mmdbASN(ctx, ip, source, dbVersion)
+	}
+
+	return IpASN{}, false
+}
+
+// Kept apart from `dbIp` as the lists are sparse and must also match the end of the range
+func dbReputation(ctx context.Context, ip net.IP) (IpReputation, bool) {
+	dbVersion, ok := versionLive(ctx, "ip_reputation", "", getIpVersion(ip.String()))
+	if !ok {
+		return IpReputation{}, false
+	}
+
+	switch config().DbType {
+		case "postgres":	return postgresReputation(ctx, ip, dbVersion)
+		case "mysql": 		return mysqlReputation(ctx, ip, dbVersion)
+		case "sqlite": 		return sqliteReputation(ctx, ip, dbVersion)
+		case "mmdb":		return mmdbReputation(ctx, ip, dbVersion)
+	}
+
+	return IpReputation{}, false
+}
+
+func dbGeofeed(ctx context.Context, ip net.IP) (IpGeofeed, bool) {
+	dbVersion, ok := versionLive(ctx, "ip_geofeed", "", getIpVersion(ip.String()))
+	if !ok {
+		return IpGeofeed{}, false
+	}
+
+	switch config().DbType {
+		case "postgres":	return postgresGeofeed(ctx, ip, dbVersion)
+		case "mysql": 		return mysqlGeofeed(ctx, ip, dbVersion)
+		case "sqlite": 		return sqliteGeofeed(ctx, ip, dbVersion)
+		case "mmdb":		return mmdbGeofeed(ctx, ip, dbVersion)
+	}
+
+	return IpGeofeed{}, false
 }
 
-func dbDropOld(table string, ipVersion int, dbVersion int) {
-	switch os.Getenv("DB_TYPE") {
-		case "postgres":	postgresDropOld(table, ipVersion, dbVersion)
-		case "mysql": 		mysqlDropOld(table, ipVersion, dbVersion)
-		case "sqlite": 		sqliteDropOld(table, ipVersion, dbVersion)
-		case "mmdb":		mmdbSaveRestart(table, ipVersion)
+func dbConsensus(ctx context.Context, ip net.IP) (IpConsensus, bool) {
+	dbVersion, ok := versionLive(ctx, "ip_consensus", "", getIpVersion(ip.String()))
+	if !ok {
+		return IpConsensus{}, false
+	}
+
+	switch config().DbType {
+		case "postgres":	return postgresConsensus(ctx, ip, dbVersion)
+		case "mysql": 		return mysqlConsensus(ctx, ip, dbVersion)
+		case "sqlite": 		return sqliteConsensus(ctx, ip, dbVersion)
+		case "mmdb":		return mmdbConsensus(ctx, ip, dbVersion)
 	}
+
+	return IpConsensus{}, false
+}
+
+// `source` limits the drop to one dataset source, it's empty for tables that only ever hold one. The new version goes live
+// first, then versions older than the retention settings keep are dropped
+func dbDropOld(table string, source string, ipVersion int, dbVersion int) {
+	previousVersion := versionCurrent(table, source, ipVersion)
+	if config().DbType == "mmdb" {
+		mmdbSaveRestart(table, source, ipVersion, previousVersion)
+	}
+
+	versionRecord(table, source, ipVersion, dbVersion)
+	diffVersions(table, source, ipVersion, previousVersion, dbVersion)
+	oldest := versionOldestRetained(table, source, ipVersion)
+
+	switch config().DbType {
+		case "postgres":	postgresDropOld(table, source, ipVersion, oldest)
+		case "mysql": 		mysqlDropOld(table, source, ipVersion, oldest)
+		case "sqlite": 		sqliteDropOld(table, source, ipVersion, oldest)
+		case "mmdb":		mmdbDropOld(table, source, ipVersion, oldest)
+	}
+
+	dbDeleteVersions(table, source, ipVersion, oldest)
+	versionsLoad()
 }
 
 func dbSaveCountries(countries []IpCountry) {
-	switch os.Getenv("DB_TYPE") {
+	switch config().DbType {
 		case "postgres":	postgresSaveCountries(countries)
 		case "mysql": 		mysqlSaveCountries(countries)
 		case "sqlite": 		sqliteSaveCountries(countries)
@@ -68,7 +239,7 @@ func dbSaveCountries(countries []IpCountry) {
 }
 
 func dbSaveASNs(ASNs []IpASN) {
-	switch os.Getenv("DB_TYPE") {
+	switch config().DbType {
 		case "postgres":	postgresSaveASNs(ASNs)
 		case "mysql": 		mysqlSaveASNs(ASNs)
 		case "sqlite": 		sqliteSaveASNs(ASNs)
@@ -77,7 +248,7 @@ func dbSaveASNs(ASNs []IpASN) {
 }
 
 func dbSaveCities(cities []IpCity) {
-	switch os.Getenv("DB_TYPE") {
+	switch config().DbType {
 		case "postgres":	postgresSaveCities(cities)
 		case "mysql": 		mysqlSaveCities(cities)
 		case "sqlite": 		sqliteSaveCities(cities)
@@ -85,20 +256,178 @@ func dbSaveCities(cities []IpCity) {
 	}
 }
 
+func dbSaveReputations(reputations []IpReputation) {
+	switch config().DbType {
+		case "postgres":	postgresSaveReputations(reputations)
+		case "mysql": 		mysqlSaveReputations(reputations)
+		case "sqlite": 		sqliteSaveReputations(reputations)
+		case "mmdb":		mmdbSaveReputations(reputations)
+	}
+}
+
+func dbSaveGeofeeds(geofeeds []IpGeofeed) {
+	switch config().DbType {
+		case "postgres":	postgresSaveGeofeeds(geofeeds)
+		case "mysql": 		mysqlSaveGeofeeds(geofeeds)
+		case "sqlite": 		sqliteSaveGeofeeds(geofeeds)
+		case "mmdb":		mmdbSaveGeofeeds(geofeeds)
+	}
+}
+
+func dbSaveConsensuses(consensuses []IpConsensus) {
+	switch config().DbType {
+		case "postgres":	postgresSaveConsensuses(consensuses)
+		case "mysql": 		mysqlSaveConsensuses(consensuses)
+		case "sqlite": 		sqliteSaveConsensuses(consensuses)
+		case "mmdb":		mmdbSaveConsensuses(consensuses)
+	}
+}
+
+// Only the latest version's ranges, earlier retained versions are only used for `as_of` lookups
+func dbSampleRanges(table string, source string, ipVersion int) []SampleRange {
+	dbVersion := versionCurrent(table, source, ipVersion)
+
+	switch config().DbType {
+		case "postgres":	return postgresSampleRanges(table, source, ipVersion, dbVersion)
+		case "mysql": 		return mysqlSampleRanges(table, source, ipVersion, dbVersion)
+		case "sqlite": 		return sqliteSampleRanges(table, source, ipVersion, dbVersion)
+		case "mmdb":		return mmdbSampleRanges(table, source, ipVersion, dbVersion)
+	}
+
+	return []SampleRange{}
+}
+
+// Every range of one version with its compared value, see `diffColumns`
+func dbDiffRanges(table string, source string, ipVersion int, dbVersion int) []SampleRange {
+	switch config().DbType {
+		case "postgres":	return postgresDiffRanges(table, source, ipVersion, dbVersion)
+		case "mysql": 		return mysqlDiffRanges(table, source, ipVersion, dbVersion)
+		case "sqlite": 		return sqliteDiffRanges(table, source, ipVersion, dbVersion)
+		case "mmdb":		return mmdbDiffRanges(table, source, ipVersion, dbVersion)
+	}
+
+	return []SampleRange{}
+}
+
+// The rows of a source's version are read in batches, see `exportColumns` for the SQL backends
+func dbExportCountries(source string, ipVersion int, dbVersion int, save func([]IpCountry)) {
+	read := func(rows *sql.Rows) { exportCountriesFromRows(rows, source, ipVersion, save) }
+
+	switch config().DbType {
+		case "postgres":	postgresExport("ip_country", source, ipVersion, dbVersion, read)
+		case "mysql": 		mysqlExport("ip_country", source, ipVersion, dbVersion, read)
+		case "sqlite": 		sqliteExport("ip_country", source, ipVersion, dbVersion, read)
+		case "mmdb":		mmdbExportCountries(source, ipVersion, dbVersion, save)
+	}
+}
+
+func dbExportASNs(source string, ipVersion int, dbVersion int, save func([]IpASN)) {
+	read := func(rows *sql.Rows) { exportASNsFromRows(rows, source, ipVersion, save) }
+
+	switch config().DbType {
+		case "postgres":	postgresExport("ip_asn", source, ipVersion, dbVersion, read)
+		case "mysql": 		mysqlExport("ip_asn", source, ipVersion, dbVersion, read)
+		case "sqlite": 		sqliteExport("ip_asn", source, ipVersion, dbVersion, read)
+		case "mmdb":		mmdbExportASNs(source, ipVersion, dbVersion, save)
+	}
+}
+
+func dbExportCities(source string, ipVersion int, dbVersion int, save func([]IpCity)) {
+	read := func(rows *sql.Rows) { exportCitiesFromRows(rows, source, ipVersion, save) }
+
+	switch config().DbType {
+		case "postgres":	postgresExport("ip_city", source, ipVersion, dbVersion, read)
+		case "mysql": 		mysqlExport("ip_city", source, ipVersion, dbVersion, read)
+		case "sqlite": 		sqliteExport("ip_city", source, ipVersion, dbVersion, read)
+		case "mmdb":		mmdbExportCities(source, ipVersion, dbVersion, save)
+	}
+}
+
 func dbFile() {
-	switch os.Getenv("DB_TYPE") {
+	switch config().DbType {
 		case "postgres":	postgresFile("structure/postgres.sql")
 		case "mysql": 		mysqlFile("structure/mysql.sql")
 		case "sqlite": 		sqliteFile("structure/sqlite.sql")
 	}
 }
 
+// Brings tables created by earlier versions up to date (`CREATE TABLE IF NOT EXISTS` won't)
+func dbMigrate() {
+	switch config().DbType {
+		case "postgres":	postgresMigrate()
+		case "mysql": 		mysqlMigrate()
+		case "sqlite": 		sqliteMigrate()
+	}
+}
+
 func dbQueryMaxVersion(table string, ipVersion int) int {
-	switch os.Getenv("DB_TYPE") {
+	switch config().DbType {
 		case "postgres":	return postgresQueryMaxVersion(table, ipVersion)
 		case "mysql": 		return mysqlQueryMaxVersion(table, ipVersion)
 		case "sqlite": 		return sqliteQueryMaxVersion(table, ipVersion)
+		case "mmdb":		return mmdbQueryMaxVersion(table, ipVersion)
 	}
 
 	return 0
+}
+
+func dbOverrides() []Override {
+	switch config().DbType {
+		case "postgres":	return postgresOverrides()
+		case "mysql": 		return mysqlOverrides()
+		case "sqlite": 		return sqliteOverrides()
+		case "mmdb":		return mmdbOverrides()
+	}
+
+	return []Override{}
+}
+
+func dbSaveOverride(override Override) Override {
+	switch config().DbType {
+		case "postgres":	return postgresSaveOverride(override)
+		case "mysql": 		return mysqlSaveOverride(override)
+		case "sqlite": 		return sqliteSaveOverride(override)
+		case "mmdb":		return mmdbSaveOverride(override)
+	}
+
+	return override
+}
+
+func dbDeleteOverride(id int64) {
+	switch config().DbType {
+		case "postgres":	postgresDeleteOverride(id)
+		case "mysql": 		mysqlDeleteOverride(id)
+		case "sqlite": 		sqliteDeleteOverride(id)
+		case "mmdb":		mmdbDeleteOverride(id)
+	}
+}
+
+func dbVersions() []DatasetVersion {
+	switch config().DbType {
+		case "postgres":	return postgresVersions()
+		case "mysql": 		return mysqlVersions()
+		case "sqlite": 		return sqliteVersions()
+		case "mmdb":		return mmdbVersions()
+	}
+
+	return []DatasetVersion{}
+}
+
+func dbSaveVersion(version DatasetVersion) {
+	switch config().DbType {
+		case "postgres":	postgresSaveVersion(version)
+		case "mysql": 		mysqlSaveVersion(version)
+		case "sqlite": 		sqliteSaveVersion(version)
+		case "mmdb":		mmdbSaveVersion(version)
+	}
+}
+
+// Forgets the loads of versions below `dbVersion`, once their data has been dropped
+func dbDeleteVersions(table string, source string, ipVersion int, dbVersion int) {
+	switch config().DbType {
+		case "postgres":	postgresDeleteVersions(table, source, ipVersion, dbVersion)
+		case "mysql": 		mysqlDeleteVersions(table, source, ipVersion, dbVersion)
+		case "sqlite": 		sqliteDeleteVersions(table, source, ipVersion, dbVersion)
+		case "mmdb":		mmdbDeleteVersions(table, source, ipVersion, dbVersion)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"math/big"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Only the first changed ranges are listed in a report, the counts cover all of them
+const diffMaxChanges = 1000
+
+// The latest report for each dataset and IP version, made whenever a new version replaces an earlier one
+var diffReports	= map[string]map[int]DiffReport{}
+var diffMutex	sync.RWMutex
+
+// Starts or ends a range of the previous or new version during the comparison
+type diffEvent struct {
+	position	*big.Int
+	previous	bool
+	value		string
+}
+
+func hasDiffReports() bool {
+	return config().DiffReports
+}
+
+// Columns making up the compared value, reputation lists have no location or ASN to compare
+func diffColumns(table string) []string {
+	switch table {
+		case "ip_city", "ip_geofeed":			return []string{ "country_code", "city" }
+		case "ip_country", "ip_consensus":		return []string{ "country_code" }
+		case "ip_asn":							return []string{ "as_number" }
+	}
+
+	return []string{}
+}
+
+// Compares the new version with the one it replaces, before that is dropped
+func diffVersions(table string, source string, ipVersion int, previousVersion int, dbVersion int) {
+	if !hasDiffReports() || previousVersion == 0 || len(diffColumns(table)) == 0 {
+		return
+	}
+
+	dataset := datasetName(table, source)
+	slog.Info("comparing versions", "dataset", dataset, "table", table, "ip_version", ipVersion, "from_version", previousVersion, "to_version", dbVersion)
+
+	events := diffEvents(nil, dbDiffRanges(table, source, ipVersion, previousVersion), true)
+	events = diffEvents(events, dbDiffRanges(table, source, ipVersion, dbVersion), false)
+
+	report := NewDiffReport(dataset, table, ipVersion, previousVersion, dbVersion)
+	for _, change := range diffSweep(events, ipVersion) {
+		report.add(change)
+	}
+	report.finish()
+
+	slog.Info("compared versions", "dataset", dataset, "ip_version", ipVersion, "ranges_changed", report.RangesChanged, "addresses_changed", report.AddressesChanged)
+
+	diffMutex.Lock()
+	if _, ok := diffReports[dataset]; !ok {
+		diffReports[dataset] = map[int]DiffReport{}
+	}
+	diffReports[dataset][ipVersion] = *report
+	diffMutex.Unlock()
+
+	diffWrite(*report)
+}
+
+func diffEvents(events []diffEvent, ranges []SampleRange, previous bool) []diffEvent {
+	for _, diffRange := range ranges {
+		events = append(events,
+			diffEvent{ diffRange.Start, previous, diffRange.Value },
+			diffEvent{ new(big.Int).Add(diffRange.Start, diffRange.Size), previous, "" },
+		)
+	}
+
+	return events
+}
+
+// Sweeps across both versions' ranges, returning the pieces whose value differs (adjacent pieces with the same change combined)
+func diffSweep(events []diffEvent, ipVersion int) []DiffChange {
+	// Ends sort before starts at the same position, as the ranges within a version don't overlap
+	sort.SliceStable(events, func(i, j int) bool {
+		if compared := events[i].position.Cmp(events[j].position); compared != 0 {
+			return compared < 0
+		}
+
+		return len(events[i].value) < len(events[j].value)
+	})
+
+	var changes []DiffChange
+	var previousValue, newValue string
+	var position *big.Int
+	var lastEnd *big.Int
+
+	for i := 0; i < len(events); {
+		next := events[i].position
+
+		if position != nil && previousValue != newValue && position.Cmp(next) < 0 {
+			end := new(big.Int).Sub(next, big.NewInt(1))
+
+			last := len(changes) - 1
+			if last >= 0 && changes[last].from == previousValue && changes[last].to == newValue && new(big.Int).Add(lastEnd, big.NewInt(1)).Cmp(position) == 0 {
+				changes[last].IpRangeEnd	= reputationIpString(end, ipVersion)
+				changes[last].addresses		= new(big.Int).Add(changes[last].addresses, new(big.Int).Sub(next, position))
+			} else {
+				changes = append(changes, DiffChange{
+					IpRangeStart:	reputationIpString(position, ipVersion),
+					IpRangeEnd:		reputationIpString(end, ipVersion),
+					From:			diffDescribe(previousValue),
+					To:				diffDescribe(newValue),
+					from:			previousValue,
+					to:				newValue,
+					addresses:		new(big.Int).Sub(next, position),
+				})
+			}
+			lastEnd = end
+		}
+
+		for ; i < len(events) && events[i].position.Cmp(next) == 0; i++ {
+			if events[i].previous {
+				previousValue = events[i].value
+			} else {
+				newValue = events[i].value
+			}
+		}
+
+		position = next
+	}
+
+	return changes
+}
+
+// City values are stored as `country|city`, shown the same way as in comparisons
+func diffDescribe(value string) string {
+	countryCode, city, ok := strings.Cut(value, "|")
+	if !ok || len(city) == 0 {
+		return countryCode
+	}
+
+	return city + ", " + countryCode
+}
+
+func (report *DiffReport) add(change DiffChange) {
+	report.RangesChanged++
+	report.addresses = new(big.Int).Add(report.addresses, change.addresses)
+
+	if len(report.Changes) < diffMaxChanges {
+		report.Changes = append(report.Changes, change)
+	} else {
+		report.Truncated = true
+	}
+
+	if report.Table == "ip_asn" {
+		report.AsnChanges++
+		return
+	}
+
+	fromCountry, fromCity, _	:= strings.Cut(change.from, "|")
+	toCountry, toCity, _		:= strings.Cut(change.to, "|")
+	if fromCity != toCity {
+		report.CityChanges++
+	}
+
+	if fromCountry != toCountry {
+		report.CountryChanges++
+
+		key := fromCountry + ">" + toCountry
+		pair, ok := report.pairs[key]
+		if !ok {
+			pair = &DiffPair{ From: fromCountry, To: toCountry, addresses: big.NewInt(0) }
+			report.pairs[key] = pair
+		}
+		pair.Ranges++
+		pair.addresses = new(big.Int).Add(pair.addresses, change.addresses)
+	}
+}
+
+// Address counts can exceed 64 bits for IPv6, so they're reported as strings
+func (report *DiffReport) finish() {
+	report.AddressesChanged = report.addresses.String()
+
+	for i := range report.Changes {
+		report.Changes[i].Addresses = report.Changes[i].addresses.String()
+	}
+
+	for _, pair := range report.pairs {
+		pair.Addresses = pair.addresses.String()
+		report.CountryPairs = append(report.CountryPairs, *pair)
+	}
+
+	sort.Slice(report.CountryPairs, func(i, j int) bool {
+		if report.CountryPairs[i].Ranges != report.CountryPairs[j].Ranges {
+			return report.CountryPairs[i].Ranges > report.CountryPairs[j].Ranges
+		}
+
+		return report.CountryPairs[i].From + report.CountryPairs[i].To < report.CountryPairs[j].From + report.CountryPairs[j].To
+	})
+}
+
+// Every report is also written to `DIFF_DIR` when it's set, named after the dataset and versions compared
+func diffWrite(report DiffReport) {
+	directory := config().DiffDir
+	if len(directory) == 0 {
+		return
+	}
+
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		panic(err)
+	}
+
+	content, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+
+	fileName := report.Dataset + "-ipv" + strconv.Itoa(report.IpVersion) + "-v" + strconv.Itoa(report.FromVersion) + "-v" + strconv.Itoa(report.ToVersion) + ".json"
+	fileWriteSmall(path.Join(directory, fileName), string(content))
+}
+
+func diffList(dataset string) []DiffReport {
+	diffMutex.RLock()
+	defer diffMutex.RUnlock()
+
+	reports := []DiffReport{}
+	for _, ipVersion := range []int{ 4, 6 } {
+		if report, ok := diffReports[dataset][ipVersion]; ok {
+			reports = append(reports, report)
+		}
+	}
+
+	return reports
+}
+
+// Ranges without a value are left out, so gaps and empty values compare the same
+func diffRangesFromRows(rows *sql.Rows, columns int) []SampleRange {
+	ranges := []SampleRange{}
+	for rows.Next() {
+		var ipRangeStart, ipRangeEnd string
+		values		:= make([]string, columns)
+		pointers	:= []any{ &ipRangeStart, &ipRangeEnd }
+		for i := range values {
+			pointers = append(pointers, &values[i])
+		}
+
+		err := rows.Scan(pointers...)
+		if err != nil {
+			panic(err)
+		}
+
+		value := strings.Join(values, "|")
+		if len(strings.Trim(value, "|")) > 0 && value != "0" {
+			ranges = append(ranges, NewSampleRangeFromStrings(ipRangeStart, ipRangeEnd, value))
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return ranges
+}
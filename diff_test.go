@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+type diffTestChange struct {
+	start		string
+	end			string
+	from		string
+	to			string
+	addresses	string
+}
+
+func diffTestRanges(ranges [][3]string) []SampleRange {
+	var sampleRanges []SampleRange
+	for _, diffRange := range ranges {
+		sampleRanges = append(sampleRanges, NewSampleRangeFromStrings(diffRange[0], diffRange[1], diffRange[2]))
+	}
+
+	return sampleRanges
+}
+
+func TestDiffSweep(t *testing.T) {
+	tests := []struct {
+		name		string
+		ipVersion	int
+		previous	[][3]string
+		new			[][3]string
+		expected	[]diffTestChange
+	}{
+		{
+			"added", 4,
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "US" } },
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "US" }, { "1.0.2.0", "1.0.2.255", "CA" } },
+			[]diffTestChange{ { "1.0.2.0", "1.0.2.255", "", "CA", "256" } },
+		},
+		{
+			"removed", 4,
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "US" }, { "1.0.2.0", "1.0.2.255", "CA" } },
+			[][3]string{ { "1.0.2.0", "1.0.2.255", "CA" } },
+			[]diffTestChange{ { "1.0.0.0", "1.0.0.255", "US", "", "256" } },
+		},
+		{
+			"changed", 4,
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "DE|Munich" } },
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "DE|Berlin" } },
+			[]diffTestChange{ { "1.0.0.0", "1.0.0.255", "Munich, DE", "Berlin, DE", "256" } },
+		},
+		{
+			"part of a range changed", 4,
+			[][3]string{ { "1.0.0.0", "1.0.1.255", "US" } },
+			[][3]string{ { "1.0.0.0", "1.0.0.127", "US" }, { "1.0.0.128", "1.0.0.255", "MX" }, { "1.0.1.0", "1.0.1.255", "US" } },
+			[]diffTestChange{ { "1.0.0.128", "1.0.0.255", "US", "MX", "128" } },
+		},
+		{
+			"touching ranges with the same change are combined", 4,
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "US" }, { "1.0.1.0", "1.0.1.255", "US" } },
+			[][3]string{ { "1.0.0.0", "1.0.1.255", "CA" } },
+			[]diffTestChange{ { "1.0.0.0", "1.0.1.255", "US", "CA", "512" } },
+		},
+		{
+			"touching ranges with different changes", 4,
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "US" }, { "1.0.1.0", "1.0.1.255", "GB" } },
+			[][3]string{ { "1.0.0.0", "1.0.1.255", "CA" } },
+			[]diffTestChange{ { "1.0.0.0", "1.0.0.255", "US", "CA", "256" }, { "1.0.1.0", "1.0.1.255", "GB", "CA", "256" } },
+		},
+		{
+			"split without a change", 4,
+			[][3]string{ { "1.0.0.0", "1.0.1.255", "US" } },
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "US" }, { "1.0.1.0", "1.0.1.255", "US" } },
+			nil,
+		},
+		{
+			"empty previous version", 4,
+			nil,
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "US" }, { "1.0.1.0", "1.0.1.255", "CA" } },
+			[]diffTestChange{ { "1.0.0.0", "1.0.0.255", "", "US", "256" }, { "1.0.1.0", "1.0.1.255", "", "CA", "256" } },
+		},
+		{
+			"empty new version", 4,
+			[][3]string{ { "1.0.0.0", "1.0.0.255", "US" } },
+			nil,
+			[]diffTestChange{ { "1.0.0.0", "1.0.0.255", "US", "", "256" } },
+		},
+		{
+			"both empty", 4, nil, nil, nil,
+		},
+		{
+			"IPv6 up to the last address", 6,
+			[][3]string{ { "8000::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "NL" } },
+			[][3]string{ { "8000::", "fffe:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "NL" }, { "ffff::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "DE" } },
+			[]diffTestChange{ { "ffff::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "NL", "DE", "5192296858534827628530496329220096" } },
+		},
+	}
+
+	for _, test := range tests {
+		events := diffEvents(nil, diffTestRanges(test.previous), true)
+		events = diffEvents(events, diffTestRanges(test.new), false)
+
+		var changes []diffTestChange
+		for _, change := range diffSweep(events, test.ipVersion) {
+			changes = append(changes, diffTestChange{
+				netip.MustParseAddr(change.IpRangeStart).String(),
+				netip.MustParseAddr(change.IpRangeEnd).String(),
+				change.From,
+				change.To,
+				change.addresses.String(),
+			})
+		}
+
+		if !reflect.DeepEqual(changes, test.expected) {
+			t.Errorf("%s:\n got      %v\n expected %v", test.name, changes, test.expected)
+		}
+	}
+}
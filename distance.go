@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Mean radius, as used by the haversine formula
+const earthRadiusKm = 6371.0088
+
+var compassPoints = []string{ "N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW" }
+
+// Location of an IP, only usable when the city data has coordinates for it
+func distancePointFromIp(ctx context.Context, ipString string) (DistancePoint, error) {
+	ipResult, err := fetchIP(ctx, ipString)
+	if err != nil {
+		return DistancePoint{}, err
+	}
+
+	if !ipResult.FoundCity || (ipResult.Latitude == 0 && ipResult.Longitude == 0) {
+		return DistancePoint{}, errors.New("no city level location is available for " + ipString)
+	}
+
+	return DistancePoint{ ipResult.IP, ipResult.Latitude, ipResult.Longitude, ipResult.City, ipResult.CountryCode }, nil
+}
+
+func distancePointFromStrings(latitude string, longitude string) (DistancePoint, error) {
+	if len(latitude) == 0 || len(longitude) == 0 {
+		return DistancePoint{}, errors.New("either a second IP or both lat and lon must be passed")
+	}
+
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return DistancePoint{}, errors.New("lat must be a number between -90 and 90")
+	}
+
+	lon, err := strconv.ParseFloat(longitude, 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return DistancePoint{}, errors.New("lon must be a number between -180 and 180")
+	}
+
+	return DistancePoint{ Latitude: lat, Longitude: lon }, nil
+}
+
+func distanceBetween(from DistancePoint, to DistancePoint) *Distance {
+	kilometres	:= haversine(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+	bearing		:= initialBearing(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+
+	return NewDistance(from, to, kilometres, bearing)
+}
+
+// Great-circle distance in kilometres
+func haversine(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	phi1		:= radians(lat1)
+	phi2		:= radians(lat2)
+	deltaPhi	:= radians(lat2 - lat1)
+	deltaLambda	:= radians(lon2 - lon1)
+
+	a := math.Sin(deltaPhi / 2) * math.Sin(deltaPhi / 2) + math.Cos(phi1) * math.Cos(phi2) * math.Sin(deltaLambda / 2) * math.Sin(deltaLambda / 2)
+
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1 - a))
+}
+
+// Initial (forward azimuth) bearing in degrees clockwise from north, 0 - 360
+func initialBearing(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	phi1		:= radians(lat1)
+	phi2		:= radians(lat2)
+	deltaLambda	:= radians(lon2 - lon1)
+
+	y := math.Sin(deltaLambda) * math.Cos(phi2)
+	x := math.Cos(phi1) * math.Sin(phi2) - math.Sin(phi1) * math.Cos(phi2) * math.Cos(deltaLambda)
+
+	return math.Mod(degrees(math.Atan2(y, x)) + 360, 360)
+}
+
+func compassPoint(bearing float64) string {
+	return compassPoints[int(math.Round(bearing / 22.5)) % len(compassPoints)]
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func degrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
@@ -1,34 +1,41 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"path"
 	"strings"
 	"golang.org/x/exp/slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var available = map[string]Download{
-	"asn-country":				Download{ "asn-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{} },
-	"dbip-country": 			Download{ "dbip-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "DBIP-LICENSE" } },
-	"geo-asn-country":			Download{ "geo-asn-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{} },
-	"geo-whois-asn-country":	Download{ "geo-whois-asn-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{} },
-	"geolite2-country":			Download{ "geolite2-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "GEOLITE2_LICENSE", "GEOLITE2_EULA" } },
-	"iptoasn-country":			Download{ "iptoasn-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{} },
-	"webnet77-country":			Download{ "webnet77-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "WEBNET77-LICENSE" } },
-
-	"dbip-city":				Download{ "dbip-city", "gz", "CITY", "https://unpkg.com/@ip-location-db/", []string{ "DBIP-LICENSE" } },
-	"geolite2-city":			Download{ "geolite2-city", "gz", "CITY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "GEOLITE2_LICENSE", "GEOLITE2_EULA" } },
-
-	"asn":						Download{ "asn", "csv", "ASN", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "ROUTEVIEWS-LICENSE", "DBIP-LICENSE" } },
-	"dbip-asn":					Download{ "dbip-asn", "csv", "ASN", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "DBIP-LICENSE" } },
-	"geolite2-asn":				Download{ "geolite2-asn", "csv", "ASN", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "GEOLITE2_LICENSE", "GEOLITE2_EULA" } },
-	"iptoasn-asn":				Download{ "iptoasn-asn", "csv", "ASN", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{} },
+	"asn-country":				Download{ "asn-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{}, nil },
+	"dbip-country": 			Download{ "dbip-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "DBIP-LICENSE" }, nil },
+	"geo-asn-country":			Download{ "geo-asn-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{}, nil },
+	"geo-whois-asn-country":	Download{ "geo-whois-asn-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{}, nil },
+	"geolite2-country":			Download{ "geolite2-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "GEOLITE2_LICENSE", "GEOLITE2_EULA" }, nil },
+	"iptoasn-country":			Download{ "iptoasn-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{}, nil },
+	"webnet77-country":			Download{ "webnet77-country", "csv", "COUNTRY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "WEBNET77-LICENSE" }, nil },
+
+	"dbip-city":				Download{ "dbip-city", "gz", "CITY", "https://unpkg.com/@ip-location-db/", []string{ "DBIP-LICENSE" }, nil },
+	"geolite2-city":			Download{ "geolite2-city", "gz", "CITY", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "GEOLITE2_LICENSE", "GEOLITE2_EULA" }, nil },
+
+	"asn":						Download{ "asn", "csv", "ASN", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "ROUTEVIEWS-LICENSE", "DBIP-LICENSE" }, nil },
+	"dbip-asn":					Download{ "dbip-asn", "csv", "ASN", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "DBIP-LICENSE" }, nil },
+	"geolite2-asn":				Download{ "geolite2-asn", "csv", "ASN", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{ "GEOLITE2_LICENSE", "GEOLITE2_EULA" }, nil },
+	"iptoasn-asn":				Download{ "iptoasn-asn", "csv", "ASN", "https://cdn.jsdelivr.net/npm/@ip-location-db/", []string{}, nil },
 }
 
-func downloadDataToLoad(missing []string) []DataToLoad {
+func downloadDataToLoad(ctx context.Context, missing []string) []DataToLoad {
 	downloadPath := "./downloads"
 	if _, err := os.Stat(downloadPath); os.IsNotExist(err) {
 		err := os.MkdirAll(downloadPath, 0755)
@@ -44,9 +51,14 @@ func downloadDataToLoad(missing []string) []DataToLoad {
 	downloads = downloadSelect("CITY", downloads, missing)
 	downloads = downloadSelect("ASN", downloads, missing)
 
-	fmt.Println("checking for new data...")
+	slog.Info("checking for new data")
 
 	for _, download := range downloads {
+		if download.Layout != nil {
+			dataToLoad = append(dataToLoad, sourceDataToLoad(ctx, download, downloadPath, missing)...)
+			continue
+		}
+
 		compression := "";
 		if download.Format == "gz" {
 			compression = ".gz"
@@ -66,42 +78,55 @@ func downloadDataToLoad(missing []string) []DataToLoad {
 			fileName := path.Base(url)
 			filePath := downloadPath + "/" + fileName
 
-			changed, err := downloadFile(filePath, url)
-			if err != nil {
-				panic(err)
-			}
-
-			loadPath := ""
-			if changed && compression != "" {
-				// New file that needs decompressing first
-				err := decompressFile(filePath, compression)
-				if err != nil {
-					panic(err)
-				}
-				loadPath = strings.Replace(filePath, compression, "", -1)
-			} else if changed {
-				// New file
-				loadPath = filePath
-			} else {
-				if len(missing) > 0 && slices.Contains(missing, download.Type) {
-					// Existing file, but our data hasn't been loaded, so re-process the old one
-					loadPath = filePath
-					if compression != "" {
-						loadPath = strings.Replace(filePath, compression, "", -1)
-					}
-				}
-			}
-
+			loadPath := downloadDataFile(ctx, download, url, filePath, compression, missing)
 			if loadPath != "" {
 				dataToLoad = append(dataToLoad, DataToLoad{ download, loadPath, ipVersion })
 			}
 		}
 	}
 
+	dataToLoad = append(dataToLoad, reputationDataToLoad(ctx, downloadPath, missing)...)
+	dataToLoad = append(dataToLoad, geofeedDataToLoad(ctx, downloadPath, missing)...)
+	dataToLoad = append(dataToLoad, consensusDataToLoad(dataToLoad, missing)...)
+
 	return dataToLoad
 }
 
-func downloadFile(filePath string, url string) (bool, error) {
+// Path of the file to load, empty when it's unchanged and already loaded
+func downloadDataFile(ctx context.Context, download Download, url string, filePath string, compression string, missing []string) string {
+	changed, err := downloadFile(ctx, filePath, url)
+	if err != nil {
+		panic(err)
+	}
+
+	loadPath := ""
+	if changed && compression != "" {
+		// New file that needs decompressing first
+		err := decompressFile(filePath, compression)
+		if err != nil {
+			panic(err)
+		}
+		loadPath = strings.Replace(filePath, compression, "", -1)
+	} else if changed {
+		// New file
+		loadPath = filePath
+	} else {
+		if len(missing) > 0 && slices.Contains(missing, download.Folder) {
+			// Existing file, but our data hasn't been loaded, so re-process the old one
+			loadPath = filePath
+			if compression != "" {
+				loadPath = strings.Replace(filePath, compression, "", -1)
+			}
+		}
+	}
+
+	return loadPath
+}
+
+func downloadFile(ctx context.Context, filePath string, url string) (bool, error) {
+	_, span := tracer.Start(ctx, "download", trace.WithAttributes(attribute.String("url.full", url)))
+	defer span.End()
+
 	etagFilePath := filePath + ".etag"
 
 	currentEtag := ""
@@ -109,15 +134,18 @@ func downloadFile(filePath string, url string) (bool, error) {
 		currentEtag = fileReadSmall(etagFilePath)
 	}
 
-	fmt.Println("downloading Etag: " + url)
+	slog.Debug("downloading etag", "url", url)
 	newEtag := getEtag(url)
 
 	if newEtag == "" || currentEtag != newEtag {
 		fileWriteSmall(etagFilePath, newEtag)
 
-		fmt.Println("downloading data file: " + url)
+		slog.Info("downloading data file", "url", url)
+		span.SetAttributes(attribute.Bool("download.changed", true))
+
 		resp, err := http.Get(url)
 		if err != nil {
+			span.RecordError(err)
 			return false, err
 		}
 		defer resp.Body.Close()
@@ -132,22 +160,79 @@ func downloadFile(filePath string, url string) (bool, error) {
 
 		return true, err
 	} else {
-		fmt.Println("Etag unchanged, skipping")
+		slog.Info("etag unchanged, skipping", "url", url)
 	}
 
 	return false, nil
 }
 
 func downloadSelect(name string, downloads []Download, missing []string) []Download {
-	value := os.Getenv(name)
-	if len(value) > 0 && (len(missing) == 0 || slices.Contains(missing, name)) {
-		download, ok := available[value]
-		if ok {
-			downloads = append(downloads, download)
-		} else {
-			panic(value + " is not a valid " + name + " option")
+	for _, source := range datasetSources(name) {
+		if len(missing) == 0 || slices.Contains(missing, source) {
+			downloads = append(downloads, availableSources()[source])
 		}
 	}
 
 	return downloads
 }
+
+// Ordered list of the configured sources for a dataset type
+func datasetSources(name string) []string {
+	switch name {
+		case "COUNTRY":	return config().Country
+		case "CITY":	return config().City
+		case "ASN":		return config().ASN
+	}
+
+	return nil
+}
+
+// The built-in sources and those defined in `SOURCES`
+func availableSources() map[string]Download {
+	return availableWith(config().Sources)
+}
+
+func availableWith(custom map[string]Download) map[string]Download {
+	sources := maps.Clone(available)
+	for name, download := range custom {
+		sources[name] = download
+	}
+
+	return sources
+}
+
+// Ordered, comma separated list of sources for a dataset type, e.g. `COUNTRY=geolite2-country,dbip-country`
+func datasetSourcesParse(name string, setting string, options map[string]Download) ([]string, error) {
+	var sources []string
+	for _, value := range strings.Split(setting, ",") {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+
+		download, ok := options[value]
+		if !ok || download.Type != name {
+			return nil, errors.New(value + " is not a valid " + name + " option")
+		}
+
+		if !slices.Contains(sources, value) {
+			sources = append(sources, value)
+		}
+	}
+
+	return sources, nil
+}
+
+// The dataset type stored in a table, e.g. `ip_city` holds `CITY` sources
+func datasetType(table string) string {
+	return strings.ToUpper(strings.TrimPrefix(table, "ip_"))
+}
+
+// The dataset source, or the table for the reputation lists, geofeeds and consensus (which are merged rather than having sources)
+func datasetName(table string, source string) string {
+	if len(source) > 0 {
+		return source
+	}
+
+	return strings.TrimPrefix(table, "ip_")
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Exported files hold a single version of the data
+const exportVersion = 1
+
+var exportFormats = []string{ "csv", "mmdb", "sqlite" }
+
+// Columns read after the range, in the order of the ip-location-db CSV files (plus the localised names for cities)
+func exportColumns(table string) []string {
+	switch table {
+		case "ip_city":		return []string{ "country_code", "state1", "state2", "city", "postcode", "latitude", "longitude", "timezone", "names" }
+		case "ip_asn":		return []string{ "as_number", "as_organisation" }
+	}
+
+	return []string{ "country_code" }
+}
+
+// `export <format> [source...]`, every configured country, city and ASN source is exported when none are listed
+func exportCommand(args []string) {
+	if len(args) == 0 {
+		slog.Error("usage: export <csv|mmdb|sqlite> [source...]")
+		os.Exit(1)
+	}
+
+	sources := args[1:]
+	if len(sources) == 0 {
+		for _, key := range []string{ "COUNTRY", "CITY", "ASN" } {
+			sources = append(sources, datasetSources(key)...)
+		}
+	}
+
+	for _, source := range sources {
+		filePaths, err := exportSource(args[0], source, []int{ 4, 6 }, config().ExportDir)
+		if err != nil {
+			slog.Error("export failed", "source", source, "error", err)
+			os.Exit(1)
+		}
+
+		slog.Info("exported", "source", source, "files", filePaths)
+	}
+}
+
+// Writes the version of a source currently served to `directory`, as CSV / MMDB files per IP version or a single SQLite file
+func exportSource(format string, source string, ipVersions []int, directory string) ([]string, error) {
+	if !slices.Contains(exportFormats, format) {
+		return nil, errors.New("format must be one of " + strings.Join(exportFormats, ", "))
+	}
+
+	download, ok := availableSources()[source]
+	if !ok {
+		return nil, errors.New(source + " is not a known dataset source")
+	}
+
+	if !dbInitialised(download.Type, source) {
+		return nil, errors.New(source + " has not been loaded")
+	}
+
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		panic(err)
+	}
+
+	table := "ip_" + strings.ToLower(download.Type)
+	slog.Info("exporting", "source", source, "format", format, "directory", directory)
+
+	var filePaths []string
+	var exportDb *sql.DB
+	if format == "sqlite" {
+		filePath := path.Join(directory, source + ".db")
+		exportDb = exportSqliteOpen(filePath)
+		defer exportDb.Close()
+
+		filePaths = append(filePaths, filePath)
+	}
+
+	for _, ipVersion := range ipVersions {
+		dbVersion := versionCurrent(table, source, ipVersion)
+
+		switch format {
+			case "csv":		filePaths = append(filePaths, exportCsv(table, source, ipVersion, dbVersion, directory))
+			case "mmdb":	filePaths = append(filePaths, exportMmdb(table, source, ipVersion, dbVersion, directory))
+			case "sqlite":	exportSqlite(exportDb, table, source, ipVersion, dbVersion)
+		}
+	}
+
+	return filePaths, nil
+}
+
+// No header row, as in the ip-location-db files
+func exportCsv(table string, source string, ipVersion int, dbVersion int, directory string) string {
+	filePath := path.Join(directory, source + "-ipv" + strconv.Itoa(ipVersion) + ".csv")
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	writer	:= csv.NewWriter(file)
+	write	:= func(record []string) {
+		err := writer.Write(record)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	switch table {
+		case "ip_country":
+			dbExportCountries(source, ipVersion, dbVersion, func(countries []IpCountry) {
+				for _, country := range countries {
+					write([]string{ country.IpRangeStart, country.IpRangeEnd, country.CountryCode })
+				}
+			})
+		case "ip_asn":
+			dbExportASNs(source, ipVersion, dbVersion, func(ASNs []IpASN) {
+				for _, asn := range ASNs {
+					write([]string{ asn.IpRangeStart, asn.IpRangeEnd, strconv.Itoa(asn.AsNumber), asn.AsOrganisation })
+				}
+			})
+		case "ip_city":
+			dbExportCities(source, ipVersion, dbVersion, func(cities []IpCity) {
+				for _, city := range cities {
+					write([]string{ city.IpRangeStart, city.IpRangeEnd, city.CountryCode, city.State1, city.State2, city.City, city.Postcode, strconv.FormatFloat(city.Latitude, 'f', -1, 64), strconv.FormatFloat(city.Longitude, 'f', -1, 64), city.Timezone })
+				}
+			})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		panic(err)
+	}
+
+	return filePath
+}
+
+// Built the same way as the files of the MMDB backend, so they can be used in its `downloads` folder
+func exportMmdb(table string, source string, ipVersion int, dbVersion int, directory string) string {
+	filePath := path.Join(directory, source + "-ipv" + strconv.Itoa(ipVersion) + ".mmdb")
+
+	recordSize := 24
+	if table == "ip_city" {
+		recordSize = 28
+	}
+	writer := mmdbNewWriter(source, ipVersion, recordSize)
+
+	switch table {
+		case "ip_country":	dbExportCountries(source, ipVersion, dbVersion, func(countries []IpCountry) { mmdbInsertCountries(writer, countries) })
+		case "ip_asn":		dbExportASNs(source, ipVersion, dbVersion, func(ASNs []IpASN) { mmdbInsertASNs(writer, ASNs) })
+		case "ip_city":		dbExportCities(source, ipVersion, dbVersion, func(cities []IpCity) { mmdbInsertCities(writer, cities) })
+	}
+
+	mmdbWriteFile(writer, filePath)
+
+	return filePath
+}
+
+// A new file with the SQLite backend's structure, so it can be used as its `DB_FILE`
+func exportSqliteOpen(filePath string) *sql.DB {
+	if fileExists(filePath) {
+		err := os.Remove(filePath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		panic(err)
+	}
+
+	sqliteStructure(db, "structure/sqlite.sql", "")
+
+	return db
+}
+
+func exportSqlite(db *sql.DB, table string, source string, ipVersion int, dbVersion int) {
+	switch table {
+		case "ip_country":	dbExportCountries(source, ipVersion, dbVersion, func(countries []IpCountry) { sqliteInsertCountries(db, "", countries) })
+		case "ip_asn":		dbExportASNs(source, ipVersion, dbVersion, func(ASNs []IpASN) { sqliteInsertASNs(db, "", ASNs) })
+		case "ip_city":		dbExportCities(source, ipVersion, dbVersion, func(cities []IpCity) { sqliteInsertCities(db, "", cities) })
+	}
+}
+
+func exportCountriesFromRows(rows *sql.Rows, source string, ipVersion int, save func([]IpCountry)) {
+	countries := []IpCountry{}
+	for rows.Next() {
+		country := IpCountry{ IpVersion: ipVersion, DbVersion: exportVersion, Source: source }
+		err := rows.Scan(&country.IpRangeStart, &country.IpRangeEnd, &country.CountryCode)
+		if err != nil {
+			panic(err)
+		}
+
+		countries = append(countries, country)
+		if len(countries) == 100 {
+			save(countries)
+			countries = []IpCountry{}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	if len(countries) > 0 {
+		save(countries)
+	}
+}
+
+func exportASNsFromRows(rows *sql.Rows, source string, ipVersion int, save func([]IpASN)) {
+	ASNs := []IpASN{}
+	for rows.Next() {
+		asn := IpASN{ IpVersion: ipVersion, DbVersion: exportVersion, Source: source }
+		err := rows.Scan(&asn.IpRangeStart, &asn.IpRangeEnd, &asn.AsNumber, &asn.AsOrganisation)
+		if err != nil {
+			panic(err)
+		}
+
+		ASNs = append(ASNs, asn)
+		if len(ASNs) == 100 {
+			save(ASNs)
+			ASNs = []IpASN{}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	if len(ASNs) > 0 {
+		save(ASNs)
+	}
+}
+
+func exportCitiesFromRows(rows *sql.Rows, source string, ipVersion int, save func([]IpCity)) {
+	cities := []IpCity{}
+	for rows.Next() {
+		var names string
+		city := IpCity{ IpVersion: ipVersion, DbVersion: exportVersion, Source: source }
+		err := rows.Scan(&city.IpRangeStart, &city.IpRangeEnd, &city.CountryCode, &city.State1, &city.State2, &city.City, &city.Postcode, &city.Latitude, &city.Longitude, &city.Timezone, &names)
+		if err != nil {
+			panic(err)
+		}
+		city.Names = parseLocalisedNames(names)
+
+		cities = append(cities, city)
+		if len(cities) == 100 {
+			save(cities)
+			cities = []IpCity{}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	if len(cities) > 0 {
+		save(cities)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// A geofeed entry read from the file, before more specific networks are cut out of it
+type geofeedEntry struct {
+	start	*big.Int
+	end		*big.Int
+	geofeed	IpGeofeed
+}
+
+func hasGeofeedDatabase() bool {
+	return len(config().Geofeed) > 0
+}
+
+func geofeedSources() []GeofeedSource {
+	return config().Geofeed
+}
+
+// Comma separated list of files / URLs, optionally named as `name=file-or-url`
+func geofeedSourcesParse(setting string) ([]GeofeedSource, error) {
+	var sources []GeofeedSource
+	for i, value := range strings.Split(setting, ",") {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+
+		name, location, named := strings.Cut(value, "=")
+		if !named || strings.Contains(name, "/") {
+			name, location = strconv.Itoa(i + 1), value
+		}
+
+		if len(name) == 0 || len(location) == 0 {
+			return nil, errors.New(value + " is not a valid GEOFEED option (expected file-or-url or name=file-or-url)")
+		}
+
+		sources = append(sources, GeofeedSource{ name, location })
+	}
+
+	return sources, nil
+}
+
+func (source GeofeedSource) isUrl() bool {
+	return strings.HasPrefix(source.Location, "http://") || strings.HasPrefix(source.Location, "https://")
+}
+
+func (source GeofeedSource) path(downloadPath string) string {
+	if source.isUrl() {
+		return downloadPath + "/geofeed-" + source.Name + ".csv"
+	}
+
+	return source.Location
+}
+
+// All feeds are merged into one table, so everything is reloaded if any one of them has changed
+func geofeedDataToLoad(ctx context.Context, downloadPath string, missing []string) []DataToLoad {
+	if !hasGeofeedDatabase() || (len(missing) > 0 && !slices.Contains(missing, "GEOFEED")) {
+		return []DataToLoad{}
+	}
+
+	changed := slices.Contains(missing, "GEOFEED")
+	for _, source := range geofeedSources() {
+		if source.isUrl() {
+			sourceChanged, err := downloadFile(ctx, source.path(downloadPath), source.Location)
+			if err != nil {
+				panic(err)
+			}
+			changed = changed || sourceChanged
+		} else {
+			changed = fileChanged(downloadPath + "/geofeed-" + source.Name + ".etag", source.Location) || changed
+		}
+	}
+
+	if !changed {
+		slog.Info("geofeeds unchanged, skipping")
+		return []DataToLoad{}
+	}
+
+	download := Download{ "geofeed", "csv", "GEOFEED", "", []string{}, nil }
+
+	return []DataToLoad{ DataToLoad{ download, downloadPath, 4 }, DataToLoad{ download, downloadPath, 6 } }
+}
+
+func loadGeofeeds(ctx context.Context, dataToLoad DataToLoad) {
+	ctx, span := traceLoad(ctx, "ip_geofeed", dataToLoad)
+	defer span.End()
+
+	version		:= dbQueryMaxVersion("ip_geofeed", dataToLoad.Version) + 1
+	progress	:= NewLoadProgress("ip_geofeed", "", dataToLoad.Version, version)
+	slog.Info("rebuilding", "table", "ip_geofeed", "ip_version", dataToLoad.Version, "db_version", version)
+
+	// The same network in more than one feed is taken from the first feed listed
+	var entries []geofeedEntry
+	seen := map[string]bool{}
+	for _, source := range geofeedSources() {
+		for prefix, entry := range geofeedRead(source, source.path(dataToLoad.Path), dataToLoad.Version) {
+			if !seen[prefix] {
+				seen[prefix] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	geofeeds := geofeedFlatten(entries, dataToLoad.Version, version)
+	for start := 0; start < len(geofeeds); start += 100 {
+		batch := geofeeds[start:min(start + 100, len(geofeeds))]
+		loadBatch(ctx, "ip_geofeed", batch, dbSaveGeofeeds)
+		progress.Saved(len(batch))
+	}
+
+	progress.Complete()
+
+	dbDropOld("ip_geofeed", "", dataToLoad.Version, version)
+}
+
+// RFC 8805: `ip_prefix,alpha2code,region,city,postal_code` with `#` comments, the (deprecated) postal code is ignored
+func geofeedRead(source GeofeedSource, filePath string, ipVersion int) map[string]geofeedEntry {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		panic(err)
+	}
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.Comment			= '#'
+	reader.FieldsPerRecord	= -1
+	reader.TrimLeadingSpace	= true
+
+	entries := map[string]geofeedEntry{}
+	skipped := 0
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			skipped++
+			continue
+		}
+
+		prefix, geofeed, err := geofeedParse(fields)
+		if err != nil {
+			slog.Debug("skipping geofeed entry", "name", source.Name, "entry", strings.Join(fields, ","), "error", err)
+			skipped++
+			continue
+		}
+
+		start, end, version, _ := reputationRange(prefix.String())
+		if version != ipVersion {
+			continue
+		}
+
+		// Later lines for the same network replace earlier ones
+		entries[prefix.String()] = geofeedEntry{ start, end, geofeed }
+	}
+
+	slog.Debug("read geofeed", "name", source.Name, "ip_version", ipVersion, "entries", len(entries), "skipped", skipped)
+	if skipped > 0 {
+		slog.Warn("geofeed contains invalid entries", "name", source.Name, "ip_version", ipVersion, "skipped", skipped)
+	}
+
+	return entries
+}
+
+func geofeedParse(fields []string) (netip.Prefix, IpGeofeed, error) {
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	prefix, err := netip.ParsePrefix(fields[0])
+	if err != nil {
+		return prefix, IpGeofeed{}, errors.New("ip_prefix must be a network in CIDR notation")
+	}
+	if prefix != prefix.Masked() {
+		return prefix, IpGeofeed{}, errors.New("ip_prefix must not have host bits set")
+	}
+	if prefix.Addr().Is4In6() {
+		return prefix, IpGeofeed{}, errors.New("ip_prefix must not be an IPv4-mapped network")
+	}
+
+	countryCode := strings.ToUpper(fields[1])
+	if _, ok := countries[countryCode]; len(countryCode) > 0 && !ok {
+		return prefix, IpGeofeed{}, errors.New("alpha2code must be an ISO 3166-1 alpha 2 code")
+	}
+
+	// ISO 3166-2 codes always start with the country code
+	region := strings.ToUpper(fields[2])
+	if len(region) > 0 && (len(countryCode) == 0 || !strings.HasPrefix(region, countryCode + "-") || len(region) < 4 || len(region) > 6) {
+		return prefix, IpGeofeed{}, errors.New("region must be an ISO 3166-2 code within alpha2code")
+	}
+
+	return prefix, IpGeofeed{ CountryCode: countryCode, State1: region, City: fields[3] }, nil
+}
+
+// Networks in a feed are either nested or disjoint, so the most specific one is kept where they overlap
+func geofeedFlatten(entries []geofeedEntry, ipVersion int, dbVersion int) []IpGeofeed {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if compared := entries[i].start.Cmp(entries[j].start); compared != 0 {
+			return compared < 0
+		}
+
+		return entries[i].end.Cmp(entries[j].end) > 0
+	})
+
+	geofeeds	:= []IpGeofeed{}
+	stack		:= []geofeedEntry{}
+	cursor		:= big.NewInt(0)
+
+	emit := func(end *big.Int, entry geofeedEntry) {
+		if cursor.Cmp(end) > 0 {
+			return
+		}
+
+		geofeed := entry.geofeed
+		geofeed.IpRangeStart	= reputationIpString(cursor, ipVersion)
+		geofeed.IpRangeEnd		= reputationIpString(end, ipVersion)
+		geofeed.IpVersion		= ipVersion
+		geofeed.DbVersion		= dbVersion
+		geofeeds = append(geofeeds, geofeed)
+	}
+
+	// Closes every open network that ends before `position` (or all of them if it's nil)
+	closeBefore := func(position *big.Int) {
+		for len(stack) > 0 && (position == nil || stack[len(stack) - 1].end.Cmp(position) < 0) {
+			top := stack[len(stack) - 1]
+			emit(top.end, top)
+			cursor = new(big.Int).Add(top.end, big.NewInt(1))
+			stack = stack[:len(stack) - 1]
+		}
+	}
+
+	for _, entry := range entries {
+		closeBefore(entry.start)
+
+		if len(stack) > 0 {
+			emit(new(big.Int).Sub(entry.start, big.NewInt(1)), stack[len(stack) - 1])
+		}
+
+		cursor	= entry.start
+		stack	= append(stack, entry)
+	}
+	closeBefore(nil)
+
+	return geofeeds
+}
+
+// Consulted before the datasets; where the feed places the address somewhere else, the dataset's finer details are dropped
+func (ip *Ip) enrichGeofeed(ctx context.Context, address netip.Addr) {
+	if !hasGeofeedDatabase() {
+		return
+	}
+
+	geofeed, ok := dbGeofeed(ctx, net.IP(address.AsSlice()))
+	if !ok {
+		return
+	}
+
+	ip.FoundGeofeed = true
+
+	moved := (len(geofeed.CountryCode) > 0 && geofeed.CountryCode != ip.CountryCode) || (len(geofeed.City) > 0 && !strings.EqualFold(geofeed.City, ip.City))
+	if moved {
+		ip.clearCity()
+	}
+
+	if len(geofeed.CountryCode) > 0 {
+		ip.CountryCode		= geofeed.CountryCode
+		ip.FoundCountry		= true
+		ip.Sources.Country	= "geofeed"
+	}
+
+	if len(geofeed.State1) > 0 && len(ip.State1) == 0 {
+		ip.State1 = geofeed.State1
+	}
+
+	if len(geofeed.City) > 0 && moved {
+		ip.City			= geofeed.City
+		ip.FoundCity	= true
+		ip.Sources.City	= "geofeed"
+	}
+}
+
+// Drops everything finer than the country, for when another layer places the address somewhere else
+func (ip *Ip) clearCity() {
+	ip.State1		= ""
+	ip.State2		= ""
+	ip.City			= ""
+	ip.Postcode		= ""
+	ip.Latitude		= 0
+	ip.Longitude	= 0
+	ip.Timezone		= ""
+	ip.FoundCity	= false
+	ip.names		= LocalisedNames{}
+	ip.Sources.City	= ""
+}
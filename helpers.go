@@ -2,14 +2,17 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"math/big"
 	"math/rand/v2"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
@@ -17,6 +20,8 @@ import (
 
 	"github.com/seancfoley/ipaddress-go/ipaddr"
 	"github.com/praserx/ipconv"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func decompressFile(filePath string, compression string) error {
@@ -38,15 +43,15 @@ func decompressFile(filePath string, compression string) error {
 	}
 	defer decompressedFile.Close()
 
-	fmt.Println("decompressing: " + filePath)
+	slog.Info("decompressing", "path", filePath)
 	buf := make([]byte, 1024)
 	for {
-		_, readErr := uncompressedStream.Read(buf)
+		read, readErr := uncompressedStream.Read(buf)
 		if readErr != nil && !errors.Is(readErr, io.EOF) {
 			panic(readErr)
 		}
 
-		_, writeErr := decompressedFile.Write(buf)
+		_, writeErr := decompressedFile.Write(buf[:read])
 		if writeErr != nil {
 			panic(writeErr);
 		}
@@ -102,27 +107,61 @@ func durationUntil(timeUntil string) time.Duration {
 	return duration
 }
 
-func fetchIP(ipString string) (*Ip, error) {
+func fetchIP(ctx context.Context, ipString string) (*Ip, error) {
 	start := time.Now()
 
-	ip := net.ParseIP(ipString)
-	if ip == nil || ip.IsPrivate() || ip.IsLoopback() {
-		return nil, errors.New("invalid IP address passed (" + ipString + "); private / loopback IP ranges are not processed")
+	ctx, span := tracer.Start(ctx, "fetchIP", trace.WithAttributes(attribute.String("ip", ipString)))
+	defer span.End()
+
+	address, err := netip.ParseAddr(ipString)
+	if err != nil || address.Zone() != "" {
+		return nil, errors.New("invalid IP address passed (" + ipString + ")")
 	}
 
-	IpResult := dbIp(ip)
+	// Transition addresses (e.g. `::ffff:1.2.3.4`) are looked up, and classified, by the IPv4 address they carry
+	effective, mechanism := addressEmbeddedIpv4(address)
+
+	addressType, isGlobal := addressClassify(effective)
+	if !isGlobal && rejectSpecialAddresses() {
+		return nil, errors.New("invalid IP address passed (" + ipString + "); special-purpose (" + addressType + ") IP ranges are not processed")
+	}
+
+	// There's no location data for addresses that aren't globally reachable, so only their type is returned
+	var IpResult *Ip
+	if isGlobal {
+		IpResult = dbIp(ctx, net.IP(effective.AsSlice()))
+		IpResult.enrichGeofeed(ctx, effective)
+		IpResult.enrichReputation(ctx, effective)
+	} else {
+		IpResult = NewIp(effective.String(), getIpVersion(effective.String()))
+	}
+	IpResult.IP						= ipString
+	IpResult.IPVersion				= getIpVersion(ipString)
+	IpResult.EffectiveIP			= effective.String()
+	IpResult.TransitionMechanism	= mechanism
+	IpResult.AddressType			= addressType
+	IpResult.IsGlobal				= isGlobal
+	if asOf, ok := versionAsOf(ctx); ok {
+		IpResult.AsOf = asOf.Format(time.RFC3339)
+	}
+	IpResult.applyOverride(address, effective)
+	IpResult.enrichCountry()
+	IpResult.inferTimezone()
+	IpResult.enrichTimezone(time.Now())
 	IpResult.Milliseconds = time.Now().Sub(start).Milliseconds()
 	IpResult.Microseconds = time.Now().Sub(start).Microseconds()
 
 	return IpResult, nil
 }
 
-func fetchIPJson(ipString string) ([]byte, error) {
-	ipResult, err := fetchIP(ipString)
+func fetchIPJson(ctx context.Context, ipString string, languages []string) ([]byte, error) {
+	ipResult, err := fetchIP(ctx, ipString)
 	if err != nil {
 		return nil, err
 	}
 
+	ipResult.localise(languages)
+
 	jsonResult, err := json.Marshal(ipResult)
 	if err != nil {
 		return nil, errors.New("system error")
@@ -162,6 +201,24 @@ func fileWriteSmall(filePath string, content string) {
 	}
 }
 
+// Local files have no etag, so their modification time / size is tracked instead
+func fileChanged(stampFilePath string, filePath string) bool {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		panic(err)
+	}
+
+	stamp := info.ModTime().UTC().Format(time.RFC3339Nano) + " " + strconv.FormatInt(info.Size(), 10)
+
+	if fileExists(stampFilePath) && fileReadSmall(stampFilePath) == stamp {
+		return false
+	}
+
+	fileWriteSmall(stampFilePath, stamp)
+
+	return true
+}
+
 func findIPRanges(ipRangeStart string, ipRangeEnd string) []*net.IPNet {
 	ipStart	:= ipaddr.NewIPAddressString(ipRangeStart)
 	ipEnd	:= ipaddr.NewIPAddressString(ipRangeEnd)
@@ -209,30 +266,16 @@ func getIpVersion(ipString string) int {
 	return ipVersion
 }
 
-func getLogFrequency() int {
-	loadLogFrequency := os.Getenv("LOAD_LOG_FREQ")
-	if len(loadLogFrequency) > 0 {
-		loadLogFrequencyInt, err := strconv.Atoi(loadLogFrequency)
-		if err != nil {
-			panic(err)
-		}
-
-		return loadLogFrequencyInt
-	}
-
-	return 1000
-}
-
 func hasASNDatabase() bool {
-	return len(os.Getenv("ASN")) > 0
+	return len(config().ASN) > 0
 }
 
 func hasCityDatabase() bool {
-	return len(os.Getenv("CITY")) > 0
+	return len(config().City) > 0
 }
 
 func hasCountryDatabase() bool {
-	return len(os.Getenv("COUNTRY")) > 0
+	return len(config().Country) > 0
 }
 
 func ipv4ToNumber(ipString string) int64 {
@@ -266,30 +309,6 @@ func ipv6ToNumber(ipString string) string {
 	return ""
 }
 
-func isIpv4Reserved(ip string) bool {
-	return strings.HasPrefix(ip, "0.") || strings.HasPrefix(ip, "127.") || strings.HasPrefix(ip, "10.") ||
-	strings.HasPrefix(ip, "100.64.") || strings.HasPrefix(ip, "169.254.") || strings.HasPrefix(ip, "172.16.") ||
-	strings.HasPrefix(ip, "172.17.") || strings.HasPrefix(ip, "172.18.") || strings.HasPrefix(ip, "172.19.") ||
-	strings.HasPrefix(ip, "172.20.") || strings.HasPrefix(ip, "172.21.") || strings.HasPrefix(ip, "172.22.") ||
-	strings.HasPrefix(ip, "172.23.") || strings.HasPrefix(ip, "172.24.") || strings.HasPrefix(ip, "172.25.") ||
-	strings.HasPrefix(ip, "172.26.") || strings.HasPrefix(ip, "172.27.") || strings.HasPrefix(ip, "172.28.") ||
-	strings.HasPrefix(ip, "172.29.") || strings.HasPrefix(ip, "172.30.") || strings.HasPrefix(ip, "172.31.") ||
-	strings.HasPrefix(ip, "192.0.0.") || strings.HasPrefix(ip, "192.0.2.") || strings.HasPrefix(ip, "192.88.") ||
-	strings.HasPrefix(ip, "192.168.") || strings.HasPrefix(ip, "198.18.") || strings.HasPrefix(ip, "198.19.") ||
-	strings.HasPrefix(ip, "198.51.100") || strings.HasPrefix(ip, "203.0.113") || strings.HasPrefix(ip, "224.") ||
-	strings.HasPrefix(ip, "225.") || strings.HasPrefix(ip, "226.") || strings.HasPrefix(ip, "227.") ||
-	strings.HasPrefix(ip, "228.") || strings.HasPrefix(ip, "229.") || strings.HasPrefix(ip, "230.") ||
-	strings.HasPrefix(ip, "231.") || strings.HasPrefix(ip, "232.") || strings.HasPrefix(ip, "233.") ||
-	strings.HasPrefix(ip, "234.") || strings.HasPrefix(ip, "235.") || strings.HasPrefix(ip, "236.") ||
-	strings.HasPrefix(ip, "237.") || strings.HasPrefix(ip, "238.") || strings.HasPrefix(ip, "239.") ||
-	strings.HasPrefix(ip, "240.") || strings.HasPrefix(ip, "241.") || strings.HasPrefix(ip, "242.") ||
-	strings.HasPrefix(ip, "243.") || strings.HasPrefix(ip, "244.") || strings.HasPrefix(ip, "245.") ||
-	strings.HasPrefix(ip, "246.") || strings.HasPrefix(ip, "247.") || strings.HasPrefix(ip, "248.") ||
-	strings.HasPrefix(ip, "249.") || strings.HasPrefix(ip, "250.") || strings.HasPrefix(ip, "251.") ||
-	strings.HasPrefix(ip, "252.") || strings.HasPrefix(ip, "253.") || strings.HasPrefix(ip, "254.") ||
-	strings.HasPrefix(ip, "255.")
-}
-
 func randomIpv4() string {
 	numbers := []int{ randomNumber(0, 255), randomNumber(0, 255), randomNumber(0, 255), randomNumber(0, 255) }
 	var parts []string
@@ -299,7 +318,7 @@ func randomIpv4() string {
 
 	ip := strings.Join(parts, ".")
 
-	if isIpv4Reserved(ip) {
+	if _, isGlobal := addressClassify(netip.MustParseAddr(ip)); !isGlobal {
 		return randomIpv4()
 	}
 
@@ -311,7 +330,7 @@ func randomIpv6() string {
 
 	first := []string{ "2001", "2002", "2003", "2400", "2401", "2402", "2403", "2404", "2405", "2406", "2407", "2408",
 	"2409", "240a", "2600", "2601", "2602", "2603", "2604", "2605", "2606", "2607", "2608", "2609", "2610", "2620",
-	"2800", "2801", "2802", "2803", "2804", "2806", "2a00", "2a01", "2a2", "2a03", "2a04", "2a05", "2a06", "2a07",
+	"2800", "2801", "2802", "2803", "2804", "2806", "2a00", "2a01", "2a02", "2a03", "2a04", "2a05", "2a06", "2a07",
 	"2a08", "2a09", "2a0a", "2a0b", "2a0c", "2a0d", "2a0e", "2a0f", "2a10", "2a11", "2a12", "2a13", "2a14", "2c0e",
 	"2c0f" }
 	pick := randomNumber(0, len(first))
@@ -330,9 +349,28 @@ func randomNumber(min, max int) int {
 	return rand.IntN(max-min) + min
 }
 
+// Admin routes are closed unless a key is configured; `ADMIN_API_KEY` falls back to `API_KEY`
+func validAdminKey(request *http.Request) bool {
+	key := adminApiKey()
+	if len(key) == 0 || request.Header.Get("API-KEY") != key {
+		return false
+	}
+
+	return true
+}
+
+func adminApiKey() string {
+	if len(config().AdminApiKey) > 0 {
+		return config().AdminApiKey
+	}
+
+	return config().ApiKey
+}
+
 func validApiKey(request *http.Request, enforceKey bool) bool {
-	if len(os.Getenv("API_KEY")) > 0 || enforceKey {
-		if len(os.Getenv("API_KEY")) == 0 || request.Header.Get("API-KEY") != os.Getenv("API_KEY") {
+	apiKey := config().ApiKey
+	if len(apiKey) > 0 || enforceKey {
+		if len(apiKey) == 0 || request.Header.Get("API-KEY") != apiKey {
 			return false
 		}
 	}
@@ -1,33 +1,52 @@
 package main
 
 import (
-	"encoding/csv"
-	"fmt"
+	"context"
+	"log/slog"
 	"os"
 	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// The loads completed by the current update, reported once it finishes
+var loadCompleted		[]LoadProgress
+var loadCompletedMutex	sync.Mutex
+
 func loadCheckInitialised() (bool, []string) {
 	initialised := true
 	var missing []string
 
-	if hasCountryDatabase() {
-		if !dbInitialised("COUNTRY") {
-			missing		= append(missing, "COUNTRY")
+	// Each dataset source is loaded separately, so only the ones that are missing need loading
+	for _, key := range []string{ "COUNTRY", "ASN", "CITY" } {
+		for _, source := range datasetSources(key) {
+			if !dbInitialised(key, source) {
+				missing		= append(missing, source)
+				initialised	= false
+			}
+		}
+	}
+
+	if hasReputationDatabase() {
+		if !dbInitialised("REPUTATION", "") {
+			missing		= append(missing, "REPUTATION")
 			initialised	= false
 		}
 	}
 
-	if hasASNDatabase() {
-		if !dbInitialised("ASN") {
-			missing		= append(missing, "ASN")
+	if hasGeofeedDatabase() {
+		if !dbInitialised("GEOFEED", "") {
+			missing		= append(missing, "GEOFEED")
 			initialised	= false
 		}
 	}
 
-	if hasCityDatabase() {
-		if !dbInitialised("CITY") {
-			missing		= append(missing, "CITY")
+	if hasConsensusDatabase() {
+		if !dbInitialised("CONSENSUS", "") {
+			missing		= append(missing, "CONSENSUS")
 			initialised	= false
 		}
 	}
@@ -35,30 +54,40 @@ func loadCheckInitialised() (bool, []string) {
 	return initialised, missing
 }
 
-func loadData(dataToLoad []DataToLoad) {
+func loadData(ctx context.Context, dataToLoad []DataToLoad) {
 	for _, item := range dataToLoad {
 		switch item.Download.Type {
-			case "CITY":	loadCities(item)
-			case "ASN":		loadASNs(item)
-			case "COUNTRY":	loadCountries(item)
+			case "CITY":	loadCities(ctx, item)
+			case "ASN":		loadASNs(ctx, item)
+			case "COUNTRY":	loadCountries(ctx, item)
+			case "REPUTATION":	loadReputation(ctx, item)
+			case "GEOFEED":		loadGeofeeds(ctx, item)
+			case "CONSENSUS":	loadConsensus(ctx, item)
 		}
 	}
 }
 
-func loadCities(dataToLoad DataToLoad) {
+func loadCities(ctx context.Context, dataToLoad DataToLoad) {
+	ctx, span := traceLoad(ctx, "ip_city", dataToLoad)
+	defer span.End()
+
 	csvFile, err := os.Open(dataToLoad.Path)
 	if err != nil {
 		panic(err)
 	}
 	defer csvFile.Close()
-	csvFileReader := csv.NewReader(csvFile)
+	csvFileReader, err := newSourceReader(csvFile, dataToLoad)
+	if err != nil {
+		// Only this source is skipped, the other datasets still load
+		slog.Error("source could not be read", "table", "ip_city", "source", dataToLoad.Download.Folder, "ip_version", dataToLoad.Version, "error", err)
+		span.RecordError(err)
+		return
+	}
 
 	version 	:= dbQueryMaxVersion("ip_city", dataToLoad.Version) + 1
 	cities		:= []IpCity{}
-	numSaved	:= 0;
-
-	fmt.Println("rebuilding: ip_city ipv", dataToLoad.Version)
-	fmt.Print("\033[s") // Save the cursor position
+	progress	:= NewLoadProgress("ip_city", dataToLoad.Download.Folder, dataToLoad.Version, version)
+	slog.Info("rebuilding", "table", "ip_city", "source", dataToLoad.Download.Folder, "ip_version", dataToLoad.Version, "db_version", version)
 	for {
 		record, err := csvFileReader.Read()
 		if err != nil {
@@ -67,112 +96,178 @@ func loadCities(dataToLoad DataToLoad) {
 		lat, _ := strconv.ParseFloat(record[7], 64)
 		lon, _ := strconv.ParseFloat(record[8], 64)
 
-		cities = append(cities, IpCity{ record[0], record[1], record[2], record[3], record[4], record[5], record[6], lat, lon, record[9], dataToLoad.Version, version })
+		cities = append(cities, IpCity{ record[0], record[1], record[2], record[3], record[4], record[5], record[6], lat, lon, record[9], csvFileReader.localisedNames(record), dataToLoad.Version, version, dataToLoad.Download.Folder })
 
 		if len(cities) == 100 {
-			dbSaveCities(cities)
-			logEntriesConditionally(&numSaved, &cities)
+			loadBatch(ctx, "ip_city", cities, dbSaveCities)
+			progress.Saved(len(cities))
 			cities = []IpCity{}
 		}
 	}
 
 	if len(cities) > 0 {
-		dbSaveCities(cities)
-		logEntries(&cities)
+		loadBatch(ctx, "ip_city", cities, dbSaveCities)
+		progress.Saved(len(cities))
 	}
 
-	dbDropOld("ip_city", dataToLoad.Version, version)
+	progress.Complete()
+
+	dbDropOld("ip_city", dataToLoad.Download.Folder, dataToLoad.Version, version)
 }
 
-func loadASNs(dataToLoad DataToLoad) {
+func loadASNs(ctx context.Context, dataToLoad DataToLoad) {
+	ctx, span := traceLoad(ctx, "ip_asn", dataToLoad)
+	defer span.End()
+
 	csvFile, err := os.Open(dataToLoad.Path)
 	if err != nil {
 		panic(err)
 	}
 	defer csvFile.Close()
-	csvFileReader := csv.NewReader(csvFile)
+	csvFileReader, err := newSourceReader(csvFile, dataToLoad)
+	if err != nil {
+		slog.Error("source could not be read", "table", "ip_asn", "source", dataToLoad.Download.Folder, "ip_version", dataToLoad.Version, "error", err)
+		span.RecordError(err)
+		return
+	}
 
 	version 	:= dbQueryMaxVersion("ip_asn", dataToLoad.Version) + 1
 	ASNs		:= []IpASN{}
-	numSaved	:= 0;
-
-	fmt.Println("rebuilding: ip_asn ipv", dataToLoad.Version)
-	fmt.Print("\033[s") // Save the cursor position
+	progress	:= NewLoadProgress("ip_asn", dataToLoad.Download.Folder, dataToLoad.Version, version)
+	slog.Info("rebuilding", "table", "ip_asn", "source", dataToLoad.Download.Folder, "ip_version", dataToLoad.Version, "db_version", version)
 	for {
 		record, err := csvFileReader.Read()
 		if err != nil {
 			break
 		}
 		asn, _ := strconv.Atoi(record[2])
-		ASNs = append(ASNs, IpASN{ record[0], record[1], asn, record[3], dataToLoad.Version, version })
+		ASNs = append(ASNs, IpASN{ record[0], record[1], asn, record[3], dataToLoad.Version, version, dataToLoad.Download.Folder })
 
 		if len(ASNs) == 100 {
-			dbSaveASNs(ASNs)
-			logEntriesConditionally(&numSaved, &ASNs)
+			loadBatch(ctx, "ip_asn", ASNs, dbSaveASNs)
+			progress.Saved(len(ASNs))
 			ASNs = []IpASN{}
 		}
 	}
 
 	if len(ASNs) > 0 {
-		dbSaveASNs(ASNs)
-		logEntries(&ASNs)
+		loadBatch(ctx, "ip_asn", ASNs, dbSaveASNs)
+		progress.Saved(len(ASNs))
 	}
 
-	dbDropOld("ip_asn", dataToLoad.Version, version)
+	progress.Complete()
+
+	dbDropOld("ip_asn", dataToLoad.Download.Folder, dataToLoad.Version, version)
 }
 
-func loadCountries(dataToLoad DataToLoad) {
+func loadCountries(ctx context.Context, dataToLoad DataToLoad) {
+	ctx, span := traceLoad(ctx, "ip_country", dataToLoad)
+	defer span.End()
+
 	csvFile, err := os.Open(dataToLoad.Path)
 	if err != nil {
 		panic(err)
 	}
 	defer csvFile.Close()
-	csvFileReader := csv.NewReader(csvFile)
+	csvFileReader, err := newSourceReader(csvFile, dataToLoad)
+	if err != nil {
+		slog.Error("source could not be read", "table", "ip_country", "source", dataToLoad.Download.Folder, "ip_version", dataToLoad.Version, "error", err)
+		span.RecordError(err)
+		return
+	}
 
 	version		:= dbQueryMaxVersion("ip_country", dataToLoad.Version) + 1
 	countries	:= []IpCountry{}
-	numSaved	:= 0
-
-	fmt.Println("rebuilding: ip_country ipv", dataToLoad.Version)
-	fmt.Print("\033[s") // Save the cursor position
+	progress	:= NewLoadProgress("ip_country", dataToLoad.Download.Folder, dataToLoad.Version, version)
+	slog.Info("rebuilding", "table", "ip_country", "source", dataToLoad.Download.Folder, "ip_version", dataToLoad.Version, "db_version", version)
 	for {
 		record, err := csvFileReader.Read()
 		if err != nil {
 			break
 		}
-		countries = append(countries, IpCountry{ record[0], record[1], record[2], dataToLoad.Version, version })
+		countries = append(countries, IpCountry{ record[0], record[1], record[2], dataToLoad.Version, version, dataToLoad.Download.Folder })
 
 		if len(countries) == 100 {
-			dbSaveCountries(countries)
-			logEntriesConditionally(&numSaved, &countries)
+			loadBatch(ctx, "ip_country", countries, dbSaveCountries)
+			progress.Saved(len(countries))
 			countries = []IpCountry{}
 		}
 	}
 
 	if len(countries) > 0 {
-		dbSaveCountries(countries)
-		logEntries(&countries)
+		loadBatch(ctx, "ip_country", countries, dbSaveCountries)
+		progress.Saved(len(countries))
 	}
 
-	dbDropOld("ip_country", dataToLoad.Version, version)
+	progress.Complete()
+
+	dbDropOld("ip_country", dataToLoad.Download.Folder, dataToLoad.Version, version)
+}
+
+func traceLoad(ctx context.Context, table string, dataToLoad DataToLoad) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "load " + table, trace.WithAttributes(
+		attribute.String("dataset", dataToLoad.Download.Folder),
+		attribute.String("file", dataToLoad.Path),
+		attribute.Int("ip_version", dataToLoad.Version),
+	))
+}
+
+func loadBatch[T any](ctx context.Context, table string, list []T, save func([]T)) {
+	_, span := tracer.Start(ctx, "save batch " + table, trace.WithAttributes(attribute.Int("rows", len(list))))
+	defer span.End()
+
+	save(list)
 }
 
 func loadDbStructure() {
 	dbFile()
+	dbMigrate()
 }
 
-func logEntries[T any](list *[]T) {
-	fmt.Print("\033[u\033[K") // Restore the cursor position and clear the line
-	fmt.Printf("Saved: %d entries\n", len(*list))
+// Don't spam the logs, only emit a progress record after every `LOAD_LOG_FREQ` records
+func (progress *LoadProgress) Saved(count int) {
+	progress.Rows += count
+
+	if progress.Rows - progress.logged >= progress.frequency {
+		progress.logged = progress.Rows
+		progress.log("load progress")
+	}
 }
 
-// Don't spam the logs, only display every message after `logFreq` records
-func logEntriesConditionally[T any](numSaved *int, list *[]T) {
-	logFreq := getLogFrequency()
+func (progress *LoadProgress) Complete() {
+	progress.Elapsed = time.Since(progress.Start)
+	progress.log("load complete")
 
-	*numSaved += len(*list)
+	loadCompletedMutex.Lock()
+	loadCompleted = append(loadCompleted, *progress)
+	loadCompletedMutex.Unlock()
+}
+
+// Returns the loads completed since the last call
+func loadCompletedTake() []LoadProgress {
+	loadCompletedMutex.Lock()
+	defer loadCompletedMutex.Unlock()
+
+	completed		:= loadCompleted
+	loadCompleted	= nil
+
+	return completed
+}
+
+func (progress *LoadProgress) log(message string) {
+	elapsed := time.Since(progress.Start)
 
-	if len(*list) >= *numSaved + logFreq {
-		logEntries(list)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(progress.Rows) / elapsed.Seconds()
 	}
-}
\ No newline at end of file
+
+	slog.Info(message,
+		"table",		progress.Table,
+		"ip_version",	progress.IpVersion,
+		"db_version",	progress.DbVersion,
+		"rows",			progress.Rows,
+		"elapsed",		elapsed.Round(time.Millisecond),
+		"rows_per_sec",	int(rate),
+	)
+}
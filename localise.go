@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Stored as a single JSON column, empty when the source only has the default names
+func (names LocalisedNames) String() string {
+	if len(names.City) == 0 && len(names.State1) == 0 && len(names.State2) == 0 {
+		return ""
+	}
+
+	jsonBytes, err := json.Marshal(names)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(jsonBytes)
+}
+
+func parseLocalisedNames(jsonString string) LocalisedNames {
+	var names LocalisedNames
+	if len(jsonString) > 0 {
+		err := json.Unmarshal([]byte(jsonString), &names)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return names
+}
+
+// Creates the map for the first name added
+func localisedNameAdd(names map[string]string, language string, name string) map[string]string {
+	if names == nil {
+		names = map[string]string{}
+	}
+	names[language] = name
+
+	return names
+}
+
+// MMDB files keep every language (including English) in one map, the default name is held separately here
+func localisedNamesFromMmdb(names map[string]string) map[string]string {
+	localised := map[string]string{}
+	for language, name := range names {
+		if language != "en" && len(name) > 0 {
+			localised[language] = name
+		}
+	}
+
+	return localised
+}
+
+// Preferred languages, from `?lang=` (comma separated) and then the `Accept-Language` header in order of quality
+func requestLanguages(request *http.Request) []string {
+	var languages []string
+
+	for _, language := range strings.Split(request.URL.Query().Get("lang"), ",") {
+		language = strings.TrimSpace(language)
+		if len(language) > 0 {
+			languages = append(languages, language)
+		}
+	}
+
+	type weightedLanguage struct {
+		language	string
+		quality		float64
+	}
+
+	var weighted []weightedLanguage
+	for _, part := range strings.Split(request.Header.Get("Accept-Language"), ",") {
+		pieces		:= strings.Split(strings.TrimSpace(part), ";")
+		language	:= strings.TrimSpace(pieces[0])
+		quality		:= 1.0
+
+		for _, parameter := range pieces[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				value, err := strconv.ParseFloat(parameter[2:], 64)
+				if err == nil {
+					quality = value
+				}
+			}
+		}
+
+		if len(language) > 0 && language != "*" && quality > 0 {
+			weighted = append(weighted, weightedLanguage{ language, quality })
+		}
+	}
+
+	sort.SliceStable(weighted, func(i, j int) bool { return weighted[i].quality > weighted[j].quality })
+	for _, item := range weighted {
+		languages = append(languages, item.language)
+	}
+
+	return languages
+}
+
+// Swaps in the best available names for the requested languages, falling back to English
+func (ip *Ip) localise(languages []string) {
+	if !ip.FoundCity && len(ip.State1) == 0 {
+		return
+	}
+
+	ip.Language = "en"
+
+	for _, language := range languages {
+		for _, candidate := range languageCandidates(language) {
+			if strings.EqualFold(candidate, "en") {
+				return
+			}
+
+			city, hasCity		:= localisedName(ip.names.City, candidate)
+			state1, hasState	:= localisedName(ip.names.State1, candidate)
+			if !hasCity && !hasState {
+				continue
+			}
+
+			if hasCity {
+				ip.City = city
+			}
+			if hasState {
+				ip.State1 = state1
+			}
+			if state2, ok := localisedName(ip.names.State2, candidate); ok {
+				ip.State2 = state2
+			}
+			ip.Language = candidate
+
+			return
+		}
+	}
+}
+
+// `pt-BR` tries `pt-BR` then `pt`
+func languageCandidates(language string) []string {
+	language = strings.ReplaceAll(language, "_", "-")
+
+	candidates := []string{ language }
+	if base, _, found := strings.Cut(language, "-"); found {
+		candidates = append(candidates, base)
+	}
+
+	return candidates
+}
+
+// Language tags are case-insensitive (`zh-CN` / `zh-cn`)
+func localisedName(names map[string]string, language string) (string, bool) {
+	for key, name := range names {
+		if strings.EqualFold(key, language) && len(name) > 0 {
+			return name, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Loads a city source with German names, then looks addresses up through the route
+func TestLocalisedCityNames(t *testing.T) {
+	for _, dbType := range []string{ "sqlite", "mmdb" } {
+		t.Run(dbType, func(t *testing.T) {
+			testLocalisedCityNames(t, dbType)
+		})
+	}
+}
+
+func testLocalisedCityNames(t *testing.T, dbType string) {
+	// MMDB files are written to the downloads folder of the working directory
+	directory := t.TempDir()
+	t.Chdir(directory)
+
+	err := os.Mkdir("downloads", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csvPath := filepath.Join(directory, "my-city.csv")
+	err = os.WriteFile(csvPath, []byte("start,end,country,state,state_de,city,city_de,lat,lon\n" +
+		"1.0.0.0,1.0.0.255,DE,Bavaria,Bayern,Munich,München,48.1,11.5\n" +
+		"2.0.0.0,2.0.0.255,DE,Hesse,,Frankfurt,,50.1,8.6\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, problems := configParse(map[string]string{
+		"DB_TYPE":			dbType,
+		"DB_FILE":			filepath.Join(directory, "ip.db"),
+		"TIMEZONE_INFER":	"false",
+		"CITY":				"my-city",
+		"SOURCES":			`{ "my-city": { "type": "city", "url": "https://example.com/city.csv", "header": true, "columns": {
+			"ip_range_start": "start", "ip_range_end": "end", "country_code": "country", "state1": "state", "city": "city",
+			"latitude": "lat", "longitude": "lon", "state1.de": "state_de", "city.de": 6 } } }`,
+	})
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	configCurrent.Store(loaded)
+
+	dbConnect()
+	defer dbClose()
+	prepare()
+
+	loadData(context.Background(), []DataToLoad{ { loaded.Sources["my-city"], csvPath, 4 } })
+
+	lookups := []struct {
+		path		string
+		header		string
+		city		string
+		state		string
+		language	string
+	}{
+		{ "/ip/1.0.0.1?lang=de", "", "München", "Bayern", "de" },
+		{ "/ip/1.0.0.1", "fr;q=0.9, de-AT;q=0.8", "München", "Bayern", "de" },
+		{ "/ip/1.0.0.1?lang=fr", "", "Munich", "Bavaria", "en" },
+		{ "/ip/1.0.0.1", "", "Munich", "Bavaria", "en" },
+		{ "/ip/2.0.0.1?lang=de", "", "Frankfurt", "Hesse", "en" },
+	}
+
+	for _, lookup := range lookups {
+		request := httptest.NewRequest(http.MethodGet, lookup.path, nil)
+		request.SetPathValue("ip", filepath.Base(request.URL.Path))
+		if len(lookup.header) > 0 {
+			request.Header.Set("Accept-Language", lookup.header)
+		}
+
+		recorder := httptest.NewRecorder()
+		getIp(recorder, request)
+
+		var result map[string]any
+		err := json.Unmarshal(recorder.Body.Bytes(), &result)
+		if err != nil {
+			t.Fatalf("%s: %s (%s)", lookup.path, err, recorder.Body.String())
+		}
+
+		if result["city"] != lookup.city || result["state"] != lookup.state || result["language"] != lookup.language {
+			t.Errorf("%s (Accept-Language %q): got %v / %v in %v, expected %s / %s in %s", lookup.path, lookup.header, result["city"], result["state"], result["language"], lookup.city, lookup.state, lookup.language)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Kept so the logger can be rebuilt when the configuration is reloaded
+var loggerOutput io.Writer
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status	int
+	bytes	int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(content []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	written, err := recorder.ResponseWriter.Write(content)
+	recorder.bytes += written
+
+	return written, err
+}
+
+func loggerInit(output io.Writer) {
+	loggerOutput = output
+	options := &slog.HandlerOptions{ Level: loggerLevel(config().LogLevel) }
+
+	var handler slog.Handler
+	switch strings.ToLower(config().LogFormat) {
+		case "json":	handler = slog.NewJSONHandler(output, options)
+		default:		handler = slog.NewTextHandler(output, options)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
+func loggerLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+		case "debug":			return slog.LevelDebug
+		case "warn", "warning":	return slog.LevelWarn
+		case "error":			return slog.LevelError
+	}
+
+	return slog.LevelInfo
+}
+
+// Wraps the router so that every request produces a single access log record
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start		:= time.Now()
+		recorder	:= &statusRecorder{ ResponseWriter: response }
+
+		next.ServeHTTP(recorder, request)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		slog.Info("request",
+			"method",		request.Method,
+			"path",			request.URL.Path,
+			"status",		recorder.status,
+			"bytes",		recorder.bytes,
+			"latency",		time.Since(start),
+			"key",			apiKeyName(request),
+			"client_ip",	clientIp(request),
+			"trace_id",		traceId(request.Context()),
+		)
+	})
+}
+
+// Name of the key used by the request, never the key itself
+func apiKeyName(request *http.Request) string {
+	key := request.Header.Get("API-KEY")
+	if len(key) == 0 {
+		return "none"
+	}
+
+	if len(config().ApiKey) > 0 && key == config().ApiKey {
+		return "default"
+	}
+
+	if len(config().AdminApiKey) > 0 && key == config().AdminApiKey {
+		return "admin"
+	}
+
+	return "invalid"
+}
+
+// Proxy headers are only trusted when the direct peer is local (i.e. a reverse proxy on the same host / network)
+func clientIp(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	peer := net.ParseIP(host)
+	if peer != nil && (peer.IsLoopback() || peer.IsPrivate()) {
+		if realIp := request.Header.Get("X-Real-IP"); len(realIp) > 0 {
+			return realIp
+		}
+
+		if forwardedFor := request.Header.Get("X-Forwarded-For"); len(forwardedFor) > 0 {
+			return strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
+		}
+	}
+
+	return host
+}
@@ -1,54 +1,135 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/joho/godotenv"
+	"golang.org/x/exp/slices"
 )
 
-var processing = false
+// Set while an update runs, only one may run at a time
+var processing atomic.Bool
+
+// Datasets that needed loading while an update was running, loaded as soon as it finishes
+var upgradePending		[]string
+var upgradePendingMutex	sync.Mutex
 
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		panic("Error loading .env file")
+	command, args	:= cliCommand(os.Args[1:])
+	flags			:= cliFlags(command)
+	args			= cliParse(flags, args)
+
+	configFlags			= cliFlagValues(flags)
+	loaded, problems	:= configLoad(configFlags)
+	if command == "check-config" {
+		cliCheckConfig(problems)
+		return
 	}
 
+	if len(problems) > 0 {
+		configReport(problems)
+		os.Exit(1)
+	}
+	configCurrent.Store(loaded)
+
+	loggerInit(cliLogOutput(command))
+
+	shutdownTracing := tracingInit()
+	defer shutdownTracing()
+
 	dbConnect()
 	defer dbClose()
+
+	switch command {
+		case "load":	cliLoad()
+		case "lookup":	cliLookup(flags, args)
+		case "status":	cliStatus()
+		case "export":
+			prepare()
+			exportCommand(args)
+		default:		serve()
+	}
+}
+
+func serve() {
 	initialise()
 
-	http.HandleFunc("GET /", getHome)
-	http.HandleFunc("GET /ip/{ip}", getIp)
-	http.HandleFunc("GET /random/{ipVersion}", getRandomIp)
-	http.HandleFunc("GET /benchmark/{ipVersion}/{times}", getBenchmark)
+	// Loads the timezone boundaries in the background so the first lookup isn't held up
+	if timezoneInferEnabled() {
+		go timezoneInfer(0, 0)
+	}
 
-	fmt.Printf("starting server on %s:%s\n", os.Getenv("SERVER_HOST"), os.Getenv("SERVER_PORT"))
-	err = http.ListenAndServe(fmt.Sprintf("%s:%s", os.Getenv("SERVER_HOST"), os.Getenv("SERVER_PORT")), nil)
+	// Not the default mux: dependencies register their own debug handlers on that one
+	router := http.NewServeMux()
+	router.HandleFunc("GET /", getHome)
+	router.HandleFunc("GET /ip/{ip}", getIp)
+	router.HandleFunc("GET /compare/{ip}", getCompare)
+	router.HandleFunc("GET /countries", getCountries)
+	router.HandleFunc("GET /distance/{ipA}", getDistance)
+	router.HandleFunc("GET /distance/{ipA}/{ipB}", getDistance)
+	router.HandleFunc("GET /random/{ipVersion}", getRandomIp)
+	router.HandleFunc("GET /benchmark/{ipVersion}", getBenchmark)
+	router.HandleFunc("GET /benchmark/{ipVersion}/{times}", getBenchmark)
+	router.HandleFunc("GET /admin/overrides", getOverrides)
+	router.HandleFunc("POST /admin/overrides", postOverride)
+	router.HandleFunc("GET /admin/overrides/{id}", getOverride)
+	router.HandleFunc("PUT /admin/overrides/{id}", putOverride)
+	router.HandleFunc("DELETE /admin/overrides/{id}", deleteOverride)
+	router.HandleFunc("GET /admin/versions", getVersions)
+	router.HandleFunc("GET /admin/diffs/{dataset}", getDiffs)
+	router.HandleFunc("GET /admin/webhooks", getWebhooks)
+	router.HandleFunc("GET /admin/export/{source}/{format}", getExport)
+	router.HandleFunc("POST /admin/reload", postReload)
+
+	// `kill -HUP` reloads the configuration, the same as `POST /admin/reload`
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			configReload()
+		}
+	}()
+
+	address := fmt.Sprintf("%s:%d", config().ServerHost, config().ServerPort)
+
+	slog.Info("starting server", "address", address)
+	err := http.ListenAndServe(address, traceRequests(accessLog(router)))
 
 	if errors.Is(err, http.ErrServerClosed) {
-		fmt.Println("server closed")
+		slog.Info("server closed")
 	} else if err != nil {
-		fmt.Printf("error starting server: %s\n", err)
+		slog.Error("error starting server", "error", err)
 		os.Exit(1)
 	}
 }
 
-func initialise() {
+// Everything needed before data can be looked up, loaded or exported
+func prepare() {
 	loadDbStructure()
+	overridesLoad()
+	versionsLoad()
+}
+
+func initialise() {
+	prepare()
 
 	initialised, missing := loadCheckInitialised()
 
 	if !initialised {
-		fmt.Println("initialising data source(s)...")
-		go upgrade(missing)
+		slog.Info("initialising data source(s)", "missing", missing)
+		upgradeInBackground(missing)
 	}
 
-	if len(os.Getenv("UPDATE_TIME")) > 0 {
+	if len(config().UpdateTime) > 0 {
 		normaliser	:= time.NewTicker(time.Second)
 		checker		:= time.NewTicker(2 * time.Minute)
 		quit		:= make(chan struct{})
@@ -57,7 +138,7 @@ func initialise() {
 			for {
 				select {
 					case <- normaliser.C:
-						checker.Reset(durationUntil(os.Getenv("UPDATE_TIME")))
+						checker.Reset(durationUntil(config().UpdateTime))
 						normaliser.Stop()
 					case <- checker.C:
 						update(checker)
@@ -70,19 +151,99 @@ func initialise() {
 	}
 }
 
-func upgrade(missing []string) {
-	processing = true;
+// Waits for the update, false if it failed or another update was already running
+func upgrade(missing []string) bool {
+	if !processing.CompareAndSwap(false, true) {
+		return false
+	}
+
+	return upgradeClaimed(missing)
+}
+
+// False (without starting it) when another update is already running
+func upgradeInBackground(missing []string) bool {
+	if !processing.CompareAndSwap(false, true) {
+		return false
+	}
+
+	go upgradeClaimed(missing)
+
+	return true
+}
+
+// Starts loading the datasets in the background, or (returning false) queues them to load once the running update finishes
+func upgradeOrQueue(missing []string) bool {
+	upgradePendingMutex.Lock()
+	defer upgradePendingMutex.Unlock()
+
+	if upgradeInBackground(missing) {
+		return true
+	}
+
+	for _, dataset := range missing {
+		if !slices.Contains(upgradePending, dataset) {
+			upgradePending = append(upgradePending, dataset)
+		}
+	}
+
+	return false
+}
+
+// Released along with taking the queue, so nothing can be queued after the queue is checked but before the next update can start
+func upgradeRelease() []string {
+	upgradePendingMutex.Lock()
+	defer upgradePendingMutex.Unlock()
+
+	processing.Store(false)
 
-	dataToLoad := downloadDataToLoad(missing)
-	loadData(dataToLoad)
+	pending := upgradePending
+	upgradePending = nil
+
+	return pending
+}
+
+// Only called once `processing` has been claimed, which it releases when done
+func upgradeClaimed(missing []string) (ok bool) {
+	start := time.Now()
+
+	// The data already loaded is still served after a failure, so it's reported rather than stopping the server
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Error("update failed", "error", err)
+			webhookNotify("failed", start, loadCompletedTake(), fmt.Sprint(err))
+			ok = false
+		}
+
+		if pending := upgradeRelease(); len(pending) > 0 {
+			slog.Info("loading data source(s) queued during the update", "missing", pending)
+			upgradeOrQueue(pending)
+		}
+	}()
+
+	ctx, span := tracer.Start(context.Background(), "upgrade")
+	defer span.End()
+
+	loadCompletedTake()
+	webhookNotify("started", start, nil, "")
+
+	dataToLoad := downloadDataToLoad(ctx, missing)
+	loadData(ctx, dataToLoad)
+	samplerReset()
+	compareReset()
+
+	completed := loadCompletedTake()
+	if len(completed) == 0 {
+		webhookNotify("no_change", start, completed, "")
+	} else {
+		webhookNotify("completed", start, completed, "")
+	}
 
-	processing = false;
+	return true
 }
 
 func update(checker *time.Ticker) {
-	if !processing {
-		fmt.Println("checking for updates...")
-		go upgrade([]string{})
+	if upgradeInBackground([]string{}) {
+		slog.Info("checking for updates")
 
 		checker.Reset(24 * time.Hour)
 	}
@@ -1,27 +1,52 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/big"
 	"net"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/oschwald/maxminddb-golang"
 	"github.com/maxmind/mmdbwriter"
 	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"golang.org/x/exp/slices"
 )
 
-var mmDb = map[string]*maxminddb.Reader{}
-var mmDbWriter *mmdbwriter.Tree
+// Loads and reloads open and close files while lookups are reading from them
+var mmDb		= map[string]*maxminddb.Reader{}
+var mmDbMutex	sync.RWMutex
+var mmDbWriter	*mmdbwriter.Tree
 
+// Files left from sources that are no longer configured are opened too, so they can still be compared
 func mmdbConnect() {
-	mmdbOpenFile("COUNTRY")
-	mmdbOpenFile("ASN")
-	mmdbOpenFile("CITY")
+	for name := range availableSources() {
+		mmdbOpenFile(name)
+	}
+
+	if hasReputationDatabase() {
+		mmdbOpenFile("reputation")
+	}
+
+	if hasGeofeedDatabase() {
+		mmdbOpenFile("geofeed")
+	}
+
+	if hasConsensusDatabase() {
+		mmdbOpenFile("consensus")
+	}
 }
 
 func mmdbClose() {
+	mmDbMutex.Lock()
+	defer mmDbMutex.Unlock()
+
 	for connectionId, conn := range mmDb {
 		err := conn.Close()
 		if err != nil {
@@ -31,40 +56,67 @@ func mmdbClose() {
 	}
 }
 
-func mmdbInitialised(key string) bool {
-	connectionId := key + "ipv4"
+func mmdbInitialised(key string, source string) bool {
+	connectionId := datasetName("ip_" + strings.ToLower(key), source) + "ipv4"
+
+	mmDbMutex.RLock()
+	defer mmDbMutex.RUnlock()
+
 	_, ok := mmDb[connectionId]
 
 	return ok
 }
 
-func mmdbOpenFile(key string) {
-	if len(os.Getenv(key)) > 0 {
-		ipVersions := []int{ 4, 6 }
-		for _, ipVersion := range ipVersions {
-			connectionId 	:= key + "ipv" + strconv.Itoa(ipVersion)
-			filePath 		:= "downloads/" + os.Getenv(key) + "-ipv" + strconv.Itoa(ipVersion) + ".mmdb"
-
-			if _, err := os.Stat(filePath); err == nil {
-				_, ok := mmDb[connectionId]
-				if !ok {
-					fmt.Println("Opening MMDB file: " + filePath)
-					conn, err := maxminddb.Open(filePath)
-					if err != nil {
-						panic(err)
-					}
-
-					mmDb[connectionId] = conn
-				}
+// Files (and connections) are named after the dataset source, or `reputation` / `geofeed` for the merged lists
+func mmdbOpenFile(name string) {
+	ipVersions := []int{ 4, 6 }
+	for _, ipVersion := range ipVersions {
+		mmdbOpenConnection(name + "ipv" + strconv.Itoa(ipVersion), mmdbFilePath(name, ipVersion))
+
+		for dbVersion, filePath := range mmdbRetainedFiles(name, ipVersion) {
+			mmdbOpenConnection(mmdbRetainedId(name, ipVersion, dbVersion), filePath)
+		}
+	}
+}
+
+func mmdbOpenConnection(connectionId string, filePath string) {
+	mmDbMutex.Lock()
+	defer mmDbMutex.Unlock()
+
+	if _, err := os.Stat(filePath); err == nil {
+		_, ok := mmDb[connectionId]
+		if !ok {
+			slog.Info("opening mmdb file", "path", filePath)
+			conn, err := maxminddb.Open(filePath)
+			if err != nil {
+				panic(err)
 			}
+
+			mmDb[connectionId] = conn
 		}
 	}
 }
 
+// Earlier versions kept by the retention settings have their own connections, the latest (or an unrecorded) version is the main file
+func mmdbConnection(name string, ipVersion int, dbVersion int) (*maxminddb.Reader, bool) {
+	mmDbMutex.RLock()
+	defer mmDbMutex.RUnlock()
+
+	conn, ok := mmDb[mmdbRetainedId(name, ipVersion, dbVersion)]
+	if !ok {
+		conn, ok = mmDb[name + "ipv" + strconv.Itoa(ipVersion)]
+	}
+
+	return conn, ok
+}
+
 func mmdbCloseFile(connectionId string, filePath string) {
+	mmDbMutex.Lock()
+	defer mmDbMutex.Unlock()
+
 	conn, ok := mmDb[connectionId]
 	if ok {
-		fmt.Println("Closing MMDB file: " + filePath)
+		slog.Info("closing mmdb file", "path", filePath)
 		err := conn.Close()
 		if err != nil {
 			panic(err)
@@ -73,118 +125,420 @@ func mmdbCloseFile(connectionId string, filePath string) {
 	}
 }
 
-func mmdbIp(ip net.IP) *Ip {
-	ipString	:= ip.String();
-	ipVersion	:= 4
-	if strings.Contains(ipString, ":") {
-		ipVersion = 6
+func mmdbCity(ctx context.Context, ip net.IP, source string, dbVersion int) (IpCity, bool) {
+	ipVersion	:= getIpVersion(ip.String())
+	city		:= IpCity{ Source: source }
+
+	conn, ok := mmdbConnection(source, ipVersion, dbVersion)
+	if !ok {
+		return city, false
+	}
+
+	var mmdbCity MmdbCity
+	_, span := traceQuery(ctx, "ip_city", ipVersion)
+	_, found, err := conn.LookupNetwork(ip, &mmdbCity)
+	traceEnd(span, err)
+	if err != nil {
+		panic(err)
 	}
 
-	ipStruct := NewIp(ipString, ipVersion)
+	if !found {
+		return city, false
+	}
 
-	if hasCityDatabase() {
-		connectionId := "CITYipv" + strconv.Itoa(ipVersion)
-		_, ok := mmDb[connectionId]
-		if ok {
-			var mmdbCity MmdbCity
-			err := mmDb[connectionId].Lookup(ip, &mmdbCity)
-			if err != nil {
-				panic(err)
-			}
+	mmdbCityFields(&city, mmdbCity)
 
-			if len(mmdbCity.City.Names.Value) > 0 {
-				ipStruct.City		= mmdbCity.City.Names.Value
-				ipStruct.Latitude	= mmdbCity.Location.Latitude
-				ipStruct.Longitude	= mmdbCity.Location.Longitude
-				ipStruct.FoundCity	= true
-
-				for i, subdivision := range mmdbCity.Subdivisions {
-					switch i {
-						case 0: ipStruct.State1 = subdivision.Names.Value
-						case 1: ipStruct.State2 = subdivision.Names.Value
-					}
-				}
-			}
+	return city, true
+}
 
-			if len(mmdbCity.Country.ISOCode) > 0 {
-				ipStruct.CountryCode	= mmdbCity.Country.ISOCode
-				ipStruct.FoundCountry	= true
+func mmdbCityFields(city *IpCity, mmdbCity MmdbCity) {
+	city.CountryCode = mmdbCity.Country.ISOCode
+
+	if len(mmdbCity.City.Names["en"]) > 0 {
+		city.City			= mmdbCity.City.Names["en"]
+		city.Names.City		= localisedNamesFromMmdb(mmdbCity.City.Names)
+		city.Postcode		= mmdbCity.City.Postcode
+		city.Timezone		= mmdbCity.City.Timezone
+		city.Latitude		= mmdbCity.Location.Latitude
+		city.Longitude		= mmdbCity.Location.Longitude
+
+		for i, subdivision := range mmdbCity.Subdivisions {
+			switch i {
+				case 0:
+					city.State1			= subdivision.Names["en"]
+					city.Names.State1	= localisedNamesFromMmdb(subdivision.Names)
+				case 1:
+					city.State2			= subdivision.Names["en"]
+					city.Names.State2	= localisedNamesFromMmdb(subdivision.Names)
 			}
 		}
 	}
+}
+
+func mmdbCountry(ctx context.Context, ip net.IP, source string, dbVersion int) (IpCountry, bool) {
+	ipVersion	:= getIpVersion(ip.String())
+	country		:= IpCountry{ Source: source }
+
+	conn, ok := mmdbConnection(source, ipVersion, dbVersion)
+	if !ok {
+		return country, false
+	}
 
-	if !ipStruct.FoundCountry && hasCountryDatabase() {
-		connectionId := "COUNTRYipv" + strconv.Itoa(ipVersion)
-		_, ok := mmDb[connectionId]
-		if ok {
-			var mmdbCountry MmdbCountry
-			err := mmDb[connectionId].Lookup(ip, &mmdbCountry)
-			if err != nil {
-				panic(err)
-			}
+	var mmdbCountry MmdbCountry
+	_, span := traceQuery(ctx, "ip_country", ipVersion)
+	_, found, err := conn.LookupNetwork(ip, &mmdbCountry)
+	traceEnd(span, err)
+	if err != nil {
+		panic(err)
+	}
 
-			if len(mmdbCountry.Country.ISOCode) > 0 {
-				ipStruct.CountryCode	= mmdbCountry.Country.ISOCode
-				ipStruct.FoundCountry	= true
-			}
-		}
+	country.CountryCode = mmdbCountry.Country.ISOCode
+
+	return country, found
+}
+
+func mmdbASN(ctx context.Context, ip net.IP, source string, dbVersion int) (IpASN, bool) {
+	ipVersion	:= getIpVersion(ip.String())
+	asn			:= IpASN{ Source: source }
+
+	conn, ok := mmdbConnection(source, ipVersion, dbVersion)
+	if !ok {
+		return asn, false
 	}
 
-	if hasASNDatabase() {
-		connectionId := "ASNipv" + strconv.Itoa(ipVersion)
-		_, ok := mmDb[connectionId]
-		if ok {
+	var mmdbASN MmdbASN
+	_, span := traceQuery(ctx, "ip_asn", ipVersion)
+	_, found, err := conn.LookupNetwork(ip, &mmdbASN)
+	traceEnd(span, err)
+	if err != nil {
+		panic(err)
+	}
+
+	asn.AsNumber		= int(mmdbASN.AsNumber)
+	asn.AsOrganisation	= mmdbASN.AsOrganisation
+
+	return asn, found
+}
+
+func mmdbReputation(ctx context.Context, ip net.IP, dbVersion int) (IpReputation, bool) {
+	ipVersion := getIpVersion(ip.String())
+
+	conn, ok := mmdbConnection("reputation", ipVersion, dbVersion)
+	if !ok {
+		return IpReputation{}, false
+	}
+
+	var mmdbReputation MmdbReputation
+	_, span := traceQuery(ctx, "ip_reputation", ipVersion)
+	_, found, err := conn.LookupNetwork(ip, &mmdbReputation)
+	traceEnd(span, err)
+	if err != nil {
+		panic(err)
+	}
+
+	if !found {
+		return IpReputation{}, false
+	}
+
+	return IpReputation{ IsTor: mmdbReputation.IsTor, IsVpn: mmdbReputation.IsVpn, IsHosting: mmdbReputation.IsHosting, Tags: strings.Join(mmdbReputation.Tags, ",") }, true
+}
+
+func mmdbGeofeed(ctx context.Context, ip net.IP, dbVersion int) (IpGeofeed, bool) {
+	ipVersion := getIpVersion(ip.String())
+
+	conn, ok := mmdbConnection("geofeed", ipVersion, dbVersion)
+	if !ok {
+		return IpGeofeed{}, false
+	}
+
+	var mmdbGeofeed MmdbGeofeed
+	_, span := traceQuery(ctx, "ip_geofeed", ipVersion)
+	_, found, err := conn.LookupNetwork(ip, &mmdbGeofeed)
+	traceEnd(span, err)
+	if err != nil {
+		panic(err)
+	}
+
+	if !found {
+		return IpGeofeed{}, false
+	}
+
+	return IpGeofeed{ CountryCode: mmdbGeofeed.CountryCode, State1: mmdbGeofeed.State1, City: mmdbGeofeed.City }, true
+}
+
+func mmdbConsensus(ctx context.Context, ip net.IP, dbVersion int) (IpConsensus, bool) {
+	ipVersion := getIpVersion(ip.String())
+
+	conn, ok := mmdbConnection("consensus", ipVersion, dbVersion)
+	if !ok {
+		return IpConsensus{}, false
+	}
+
+	var mmdbConsensus MmdbConsensus
+	_, span := traceQuery(ctx, "ip_consensus", ipVersion)
+	_, found, err := conn.LookupNetwork(ip, &mmdbConsensus)
+	traceEnd(span, err)
+	if err != nil {
+		panic(err)
+	}
+
+	if !found {
+		return IpConsensus{}, false
+	}
+
+	return IpConsensus{ CountryCode: mmdbConsensus.CountryCode, Confidence: mmdbConsensus.Confidence, Dissent: strings.Join(mmdbConsensus.Dissent, ",") }, true
+}
+
+func mmdbSampleRanges(table string, source string, ipVersion int, dbVersion int) []SampleRange {
+	ranges := []SampleRange{}
+
+	conn, ok := mmdbConnection(source, ipVersion, dbVersion)
+	if !ok {
+		return ranges
+	}
+
+	networks := conn.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		// City records share the country layout, so only ASN files need a different decoding
+		if table == "ip_asn" {
 			var mmdbASN MmdbASN
-			err := mmDb[connectionId].Lookup(ip, &mmdbASN)
+			network, err := networks.Network(&mmdbASN)
 			if err != nil {
 				panic(err)
 			}
 
 			if mmdbASN.AsNumber > 0 {
-				ipStruct.OrganisationNumber	= mmdbASN.AsNumber
-				ipStruct.OrganisationName	= mmdbASN.AsOrganisation
-				ipStruct.FoundASN			= true
+				ranges = append(ranges, NewSampleRangeFromNetwork(network, strconv.FormatInt(mmdbASN.AsNumber, 10)))
+			}
+		} else {
+			var mmdbCountry MmdbCountry
+			network, err := networks.Network(&mmdbCountry)
+			if err != nil {
+				panic(err)
+			}
+
+			if len(mmdbCountry.Country.ISOCode) > 0 {
+				ranges = append(ranges, NewSampleRangeFromNetwork(network, mmdbCountry.Country.ISOCode))
 			}
 		}
 	}
 
-	return ipStruct
+	if err := networks.Err(); err != nil {
+		panic(err)
+	}
+
+	return ranges
 }
 
-func mmdbSaveRestart(table string, ipVersion int) {
-	if mmDbWriter != nil {
-		var key string
+// Values are built the same way as the SQL backends' columns, `country|city` for cities and geofeeds
+func mmdbDiffRanges(table string, source string, ipVersion int, dbVersion int) []SampleRange {
+	ranges := []SampleRange{}
+
+	conn, ok := mmdbConnection(datasetName(table, source), ipVersion, dbVersion)
+	if !ok {
+		return ranges
+	}
+
+	networks := conn.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var value string
+		var network *net.IPNet
+		var err error
+
 		switch table {
-			case "ip_country":	key = "COUNTRY"
-			case "ip_asn":		key = "ASN"
-			case "ip_city":		key = "CITY"
+			case "ip_asn":
+				var mmdbASN MmdbASN
+				network, err = networks.Network(&mmdbASN)
+				value = strconv.FormatInt(mmdbASN.AsNumber, 10)
+			case "ip_city":
+				var mmdbCity MmdbCity
+				network, err = networks.Network(&mmdbCity)
+				value = mmdbCity.Country.ISOCode + "|" + mmdbCity.City.Names["en"]
+			case "ip_geofeed":
+				var mmdbGeofeed MmdbGeofeed
+				network, err = networks.Network(&mmdbGeofeed)
+				value = mmdbGeofeed.CountryCode + "|" + mmdbGeofeed.City
+			case "ip_consensus":
+				var mmdbConsensus MmdbConsensus
+				network, err = networks.Network(&mmdbConsensus)
+				value = mmdbConsensus.CountryCode
+			default:
+				var mmdbCountry MmdbCountry
+				network, err = networks.Network(&mmdbCountry)
+				value = mmdbCountry.Country.ISOCode
+		}
+		if err != nil {
+			panic(err)
 		}
 
-		connectionId	:= key + "ipv" + strconv.Itoa(ipVersion)
-		filePath		:= "downloads/" + os.Getenv(key) + "-ipv" + strconv.Itoa(ipVersion) + ".mmdb"
+		if len(strings.Trim(value, "|")) > 0 && value != "0" {
+			ranges = append(ranges, NewSampleRangeFromNetwork(network, value))
+		}
+	}
 
-		mmdbCloseFile(connectionId, filePath)
+	if err := networks.Err(); err != nil {
+		panic(err)
+	}
 
-		fileHandle, err := os.Create(filePath)
+	return ranges
+}
+
+// Each network is exported as its own range, in batches like the loads
+func mmdbExportCountries(source string, ipVersion int, dbVersion int, save func([]IpCountry)) {
+	countries := []IpCountry{}
+	mmdbExport(source, ipVersion, dbVersion, func(networks *maxminddb.Networks) {
+		var mmdbCountry MmdbCountry
+		network, err := networks.Network(&mmdbCountry)
+		if err != nil {
+			panic(err)
+		}
+
+		ipRangeStart, ipRangeEnd := mmdbNetworkRange(network, ipVersion)
+		countries = append(countries, IpCountry{ ipRangeStart, ipRangeEnd, mmdbCountry.Country.ISOCode, ipVersion, exportVersion, source })
+		if len(countries) == 100 {
+			save(countries)
+			countries = []IpCountry{}
+		}
+	})
+
+	if len(countries) > 0 {
+		save(countries)
+	}
+}
+
+func mmdbExportASNs(source string, ipVersion int, dbVersion int, save func([]IpASN)) {
+	ASNs := []IpASN{}
+	mmdbExport(source, ipVersion, dbVersion, func(networks *maxminddb.Networks) {
+		var mmdbASN MmdbASN
+		network, err := networks.Network(&mmdbASN)
 		if err != nil {
 			panic(err)
 		}
-		defer fileHandle.Close()
 
-		fmt.Println("Writing MMDB file: " + filePath)
-		_, err = mmDbWriter.WriteTo(fileHandle)
+		ipRangeStart, ipRangeEnd := mmdbNetworkRange(network, ipVersion)
+		ASNs = append(ASNs, IpASN{ ipRangeStart, ipRangeEnd, int(mmdbASN.AsNumber), mmdbASN.AsOrganisation, ipVersion, exportVersion, source })
+		if len(ASNs) == 100 {
+			save(ASNs)
+			ASNs = []IpASN{}
+		}
+	})
+
+	if len(ASNs) > 0 {
+		save(ASNs)
+	}
+}
+
+func mmdbExportCities(source string, ipVersion int, dbVersion int, save func([]IpCity)) {
+	cities := []IpCity{}
+	mmdbExport(source, ipVersion, dbVersion, func(networks *maxminddb.Networks) {
+		var mmdbCity MmdbCity
+		network, err := networks.Network(&mmdbCity)
 		if err != nil {
 			panic(err)
 		}
 
+		ipRangeStart, ipRangeEnd	:= mmdbNetworkRange(network, ipVersion)
+		city						:= IpCity{ IpRangeStart: ipRangeStart, IpRangeEnd: ipRangeEnd, IpVersion: ipVersion, DbVersion: exportVersion, Source: source }
+		mmdbCityFields(&city, mmdbCity)
+
+		cities = append(cities, city)
+		if len(cities) == 100 {
+			save(cities)
+			cities = []IpCity{}
+		}
+	})
+
+	if len(cities) > 0 {
+		save(cities)
+	}
+}
+
+func mmdbExport(source string, ipVersion int, dbVersion int, read func(*maxminddb.Networks)) {
+	conn, ok := mmdbConnection(source, ipVersion, dbVersion)
+	if !ok {
+		return
+	}
+
+	networks := conn.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		read(networks)
+	}
+
+	if err := networks.Err(); err != nil {
+		panic(err)
+	}
+}
+
+func mmdbNetworkRange(network *net.IPNet, ipVersion int) (string, string) {
+	sampleRange := NewSampleRangeFromNetwork(network, "")
+	ipRangeEnd	:= new(big.Int).Sub(new(big.Int).Add(sampleRange.Start, sampleRange.Size), big.NewInt(1))
+
+	return reputationIpString(sampleRange.Start, ipVersion), reputationIpString(ipRangeEnd, ipVersion)
+}
+
+// The file being replaced is kept under its version, `mmdbDropOld` removes it again if the retention settings don't need it
+func mmdbSaveRestart(table string, source string, ipVersion int, previousVersion int) {
+	if mmDbWriter != nil {
+		name			:= datasetName(table, source)
+		connectionId	:= name + "ipv" + strconv.Itoa(ipVersion)
+		filePath		:= mmdbFilePath(name, ipVersion)
+
+		mmdbCloseFile(connectionId, filePath)
+
+		if previousVersion > 0 && fileExists(filePath) {
+			retainedPath := mmdbRetainedPath(name, ipVersion, previousVersion)
+			err := os.MkdirAll(path.Dir(retainedPath), 0755)
+			if err != nil {
+				panic(err)
+			}
+
+			err = os.Rename(filePath, retainedPath)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		mmdbWriteFile(mmDbWriter, filePath)
+
 		mmDbWriter = nil
-		mmdbOpenFile(key)
+		mmdbOpenFile(name)
+	}
+}
+
+func mmdbDropOld(table string, source string, ipVersion int, dbVersion int) {
+	name := datasetName(table, source)
+
+	for retainedVersion, filePath := range mmdbRetainedFiles(name, ipVersion) {
+		if retainedVersion < dbVersion {
+			mmdbCloseFile(mmdbRetainedId(name, ipVersion, retainedVersion), filePath)
+
+			slog.Info("removing mmdb file", "path", filePath)
+			err := os.Remove(filePath)
+			if err != nil {
+				panic(err)
+			}
+		}
 	}
 }
 
+// The files hold no versions themselves, so they're numbered from the recorded loads
+func mmdbQueryMaxVersion(table string, ipVersion int) int {
+	version := 0
+	for _, loaded := range versionList() {
+		if loaded.Table == table && loaded.IpVersion == ipVersion {
+			version = max(version, loaded.DbVersion)
+		}
+	}
+
+	return version
+}
+
 func mmdbSaveCountries(countries []IpCountry) {
-	mmdbInitWriter("COUNTRY", countries[0].IpVersion, 24)
+	mmdbInitWriter(countries[0].Source, countries[0].IpVersion, 24)
+	mmdbInsertCountries(mmDbWriter, countries)
+}
 
+func mmdbInsertCountries(writer *mmdbwriter.Tree, countries []IpCountry) {
 	for _, country := range countries {
 		record := mmdbtype.Map{
 			"country": mmdbtype.Map{
@@ -194,7 +548,7 @@ func mmdbSaveCountries(countries []IpCountry) {
 
 		ipRanges := findIPRanges(country.IpRangeStart, country.IpRangeEnd)
 		for _, ipRange := range ipRanges {
-			err := mmDbWriter.Insert(ipRange, record)
+			err := writer.Insert(ipRange, record)
 			if err != nil {
 				panic(err)
 			}
@@ -203,8 +557,11 @@ func mmdbSaveCountries(countries []IpCountry) {
 }
 
 func mmdbSaveASNs(ASNs []IpASN) {
-	mmdbInitWriter("ASN", ASNs[0].IpVersion, 24)
+	mmdbInitWriter(ASNs[0].Source, ASNs[0].IpVersion, 24)
+	mmdbInsertASNs(mmDbWriter, ASNs)
+}
 
+func mmdbInsertASNs(writer *mmdbwriter.Tree, ASNs []IpASN) {
 	for _, ASN := range ASNs {
 		record := mmdbtype.Map{
 			"autonomous_system_number":			mmdbtype.Uint32(ASN.AsNumber),
@@ -213,7 +570,7 @@ func mmdbSaveASNs(ASNs []IpASN) {
 
 		ipRanges := findIPRanges(ASN.IpRangeStart, ASN.IpRangeEnd)
 		for _, ipRange := range ipRanges {
-			err := mmDbWriter.Insert(ipRange, record)
+			err := writer.Insert(ipRange, record)
 			if err != nil {
 				panic(err)
 			}
@@ -222,14 +579,15 @@ func mmdbSaveASNs(ASNs []IpASN) {
 }
 
 func mmdbSaveCities(cities []IpCity) {
-	mmdbInitWriter("CITY", cities[0].IpVersion, 28)
+	mmdbInitWriter(cities[0].Source, cities[0].IpVersion, 28)
+	mmdbInsertCities(mmDbWriter, cities)
+}
 
+func mmdbInsertCities(writer *mmdbwriter.Tree, cities []IpCity) {
 	for _, city := range cities {
 		record := mmdbtype.Map{
 			"city":	mmdbtype.Map{
-				"names": mmdbtype.Map{
-					"en": mmdbtype.String(city.City),
-				},
+				"names": mmdbNames(city.City, city.Names.City),
 				"postcode":	mmdbtype.String(city.Postcode),
 				"timezone":	mmdbtype.String(city.Timezone),
 			},
@@ -242,19 +600,88 @@ func mmdbSaveCities(cities []IpCity) {
 			},
 			"subdivisions": mmdbtype.Slice{
 				mmdbtype.Map{
-					"names": mmdbtype.Map{
-						"en": mmdbtype.String(city.State1),
-					},
+					"names": mmdbNames(city.State1, city.Names.State1),
 				},
 				mmdbtype.Map{
-					"names": mmdbtype.Map{
-						"en": mmdbtype.String(city.State2),
-					},
+					"names": mmdbNames(city.State2, city.Names.State2),
 				},
 			},
 		}
 
 		ipRanges := findIPRanges(city.IpRangeStart, city.IpRangeEnd)
+		for _, ipRange := range ipRanges {
+			err := writer.Insert(ipRange, record)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+func mmdbSaveReputations(reputations []IpReputation) {
+	mmdbInitWriter(datasetName("ip_reputation", ""), reputations[0].IpVersion, 24)
+
+	for _, reputation := range reputations {
+		tags := mmdbtype.Slice{}
+		for _, tag := range strings.Split(reputation.Tags, ",") {
+			tags = append(tags, mmdbtype.String(tag))
+		}
+
+		record := mmdbtype.Map{
+			"is_tor":		mmdbtype.Bool(reputation.IsTor),
+			"is_vpn":		mmdbtype.Bool(reputation.IsVpn),
+			"is_hosting":	mmdbtype.Bool(reputation.IsHosting),
+			"tags":			tags,
+		}
+
+		ipRanges := findIPRanges(reputation.IpRangeStart, reputation.IpRangeEnd)
+		for _, ipRange := range ipRanges {
+			err := mmDbWriter.Insert(ipRange, record)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+func mmdbSaveGeofeeds(geofeeds []IpGeofeed) {
+	mmdbInitWriter(datasetName("ip_geofeed", ""), geofeeds[0].IpVersion, 24)
+
+	for _, geofeed := range geofeeds {
+		record := mmdbtype.Map{
+			"country_code":	mmdbtype.String(geofeed.CountryCode),
+			"state1":		mmdbtype.String(geofeed.State1),
+			"city":			mmdbtype.String(geofeed.City),
+		}
+
+		ipRanges := findIPRanges(geofeed.IpRangeStart, geofeed.IpRangeEnd)
+		for _, ipRange := range ipRanges {
+			err := mmDbWriter.Insert(ipRange, record)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+func mmdbSaveConsensuses(consensuses []IpConsensus) {
+	mmdbInitWriter(datasetName("ip_consensus", ""), consensuses[0].IpVersion, 24)
+
+	for _, consensus := range consensuses {
+		dissent := mmdbtype.Slice{}
+		for _, source := range strings.Split(consensus.Dissent, ",") {
+			if len(source) > 0 {
+				dissent = append(dissent, mmdbtype.String(source))
+			}
+		}
+
+		record := mmdbtype.Map{
+			"country_code":	mmdbtype.String(consensus.CountryCode),
+			"confidence":	mmdbtype.Float64(consensus.Confidence),
+			"dissent":		dissent,
+		}
+
+		ipRanges := findIPRanges(consensus.IpRangeStart, consensus.IpRangeEnd)
 		for _, ipRange := range ipRanges {
 			err := mmDbWriter.Insert(ipRange, record)
 			if err != nil {
@@ -264,20 +691,184 @@ func mmdbSaveCities(cities []IpCity) {
 	}
 }
 
-func mmdbInitWriter(dbType string, ipVersion int, recordSize int) {
+func mmdbNames(name string, localised map[string]string) mmdbtype.Map {
+	names := mmdbtype.Map{ "en": mmdbtype.String(name) }
+	for language, localisedName := range localised {
+		names[mmdbtype.String(language)] = mmdbtype.String(localisedName)
+	}
+
+	return names
+}
+
+func mmdbInitWriter(name string, ipVersion int, recordSize int) {
 	if mmDbWriter == nil {
-		var err error
-		mmDbWriter, err = mmdbwriter.New(
-			mmdbwriter.Options{
-				DatabaseType:				os.Getenv(dbType) + "-ipv" + strconv.Itoa(ipVersion),
-				RecordSize:					recordSize,
-				IPVersion:					ipVersion,
-				IncludeReservedNetworks:	true,
-				DisableIPv4Aliasing:		true,
-			},
-		)
-		if err != nil {
-			panic(err)
+		mmDbWriter = mmdbNewWriter(name, ipVersion, recordSize)
+	}
+}
+
+func mmdbNewWriter(name string, ipVersion int, recordSize int) *mmdbwriter.Tree {
+	writer, err := mmdbwriter.New(
+		mmdbwriter.Options{
+			DatabaseType:				name + "-ipv" + strconv.Itoa(ipVersion),
+			RecordSize:					recordSize,
+			IPVersion:					ipVersion,
+			IncludeReservedNetworks:	true,
+			DisableIPv4Aliasing:		true,
+		},
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	return writer
+}
+
+func mmdbWriteFile(writer *mmdbwriter.Tree, filePath string) {
+	fileHandle, err := os.Create(filePath)
+	if err != nil {
+		panic(err)
+	}
+	defer fileHandle.Close()
+
+	slog.Info("writing mmdb file", "path", filePath)
+	_, err = writer.WriteTo(fileHandle)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mmdbFilePath(name string, ipVersion int) string {
+	return "downloads/" + name + "-ipv" + strconv.Itoa(ipVersion) + ".mmdb"
+}
+
+func mmdbRetainedPath(name string, ipVersion int, dbVersion int) string {
+	return "downloads/history/" + name + "-ipv" + strconv.Itoa(ipVersion) + "-v" + strconv.Itoa(dbVersion) + ".mmdb"
+}
+
+func mmdbRetainedId(name string, ipVersion int, dbVersion int) string {
+	return name + "ipv" + strconv.Itoa(ipVersion) + "-v" + strconv.Itoa(dbVersion)
+}
+
+// Retained files for the name and IP version, keyed by their version
+func mmdbRetainedFiles(name string, ipVersion int) map[int]string {
+	prefix		:= name + "-ipv" + strconv.Itoa(ipVersion) + "-v"
+	retained	:= map[int]string{}
+
+	filePaths, err := filepath.Glob("downloads/history/" + prefix + "*.mmdb")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, filePath := range filePaths {
+		dbVersion, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path.Base(filePath), prefix), ".mmdb"))
+		if err == nil {
+			retained[dbVersion] = filePath
 		}
 	}
+
+	return retained
+}
+
+// The mmdb files are rebuilt on every reload, so overrides are kept alongside them in a plain JSON file
+const mmdbOverridesPath = "downloads/overrides.json"
+
+func mmdbOverrides() []Override {
+	overrides := []Override{}
+	if !fileExists(mmdbOverridesPath) {
+		return overrides
+	}
+
+	err := json.Unmarshal([]byte(fileReadSmall(mmdbOverridesPath)), &overrides)
+	if err != nil {
+		panic(err)
+	}
+
+	return overrides
+}
+
+func mmdbSaveOverride(override Override) Override {
+	overrides := mmdbOverrides()
+
+	if override.Id == 0 {
+		for _, existing := range overrides {
+			override.Id = max(override.Id, existing.Id)
+		}
+		override.Id++
+		overrides = append(overrides, override)
+	} else {
+		for i, existing := range overrides {
+			if existing.Id == override.Id {
+				overrides[i] = override
+			}
+		}
+	}
+
+	mmdbWriteOverrides(overrides)
+
+	return override
+}
+
+func mmdbDeleteOverride(id int64) {
+	overrides := slices.DeleteFunc(mmdbOverrides(), func(override Override) bool {
+		return override.Id == id
+	})
+
+	mmdbWriteOverrides(overrides)
+}
+
+func mmdbWriteOverrides(overrides []Override) {
+	err := os.MkdirAll(path.Dir(mmdbOverridesPath), 0755)
+	if err != nil {
+		panic(err)
+	}
+
+	content, err := json.MarshalIndent(overrides, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+
+	fileWriteSmall(mmdbOverridesPath, string(content))
+}
+
+// Recorded loads are kept alongside the files in a plain JSON file, like the overrides
+const mmdbVersionsPath = "downloads/versions.json"
+
+func mmdbVersions() []DatasetVersion {
+	versions := []DatasetVersion{}
+	if !fileExists(mmdbVersionsPath) {
+		return versions
+	}
+
+	err := json.Unmarshal([]byte(fileReadSmall(mmdbVersionsPath)), &versions)
+	if err != nil {
+		panic(err)
+	}
+
+	return versions
+}
+
+func mmdbSaveVersion(version DatasetVersion) {
+	mmdbWriteVersions(append(mmdbVersions(), version))
+}
+
+func mmdbDeleteVersions(table string, source string, ipVersion int, dbVersion int) {
+	versions := slices.DeleteFunc(mmdbVersions(), func(version DatasetVersion) bool {
+		return version.Table == table && version.Source == source && version.IpVersion == ipVersion && version.DbVersion < dbVersion
+	})
+
+	mmdbWriteVersions(versions)
+}
+
+func mmdbWriteVersions(versions []DatasetVersion) {
+	err := os.MkdirAll(path.Dir(mmdbVersionsPath), 0755)
+	if err != nil {
+		panic(err)
+	}
+
+	content, err := json.MarshalIndent(versions, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+
+	fileWriteSmall(mmdbVersionsPath, string(content))
 }
\ No newline at end of file
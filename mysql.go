@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
-	"os"
+	"strconv"
 	"strings"
 
 	mysql "github.com/go-sql-driver/mysql"
@@ -15,10 +17,10 @@ var mysqlDb *sql.DB
 
 func mysqlConnect() {
 	config := mysql.Config{
-		User:					os.Getenv("DB_USER"),
-		Passwd:					os.Getenv("DB_PASS"),
-		Addr:					os.Getenv("DB_HOST") + ":" + os.Getenv("DB_PORT"),
-		DBName:					os.Getenv("DB_NAME"),
+		User:					config().DbUser,
+		Passwd:					config().DbPass,
+		Addr:					config().DbHost + ":" + strconv.Itoa(config().DbPort),
+		DBName:					config().DbName,
 		Net:					"tcp",
 		AllowNativePasswords:	true,
 	}
@@ -38,17 +40,25 @@ func mysqlClose() {
 	}
 }
 
-func mysqlInitialised(key string) bool {
+func mysqlInitialised(key string, source string) bool {
 	var table string
 	switch key {
 		case "COUNTRY": table = "ip_country"
 		case "ASN": 	table = "ip_asn"
 		case "CITY": 	table = "ip_city"
+		case "REPUTATION":	table = "ip_reputation"
+		case "GEOFEED":		table = "ip_geofeed"
+		case "CONSENSUS":	table = "ip_consensus"
 	}
 
 	var total int
+	var params []any
 	sqlString := fmt.Sprintf("SELECT COUNT(*) AS `total` FROM `%s` ", table)
-	row := mysqlDb.QueryRow(sqlString)
+	if len(source) > 0 {
+		sqlString += "WHERE `source` = ?"
+		params = append(params, source)
+	}
+	row := mysqlDb.QueryRow(sqlString, params...)
 	if err := row.Scan(&total); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false
@@ -60,107 +70,325 @@ func mysqlInitialised(key string) bool {
 	return total > 0
 }
 
-func mysqlIp(ip net.IP) *Ip {
+func mysqlCity(ctx context.Context, ip net.IP, source string, dbVersion int) (IpCity, bool) {
 	ipString	:= ip.String();
 	ipVersion	:= getIpVersion(ipString)
 	function	:= mysqlGetConversionFunction(ipVersion)
-	ipStruct	:= NewIp(ipString, ipVersion)
-
-	if hasCityDatabase() {
-		// Could also use: `SET SESSION sql_mode = 'ANSI_QUOTES';` but, meh
-		sqlString := fmt.Sprintf(`
-			SELECT		`+"`"+`country_code`+"`"+`,
-						`+"`"+`state1`+"`"+`,
-						`+"`"+`state2`+"`"+`,
-						`+"`"+`city`+"`"+`,
-						`+"`"+`postcode`+"`"+`,
-						`+"`"+`latitude`+"`"+`,
-						`+"`"+`longitude`+"`"+`,
-						`+"`"+`timezone`+"`"+`
-						
-			FROM		`+"`"+`ip_city`+"`"+`
-			 
-			WHERE		`+"`"+`ip_number_start`+"`"+` <= %s(?) 
-			
-			ORDER BY	`+"`"+`ip_number_start`+"`"+` DESC
-			 
-			LIMIT 		1`,
-			function)
-
-		row := mysqlDb.QueryRow(sqlString, ipString)
-		if err := row.Scan(&ipStruct.CountryCode, &ipStruct.State1, &ipStruct.State2, &ipStruct.City, &ipStruct.Postcode, &ipStruct.Latitude, &ipStruct.Longitude, &ipStruct.Timezone); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return ipStruct
-			}
+	city			:= IpCity{ Source: source }
+
+	// Could also use: `SET SESSION sql_mode = 'ANSI_QUOTES';` but, meh
+	sqlString := fmt.Sprintf(`
+		SELECT		`+"`"+`country_code`+"`"+`,
+					`+"`"+`state1`+"`"+`,
+					`+"`"+`state2`+"`"+`,
+					`+"`"+`city`+"`"+`,
+					`+"`"+`postcode`+"`"+`,
+					`+"`"+`latitude`+"`"+`,
+					`+"`"+`longitude`+"`"+`,
+					`+"`"+`timezone`+"`"+`,
+					COALESCE(`+"`"+`names`+"`"+`, '')
+
+		FROM		(
+						SELECT		*
+
+						FROM		`+"`"+`ip_city`+"`"+`
+
+						WHERE		`+"`"+`source`+"`"+` = ?
+						AND			`+"`"+`ip_number_start`+"`"+` <= %s(?)
+						AND			`+"`"+`ip_version`+"`"+` = ?
+						AND			(? = 0 OR `+"`"+`db_version`+"`"+` = ?)
+
+						ORDER BY	`+"`"+`ip_number_start`+"`"+` DESC
+
+						LIMIT		1
+					) AS `+"`"+`latest`+"`"+`
+
+		WHERE		`+"`"+`ip_number_end`+"`"+` >= %s(?)`,
+		function, function)
+
+	var names string
+	queryCtx, span := traceQuery(ctx, "ip_city", ipVersion)
+	row := mysqlDb.QueryRowContext(queryCtx, sqlString, source, ipString, ipVersion, dbVersion, dbVersion, ipString)
+	err := row.Scan(&city.CountryCode, &city.State1, &city.State2, &city.City, &city.Postcode, &city.Latitude, &city.Longitude, &city.Timezone, &names)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return city, false
+		}
 
-			panic(err)
+		panic(err)
+	}
+
+	city.Names = parseLocalisedNames(names)
+
+	return city, true
+}
+
+func mysqlCountry(ctx context.Context, ip net.IP, source string, dbVersion int) (IpCountry, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	function	:= mysqlGetConversionFunction(ipVersion)
+	country		:= IpCountry{ Source: source }
+
+	// Could also use: `SET SESSION sql_mode = 'ANSI_QUOTES';` but, meh
+	sqlString := fmt.Sprintf(`
+		SELECT		`+"`"+`country_code`+"`"+`
+
+		FROM		(
+						SELECT		*
+
+						FROM		`+"`"+`ip_country`+"`"+`
+
+						WHERE		`+"`"+`source`+"`"+` = ?
+						AND			`+"`"+`ip_number_start`+"`"+` <= %s(?)
+						AND			`+"`"+`ip_version`+"`"+` = ?
+						AND			(? = 0 OR `+"`"+`db_version`+"`"+` = ?)
+
+						ORDER BY	`+"`"+`ip_number_start`+"`"+` DESC
+
+						LIMIT		1
+					) AS `+"`"+`latest`+"`"+`
+
+		WHERE		`+"`"+`ip_number_end`+"`"+` >= %s(?)`,
+		function, function)
+	queryCtx, span := traceQuery(ctx, "ip_country", ipVersion)
+	row := mysqlDb.QueryRowContext(queryCtx, sqlString, source, ipString, ipVersion, dbVersion, dbVersion, ipString)
+	err := row.Scan(&country.CountryCode)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return country, false
 		}
 
-		if len(ipStruct.City) > 0 {
-			ipStruct.FoundCity = true
+		panic(err)
+	}
+
+	return country, true
+}
+
+func mysqlASN(ctx context.Context, ip net.IP, source string, dbVersion int) (IpASN, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	function	:= mysqlGetConversionFunction(ipVersion)
+	asn			:= IpASN{ Source: source }
+
+	// Could also use: `SET SESSION sql_mode = 'ANSI_QUOTES';` but, meh
+	sqlString := fmt.Sprintf(`
+		SELECT		`+"`"+`as_number`+"`"+`,
+					`+"`"+`as_organisation`+"`"+`
+
+		FROM		(
+						SELECT		*
+
+						FROM		`+"`"+`ip_asn`+"`"+`
+
+						WHERE		`+"`"+`source`+"`"+` = ?
+						AND			`+"`"+`ip_number_start`+"`"+` <= %s(?)
+						AND			`+"`"+`ip_version`+"`"+` = ?
+						AND			(? = 0 OR `+"`"+`db_version`+"`"+` = ?)
+
+						ORDER BY	`+"`"+`ip_number_start`+"`"+` DESC
+
+						LIMIT		1
+					) AS `+"`"+`latest`+"`"+`
+
+		WHERE		`+"`"+`ip_number_end`+"`"+` >= %s(?)`,
+		function, function)
+	queryCtx, span := traceQuery(ctx, "ip_asn", ipVersion)
+	row := mysqlDb.QueryRowContext(queryCtx, sqlString, source, ipString, ipVersion, dbVersion, dbVersion, ipString)
+	err := row.Scan(&asn.AsNumber, &asn.AsOrganisation)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return asn, false
 		}
+
+		panic(err)
 	}
 
-	if len(ipStruct.CountryCode) > 0 {
-		ipStruct.FoundCountry = true
-	} else {
-		if hasCountryDatabase() {
-			// Could also use: `SET SESSION sql_mode = 'ANSI_QUOTES';` but, meh
-			sqlString := fmt.Sprintf(`
-				SELECT		`+"`"+`country_code`+"`"+` 
-							
-				FROM		`+"`"+`ip_country`+"`"+`
-				 
-				WHERE		`+"`"+`ip_number_start`+"`"+` <= %s(?)
-				
-				ORDER BY	`+"`"+`ip_number_start`+"`"+` DESC
-				 
-				LIMIT		1`,
-				function)
-			row := mysqlDb.QueryRow(sqlString, ipString)
-			if err := row.Scan(&ipStruct.CountryCode); err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					return ipStruct
-				}
+	return asn, true
+}
 
-				panic(err)
-			}
+func mysqlReputation(ctx context.Context, ip net.IP, dbVersion int) (IpReputation, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	function	:= mysqlGetConversionFunction(ipVersion)
+	reputation	:= IpReputation{}
 
-			if len(ipStruct.CountryCode) > 0 {
-				ipStruct.FoundCountry = true
-			}
+	sqlString := fmt.Sprintf(`
+		SELECT		`+"`"+`is_tor`+"`"+`,
+					`+"`"+`is_vpn`+"`"+`,
+					`+"`"+`is_hosting`+"`"+`,
+					`+"`"+`tags`+"`"+`
+
+		FROM		(
+						SELECT		*
+
+						FROM		`+"`"+`ip_reputation`+"`"+`
+
+						WHERE		`+"`"+`ip_number_start`+"`"+` <= %s(?)
+						AND			`+"`"+`ip_version`+"`"+` = ?
+						AND			(? = 0 OR `+"`"+`db_version`+"`"+` = ?)
+
+						ORDER BY	`+"`"+`ip_number_start`+"`"+` DESC
+
+						LIMIT		1
+					) AS `+"`"+`latest`+"`"+`
+
+		WHERE		`+"`"+`ip_number_end`+"`"+` >= %s(?)`,
+		function, function)
+	queryCtx, span := traceQuery(ctx, "ip_reputation", ipVersion)
+	row := mysqlDb.QueryRowContext(queryCtx, sqlString, ipString, ipVersion, dbVersion, dbVersion, ipString)
+	err := row.Scan(&reputation.IsTor, &reputation.IsVpn, &reputation.IsHosting, &reputation.Tags)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return reputation, false
 		}
+
+		panic(err)
 	}
 
-	if hasASNDatabase() {
-		// Could also use: `SET SESSION sql_mode = 'ANSI_QUOTES';` but, meh
-		sqlString := fmt.Sprintf(`
-			SELECT		`+"`"+`as_number`+"`"+`,
-						`+"`"+`as_organisation`+"`"+` 
-						
-			FROM		`+"`"+`ip_asn`+"`"+`
-			 
-			WHERE		`+"`"+`ip_number_start`+"`"+` <= %s(?) 
-
-			ORDER BY	`+"`"+`ip_number_start`+"`"+` DESC
-			 
-			LIMIT		1`,
-			function)
-		row := mysqlDb.QueryRow(sqlString, ipString)
-		if err := row.Scan(&ipStruct.OrganisationNumber, &ipStruct.OrganisationName); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return ipStruct
-			}
+	return reputation, true
+}
 
-			panic(err)
+func mysqlGeofeed(ctx context.Context, ip net.IP, dbVersion int) (IpGeofeed, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	function	:= mysqlGetConversionFunction(ipVersion)
+	geofeed		:= IpGeofeed{}
+
+	sqlString := fmt.Sprintf(`
+		SELECT		`+"`"+`country_code`+"`"+`,
+					`+"`"+`state1`+"`"+`,
+					`+"`"+`city`+"`"+`
+
+		FROM		(
+						SELECT		*
+
+						FROM		`+"`"+`ip_geofeed`+"`"+`
+
+						WHERE		`+"`"+`ip_number_start`+"`"+` <= %s(?)
+						AND			`+"`"+`ip_version`+"`"+` = ?
+						AND			(? = 0 OR `+"`"+`db_version`+"`"+` = ?)
+
+						ORDER BY	`+"`"+`ip_number_start`+"`"+` DESC
+
+						LIMIT		1
+					) AS `+"`"+`latest`+"`"+`
+
+		WHERE		`+"`"+`ip_number_end`+"`"+` >= %s(?)`,
+		function, function)
+	queryCtx, span := traceQuery(ctx, "ip_geofeed", ipVersion)
+	row := mysqlDb.QueryRowContext(queryCtx, sqlString, ipString, ipVersion, dbVersion, dbVersion, ipString)
+	err := row.Scan(&geofeed.CountryCode, &geofeed.State1, &geofeed.City)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return geofeed, false
+		}
+
+		panic(err)
+	}
+
+	return geofeed, true
+}
+
+func mysqlConsensus(ctx context.Context, ip net.IP, dbVersion int) (IpConsensus, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	function	:= mysqlGetConversionFunction(ipVersion)
+	consensus	:= IpConsensus{}
+
+	sqlString := fmt.Sprintf(`
+		SELECT		`+"`"+`country_code`+"`"+`,
+					`+"`"+`confidence`+"`"+`,
+					`+"`"+`dissent`+"`"+`
+
+		FROM		(
+						SELECT		*
+
+						FROM		`+"`"+`ip_consensus`+"`"+`
+
+						WHERE		`+"`"+`ip_number_start`+"`"+` <= %s(?)
+						AND			`+"`"+`ip_version`+"`"+` = ?
+						AND			(? = 0 OR `+"`"+`db_version`+"`"+` = ?)
+
+						ORDER BY	`+"`"+`ip_number_start`+"`"+` DESC
+
+						LIMIT		1
+					) AS `+"`"+`latest`+"`"+`
+
+		WHERE		`+"`"+`ip_number_end`+"`"+` >= %s(?)`,
+		function, function)
+	queryCtx, span := traceQuery(ctx, "ip_consensus", ipVersion)
+	row := mysqlDb.QueryRowContext(queryCtx, sqlString, ipString, ipVersion, dbVersion, dbVersion, ipString)
+	err := row.Scan(&consensus.CountryCode, &consensus.Confidence, &consensus.Dissent)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return consensus, false
 		}
 
-		if len(ipStruct.OrganisationName) > 0 {
-			ipStruct.FoundASN = true
+		panic(err)
+	}
+
+	return consensus, true
+}
+
+func mysqlSampleRanges(table string, source string, ipVersion int, dbVersion int) []SampleRange {
+	sqlString := fmt.Sprintf("SELECT `ip_range_start`, `ip_range_end`, `%s` FROM `%s` WHERE `ip_version` = ? AND `source` = ? AND (? = 0 OR `db_version` = ?) ORDER BY `ip_number_start`", sampleValueColumn(table), table)
+	rows, err := mysqlDb.Query(sqlString, ipVersion, source, dbVersion, dbVersion)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return sampleRangesFromRows(rows)
+}
+
+func mysqlDiffRanges(table string, source string, ipVersion int, dbVersion int) []SampleRange {
+	columns		:= diffColumns(table)
+	sqlString	:= "SELECT `ip_range_start`, `ip_range_end`"
+	for _, column := range columns {
+		sqlString += fmt.Sprintf(", `%s`", column)
+	}
+	sqlString += fmt.Sprintf(" FROM `%s` WHERE `ip_version` = ? AND `db_version` = ?", table)
+	params := []any{ ipVersion, dbVersion }
+	if len(source) > 0 {
+		sqlString += " AND `source` = ?"
+		params = append(params, source)
+	}
+	sqlString += " ORDER BY `ip_number_start`"
+
+	rows, err := mysqlDb.Query(sqlString, params...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return diffRangesFromRows(rows, len(columns))
+}
+
+// Names are only filled in when a localisation source is loaded
+func mysqlExport(table string, source string, ipVersion int, dbVersion int, read func(*sql.Rows)) {
+	sqlString := "SELECT `ip_range_start`, `ip_range_end`"
+	for _, column := range exportColumns(table) {
+		if column == "names" {
+			sqlString += ", COALESCE(`names`, '')"
+		} else {
+			sqlString += fmt.Sprintf(", `%s`", column)
 		}
 	}
+	sqlString += fmt.Sprintf(" FROM `%s` WHERE `ip_version` = ? AND `source` = ? AND (? = 0 OR `db_version` = ?) ORDER BY `ip_number_start`", table)
 
-	return ipStruct
+	rows, err := mysqlDb.Query(sqlString, ipVersion, source, dbVersion, dbVersion)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	read(rows)
 }
 
 func mysqlQueryMaxVersion(table string, ipVersion int) int {
@@ -179,24 +407,29 @@ func mysqlQueryMaxVersion(table string, ipVersion int) int {
 	return version
 }
 
-func mysqlDropOld(table string, ipVersion int, dbVersion int) {
-	fmt.Printf("Dropping old MySQL data: `%s` ipv%d... ", table, ipVersion)
-	sqlString := fmt.Sprintf("DELETE FROM `%s` WHERE `ip_version` = ? AND `db_version` < ?", table)
-	_, err := mysqlDb.Exec(sqlString, ipVersion, dbVersion)
+func mysqlDropOld(table string, source string, ipVersion int, dbVersion int) {
+	slog.Info("dropping old data", "db", "mysql", "table", table, "source", source, "ip_version", ipVersion, "db_version", dbVersion)
+	sqlString	:= fmt.Sprintf("DELETE FROM `%s` WHERE `ip_version` = ? AND `db_version` < ?", table)
+	params		:= []any{ ipVersion, dbVersion }
+	if len(source) > 0 {
+		sqlString += " AND `source` = ?"
+		params = append(params, source)
+	}
+	_, err := mysqlDb.Exec(sqlString, params...)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("Complete")
+	slog.Info("dropped old data", "db", "mysql", "table", table, "ip_version", ipVersion)
 }
 
 func mysqlSaveCountries(countries []IpCountry) {
 	var params []any
 
 	function := mysqlGetConversionFunction(countries[0].IpVersion)
-	sqlString := "INSERT INTO `ip_country` (`ip_range_start`, `ip_range_end`, `ip_number_start`, `ip_number_end`, `country_code`, `ip_version`, `db_version`) VALUES "
+	sqlString := "INSERT INTO `ip_country` (`ip_range_start`, `ip_range_end`, `ip_number_start`, `ip_number_end`, `country_code`, `ip_version`, `db_version`, `source`) VALUES "
 	for _, country := range countries {
-		sqlString += `(?, ?, ` + function + `(?), ` + function + `(?), ?, ?, ?), `
-		params = append(params, country.IpRangeStart, country.IpRangeEnd, country.IpRangeStart, country.IpRangeEnd, country.CountryCode, country.IpVersion, country.DbVersion)
+		sqlString += `(?, ?, ` + function + `(?), ` + function + `(?), ?, ?, ?, ?), `
+		params = append(params, country.IpRangeStart, country.IpRangeEnd, country.IpRangeStart, country.IpRangeEnd, country.CountryCode, country.IpVersion, country.DbVersion, country.Source)
 	}
 	sqlString = sqlString[0:len(sqlString) - 2]
 
@@ -216,10 +449,10 @@ func mysqlSaveASNs(ASNs []IpASN) {
 	var params []any
 
 	function := mysqlGetConversionFunction(ASNs[0].IpVersion)
-	sqlString := "INSERT INTO `ip_asn` (`ip_range_start`, `ip_range_end`, `ip_number_start`, `ip_number_end`, `as_number`, `as_organisation`, `ip_version`, `db_version`) VALUES "
+	sqlString := "INSERT INTO `ip_asn` (`ip_range_start`, `ip_range_end`, `ip_number_start`, `ip_number_end`, `as_number`, `as_organisation`, `ip_version`, `db_version`, `source`) VALUES "
 	for _, asn := range ASNs {
-		sqlString += `(?, ?, ` + function + `(?), ` + function + `(?), ?, ?, ?, ?), `
-		params = append(params, asn.IpRangeStart, asn.IpRangeEnd, asn.IpRangeStart, asn.IpRangeEnd, asn.AsNumber, asn.AsOrganisation, asn.IpVersion, asn.DbVersion)
+		sqlString += `(?, ?, ` + function + `(?), ` + function + `(?), ?, ?, ?, ?, ?), `
+		params = append(params, asn.IpRangeStart, asn.IpRangeEnd, asn.IpRangeStart, asn.IpRangeEnd, asn.AsNumber, asn.AsOrganisation, asn.IpVersion, asn.DbVersion, asn.Source)
 	}
 	sqlString = sqlString[0:len(sqlString) - 2]
 
@@ -239,10 +472,79 @@ func mysqlSaveCities(cities []IpCity) {
 	var params []any
 
 	function := mysqlGetConversionFunction(cities[0].IpVersion)
-	sqlString := "INSERT INTO `ip_city` (`ip_range_start`, `ip_range_end`, `ip_number_start`, `ip_number_end`, `country_code`, `state1`, `state2`, `city`, `postcode`, `latitude`, `longitude`, `timezone`, `ip_version`, `db_version`) VALUES "
+	sqlString := "INSERT INTO `ip_city` (`ip_range_start`, `ip_range_end`, `ip_number_start`, `ip_number_end`, `country_code`, `state1`, `state2`, `city`, `postcode`, `latitude`, `longitude`, `timezone`, `names`, `ip_version`, `db_version`, `source`) VALUES "
 	for _, city := range cities {
-		sqlString += `(?, ?, ` + function + `(?), ` + function + `(?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?), `
-		params = append(params, city.IpRangeStart, city.IpRangeEnd, city.IpRangeStart, city.IpRangeEnd, city.CountryCode, city.State1, city.State2, city.City, city.Postcode, city.Latitude, city.Longitude, city.Timezone, city.IpVersion, city.DbVersion)
+		sqlString += `(?, ?, ` + function + `(?), ` + function + `(?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?), `
+		params = append(params, city.IpRangeStart, city.IpRangeEnd, city.IpRangeStart, city.IpRangeEnd, city.CountryCode, city.State1, city.State2, city.City, city.Postcode, city.Latitude, city.Longitude, city.Timezone, city.Names.String(), city.IpVersion, city.DbVersion, city.Source)
+	}
+	sqlString = sqlString[0:len(sqlString) - 2]
+
+	stmt, err := mysqlDb.Prepare(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(params...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mysqlSaveReputations(reputations []IpReputation) {
+	var params []any
+
+	function := mysqlGetConversionFunction(reputations[0].IpVersion)
+	sqlString := "INSERT INTO `ip_reputation` (`ip_range_start`, `ip_range_end`, `ip_number_start`, `ip_number_end`, `is_tor`, `is_vpn`, `is_hosting`, `tags`, `ip_version`, `db_version`) VALUES "
+	for _, reputation := range reputations {
+		sqlString += `(?, ?, ` + function + `(?), ` + function + `(?), ?, ?, ?, ?, ?, ?), `
+		params = append(params, reputation.IpRangeStart, reputation.IpRangeEnd, reputation.IpRangeStart, reputation.IpRangeEnd, reputation.IsTor, reputation.IsVpn, reputation.IsHosting, reputation.Tags, reputation.IpVersion, reputation.DbVersion)
+	}
+	sqlString = sqlString[0:len(sqlString) - 2]
+
+	stmt, err := mysqlDb.Prepare(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(params...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mysqlSaveGeofeeds(geofeeds []IpGeofeed) {
+	var params []any
+
+	function := mysqlGetConversionFunction(geofeeds[0].IpVersion)
+	sqlString := "INSERT INTO `ip_geofeed` (`ip_range_start`, `ip_range_end`, `ip_number_start`, `ip_number_end`, `country_code`, `state1`, `city`, `ip_version`, `db_version`) VALUES "
+	for _, geofeed := range geofeeds {
+		sqlString += `(?, ?, ` + function + `(?), ` + function + `(?), ?, ?, ?, ?, ?), `
+		params = append(params, geofeed.IpRangeStart, geofeed.IpRangeEnd, geofeed.IpRangeStart, geofeed.IpRangeEnd, geofeed.CountryCode, geofeed.State1, geofeed.City, geofeed.IpVersion, geofeed.DbVersion)
+	}
+	sqlString = sqlString[0:len(sqlString) - 2]
+
+	stmt, err := mysqlDb.Prepare(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(params...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mysqlSaveConsensuses(consensuses []IpConsensus) {
+	var params []any
+
+	function := mysqlGetConversionFunction(consensuses[0].IpVersion)
+	sqlString := "INSERT INTO `ip_consensus` (`ip_range_start`, `ip_range_end`, `ip_number_start`, `ip_number_end`, `country_code`, `confidence`, `dissent`, `ip_version`, `db_version`) VALUES "
+	for _, consensus := range consensuses {
+		sqlString += `(?, ?, ` + function + `(?), ` + function + `(?), ?, ?, ?, ?, ?), `
+		params = append(params, consensus.IpRangeStart, consensus.IpRangeEnd, consensus.IpRangeStart, consensus.IpRangeEnd, consensus.CountryCode, consensus.Confidence, consensus.Dissent, consensus.IpVersion, consensus.DbVersion)
 	}
 	sqlString = sqlString[0:len(sqlString) - 2]
 
@@ -258,6 +560,54 @@ func mysqlSaveCities(cities []IpCity) {
 	}
 }
 
+func mysqlMigrate() {
+	mysqlAddColumn("ip_city", "names", "text NULL AFTER `timezone`")
+
+	for _, table := range []string{ "ip_country", "ip_city", "ip_asn" } {
+		mysqlAddSource(table, datasetType(table))
+	}
+}
+
+// Rows loaded before sources were recorded came from the (then single) configured source
+func mysqlAddSource(table string, key string) {
+	if !mysqlAddColumn(table, "source", "varchar(64) NOT NULL DEFAULT '' AFTER `db_version`") {
+		return
+	}
+
+	_, err := mysqlDb.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD KEY `source` (`source`, `ip_number_start`)", table))
+	if err != nil {
+		panic(err)
+	}
+
+	if len(datasetSources(key)) > 0 {
+		_, err := mysqlDb.Exec(fmt.Sprintf("UPDATE `%s` SET `source` = ? WHERE `source` = ''", table), datasetSources(key)[0])
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// MySQL (unlike MariaDB) has no `ADD COLUMN IF NOT EXISTS`
+func mysqlAddColumn(table string, column string, definition string) bool {
+	var total int
+	row := mysqlDb.QueryRow("SELECT COUNT(*) FROM `information_schema`.`COLUMNS` WHERE `TABLE_SCHEMA` = DATABASE() AND `TABLE_NAME` = ? AND `COLUMN_NAME` = ?", table, column)
+	if err := row.Scan(&total); err != nil {
+		panic(err)
+	}
+
+	if total == 0 {
+		slog.Info("adding column", "db", "mysql", "table", table, "column", column)
+		_, err := mysqlDb.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", table, column, definition))
+		if err != nil {
+			panic(err)
+		}
+
+		return true
+	}
+
+	return false
+}
+
 func mysqlFile(sqlPath string) {
 	sqlBytes, err := dbStructures.ReadFile(sqlPath)
 	//sqlBytes, err := os.ReadFile(sqlPath)
@@ -299,4 +649,68 @@ func mysqlGetConversionFunction(ipVersion int) string {
 	}
 
 	return function
+}
+
+func mysqlOverrides() []Override {
+	rows, err := mysqlDb.Query("SELECT `id`, `network`, `record` FROM `ip_override` ORDER BY `id`")
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return overridesFromRows(rows)
+}
+
+func mysqlSaveOverride(override Override) Override {
+	if override.Id == 0 {
+		result, err := mysqlDb.Exec("INSERT INTO `ip_override` (`network`, `record`) VALUES (?, ?)", override.Network, overrideRecord(override))
+		if err != nil {
+			panic(err)
+		}
+
+		override.Id, err = result.LastInsertId()
+		if err != nil {
+			panic(err)
+		}
+
+		return override
+	}
+
+	_, err := mysqlDb.Exec("UPDATE `ip_override` SET `network` = ?, `record` = ? WHERE `id` = ?", override.Network, overrideRecord(override), override.Id)
+	if err != nil {
+		panic(err)
+	}
+
+	return override
+}
+
+func mysqlDeleteOverride(id int64) {
+	_, err := mysqlDb.Exec("DELETE FROM `ip_override` WHERE `id` = ?", id)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mysqlVersions() []DatasetVersion {
+	rows, err := mysqlDb.Query("SELECT `dataset`, `source`, `ip_version`, `db_version`, `loaded_at` FROM `ip_load`")
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return versionsFromRows(rows)
+}
+
+func mysqlSaveVersion(version DatasetVersion) {
+	_, err := mysqlDb.Exec("INSERT INTO `ip_load` (`dataset`, `source`, `ip_version`, `db_version`, `loaded_at`) VALUES (?, ?, ?, ?, ?)", version.Table, version.Source, version.IpVersion, version.DbVersion, version.LoadedAt.Unix())
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mysqlDeleteVersions(table string, source string, ipVersion int, dbVersion int) {
+	_, err := mysqlDb.Exec("DELETE FROM `ip_load` WHERE `dataset` = ? AND `source` = ? AND `ip_version` = ? AND `db_version` < ?", table, source, ipVersion, dbVersion)
+	if err != nil {
+		panic(err)
+	}
 }
\ No newline at end of file
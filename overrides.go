@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Overrides are few and checked on every lookup, so they're held in memory and reloaded from the backend after each change
+var overrides		[]Override
+var overridesMutex	sync.RWMutex
+
+func overridesLoad() {
+	loaded := dbOverrides()
+	for i := range loaded {
+		loaded[i].prefix = netip.MustParsePrefix(loaded[i].Network)
+	}
+
+	overridesMutex.Lock()
+	defer overridesMutex.Unlock()
+
+	overrides = loaded
+}
+
+// The id and network have their own columns, everything else is kept as JSON so new fields don't need a migration
+func overrideRecord(override Override) string {
+	record, err := json.Marshal(override)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(record)
+}
+
+func overridesFromRows(rows *sql.Rows) []Override {
+	overrides := []Override{}
+
+	for rows.Next() {
+		var override Override
+		var id int64
+		var network, record string
+
+		err := rows.Scan(&id, &network, &record)
+		if err != nil {
+			panic(err)
+		}
+
+		err = json.Unmarshal([]byte(record), &override)
+		if err != nil {
+			panic(err)
+		}
+		override.Id			= id
+		override.Network	= network
+
+		overrides = append(overrides, override)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return overrides
+}
+
+func overrideList() []Override {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+
+	list := make([]Override, len(overrides))
+	copy(list, overrides)
+
+	return list
+}
+
+func overrideFind(id int64) (Override, bool) {
+	for _, override := range overrideList() {
+		if override.Id == id {
+			return override, true
+		}
+	}
+
+	return Override{}, false
+}
+
+// Creates the override when it has no id, otherwise replaces the existing one
+func overrideSave(override Override) (Override, error) {
+	err := override.validate()
+	if err != nil {
+		return override, err
+	}
+
+	if override.Id > 0 {
+		if _, ok := overrideFind(override.Id); !ok {
+			return override, errors.New("override not found")
+		}
+	}
+
+	override.UpdatedAt	= time.Now().UTC().Truncate(time.Second)
+	override			= dbSaveOverride(override)
+	overridesLoad()
+
+	return override, nil
+}
+
+func overrideDelete(id int64) error {
+	if _, ok := overrideFind(id); !ok {
+		return errors.New("override not found")
+	}
+
+	dbDeleteOverride(id)
+	overridesLoad()
+
+	return nil
+}
+
+// Normalises the network (a bare address is treated as a single host) and checks the values are usable
+func (override *Override) validate() error {
+	prefix, err := netip.ParsePrefix(override.Network)
+	if err != nil {
+		address, addressErr := netip.ParseAddr(override.Network)
+		if addressErr != nil {
+			return errors.New("network must be a CIDR network or an IP address")
+		}
+		prefix = netip.PrefixFrom(address, address.BitLen())
+	}
+	override.prefix		= prefix.Masked()
+	override.Network	= override.prefix.String()
+
+	if override.CountryCode == nil && override.State1 == nil && override.State2 == nil && override.City == nil && override.Postcode == nil &&
+	override.Latitude == nil && override.Longitude == nil && override.Timezone == nil && override.AsNumber == nil && override.AsOrganisation == nil {
+		return errors.New("at least one location or ASN field must be set")
+	}
+
+	if override.CountryCode != nil {
+		countryCode := strings.ToUpper(*override.CountryCode)
+		if _, ok := countries[countryCode]; !ok {
+			return errors.New("country_code must be a known 2 letter ISO code")
+		}
+		override.CountryCode = &countryCode
+	}
+
+	if (override.Latitude == nil) != (override.Longitude == nil) {
+		return errors.New("lat and lon must be set together")
+	}
+
+	if override.Latitude != nil && (*override.Latitude < -90 || *override.Latitude > 90 || *override.Longitude < -180 || *override.Longitude > 180) {
+		return errors.New("lat must be between -90 and 90 and lon between -180 and 180")
+	}
+
+	if override.Timezone != nil && len(*override.Timezone) > 0 {
+		if _, ok := timezoneLocation(*override.Timezone); !ok {
+			return errors.New("timezone must be a valid IANA timezone name")
+		}
+	}
+
+	if override.AsNumber != nil && *override.AsNumber < 0 {
+		return errors.New("as_number must be positive")
+	}
+
+	return nil
+}
+
+// The most specific override containing the address
+func overrideMatch(address netip.Addr) (Override, bool) {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+
+	var match *Override
+	for i, override := range overrides {
+		if override.prefix.Contains(address) && (match == nil || override.prefix.Bits() > match.prefix.Bits()) {
+			match = &overrides[i]
+		}
+	}
+
+	if match == nil {
+		return Override{}, false
+	}
+
+	return *match, true
+}
+
+// The address as given is checked before the IPv4 address it carries, so either can be overridden
+func (ip *Ip) applyOverride(address netip.Addr, effective netip.Addr) {
+	override, ok := overrideMatch(address)
+	if !ok {
+		override, ok = overrideMatch(effective)
+	}
+	if !ok {
+		return
+	}
+
+	ip.Overridden = true
+
+	// The dataset's location and the consensus behind it were for another country
+	if override.CountryCode != nil && *override.CountryCode != ip.CountryCode {
+		ip.clearCity()
+		ip.Confidence			= 0
+		ip.DissentingSources	= nil
+	}
+
+	if override.CountryCode != nil {
+		ip.CountryCode		= *override.CountryCode
+		ip.FoundCountry		= len(ip.CountryCode) > 0
+		ip.Sources.Country	= "override"
+	}
+
+	// The dataset's translations would no longer describe the same place
+	if override.State1 != nil {
+		ip.State1		= *override.State1
+		ip.names.State1	= nil
+	}
+	if override.State2 != nil {
+		ip.State2		= *override.State2
+		ip.names.State2	= nil
+	}
+	if override.City != nil {
+		ip.City			= *override.City
+		ip.names.City	= nil
+		ip.FoundCity	= len(ip.City) > 0
+		ip.Sources.City	= "override"
+	}
+	if override.Postcode != nil {
+		ip.Postcode = *override.Postcode
+	}
+
+	// New coordinates without a timezone leave it to be inferred again
+	if override.Latitude != nil {
+		ip.Latitude		= *override.Latitude
+		ip.Longitude	= *override.Longitude
+		ip.FoundCity	= true
+		ip.Timezone		= ""
+		ip.Sources.City	= "override"
+	}
+	if override.Timezone != nil {
+		ip.Timezone = *override.Timezone
+	}
+	ip.TimezoneInferred = false
+
+	if override.AsNumber != nil {
+		ip.OrganisationNumber	= *override.AsNumber
+		ip.FoundASN				= ip.OrganisationNumber > 0
+		ip.Sources.ASN			= "override"
+	}
+	if override.AsOrganisation != nil {
+		ip.OrganisationName	= *override.AsOrganisation
+		ip.Sources.ASN		= "override"
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestApplyOverrideCountry(t *testing.T) {
+	france	:= "FR"
+	germany	:= "DE"
+	paris	:= "Paris"
+
+	tests := []struct {
+		name		string
+		override	Override
+		city		string
+		latitude	float64
+		confidence	float64
+	}{
+		// A new country leaves nothing of the dataset's location
+		{ "other country", Override{ CountryCode: &france }, "", 0, 0 },
+		{ "other country and city", Override{ CountryCode: &france, City: &paris }, "Paris", 0, 0 },
+		{ "same country", Override{ CountryCode: &germany }, "Munich", 48.1, 0.8 },
+		{ "city only", Override{ City: &paris }, "Paris", 48.1, 0.8 },
+	}
+
+	for _, test := range tests {
+		override		:= test.override
+		override.prefix	= netip.MustParsePrefix("1.0.0.0/24")
+		overrides		= []Override{ override }
+
+		ip := NewIp("1.0.0.1", 4)
+		ip.CountryCode, ip.FoundCountry		= "DE", true
+		ip.City, ip.State1, ip.FoundCity	= "Munich", "Bavaria", true
+		ip.Latitude, ip.Longitude			= 48.1, 11.5
+		ip.Timezone							= "Europe/Berlin"
+		ip.Confidence, ip.DissentingSources	= 0.8, []string{ "dbip-country" }
+
+		address := netip.MustParseAddr("1.0.0.1")
+		ip.applyOverride(address, address)
+
+		if ip.City != test.city || ip.Latitude != test.latitude || ip.Confidence != test.confidence {
+			t.Errorf("%s: got %q at %v with confidence %v, expected %q at %v with confidence %v", test.name, ip.City, ip.Latitude, ip.Confidence, test.city, test.latitude, test.confidence)
+		}
+
+		if test.confidence == 0 && (len(ip.DissentingSources) > 0 || len(ip.Timezone) > 0 || len(ip.State1) > 0) {
+			t.Errorf("%s: dataset fields kept: %v, %q, %q", test.name, ip.DissentingSources, ip.Timezone, ip.State1)
+		}
+	}
+
+	overrides = nil
+}
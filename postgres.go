@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
-	"os"
 	"strconv"
 	"strings"
 
@@ -15,7 +16,7 @@ import (
 var pgDb *sql.DB
 
 func postgresConnect() {
-	connStr		:= fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable", os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"))
+	connStr		:= fmt.Sprintf("postgresql://%s:%s@%s:%d/%s?sslmode=disable", config().DbUser, config().DbPass, config().DbHost, config().DbPort, config().DbName)
 	conn, err	:= sql.Open("postgres", connStr)
 	if err != nil {
 		panic(err)
@@ -31,22 +32,30 @@ func postgresClose() {
 	}
 }
 
-func postgresInitialised(key string) bool {
+func postgresInitialised(key string, source string) bool {
 	var table string
 	switch key {
 		case "COUNTRY": table = "ip_country"
 		case "ASN": 	table = "ip_asn"
 		case "CITY": 	table = "ip_city"
+		case "REPUTATION":	table = "ip_reputation"
+		case "GEOFEED":		table = "ip_geofeed"
+		case "CONSENSUS":	table = "ip_consensus"
 	}
 
 	var total int
+	var params []any
 	sqlString := fmt.Sprintf(`
 		SELECT		COUNT(*) AS "total"
 		
 		FROM		"%s"."%s"
 		`,
-		os.Getenv("DB_SCHEMA"), table)
-	row := pgDb.QueryRow(sqlString)
+		config().DbSchema, table)
+	if len(source) > 0 {
+		sqlString += `WHERE "source" = $1`
+		params = append(params, source)
+	}
+	row := pgDb.QueryRow(sqlString, params...)
 	if err := row.Scan(&total); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false
@@ -58,105 +67,291 @@ func postgresInitialised(key string) bool {
 	return total > 0
 }
 
-func postgresIp(ip net.IP) *Ip {
+func postgresCity(ctx context.Context, ip net.IP, source string, dbVersion int) (IpCity, bool) {
 	ipString	:= ip.String();
 	ipVersion	:= getIpVersion(ipString)
-	ipStruct	:= NewIp(ipString, ipVersion)
-
-	if hasCityDatabase() {
-		sqlString := fmt.Sprintf(`
-			SELECT		"country_code", 
-						"state1", 
-						"state2", 
-						"city", 
-						"postcode", 
-						"latitude", 
-						"longitude", 
-						"timezone"
-						
-			FROM		"%s"."ip_city"
-			 
-			WHERE		"ip_range_start"	<= $1 
-			AND			"ip_range_end"		>= $1
+	city			:= IpCity{ Source: source }
+
+	sqlString := fmt.Sprintf(`
+		SELECT		"country_code", 
+					"state1", 
+					"state2", 
+					"city", 
+					"postcode", 
+					"latitude", 
+					"longitude", 
+					"timezone",
+					COALESCE("names", '')
+					
+		FROM		"%s"."ip_city"
+		 
+		WHERE		"ip_range_start"	<= $1 
+		AND			"ip_range_end"		>= $1
+		AND			"source"			= $2
+		AND			($3 = 0 OR "db_version" = $3)
+
+		ORDER BY	"ip_range_start" DESC
+		 
+		LIMIT		1`,
+		config().DbSchema)
+	var names string
+	queryCtx, span := traceQuery(ctx, "ip_city", ipVersion)
+	row := pgDb.QueryRowContext(queryCtx, sqlString, ipString, source, dbVersion)
+	err := row.Scan(&city.CountryCode, &city.State1, &city.State2, &city.City, &city.Postcode, &city.Latitude, &city.Longitude, &city.Timezone, &names)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return city, false
+		}
 
-			ORDER BY	"ip_range_start" DESC
+		panic(err)
+	}
+
+	city.Names = parseLocalisedNames(names)
+
+	return city, true
+}
+
+func postgresCountry(ctx context.Context, ip net.IP, source string, dbVersion int) (IpCountry, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	country		:= IpCountry{ Source: source }
+
+	sqlString := fmt.Sprintf(`
+		SELECT		"country_code" 
+					
+		FROM		"%s"."ip_country"
+		 
+		WHERE		"ip_range_start"	<= $1
+		AND			"ip_range_end"		>= $1
+		AND			"source"			= $2
+		AND			($3 = 0 OR "db_version" = $3)
+		
+		ORDER BY	"ip_range_start" DESC
 			 
-			LIMIT		1`,
-			os.Getenv("DB_SCHEMA"))
-		row := pgDb.QueryRow(sqlString, ipString)
-		if err := row.Scan(&ipStruct.CountryCode, &ipStruct.State1, &ipStruct.State2, &ipStruct.City, &ipStruct.Postcode, &ipStruct.Latitude, &ipStruct.Longitude, &ipStruct.Timezone); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return ipStruct
-			}
+		LIMIT		1`,
+		config().DbSchema)
+	queryCtx, span := traceQuery(ctx, "ip_country", ipVersion)
+	row := pgDb.QueryRowContext(queryCtx, sqlString, ipString, source, dbVersion)
+	err := row.Scan(&country.CountryCode)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return country, false
+		}
 
-			panic(err)
+		panic(err)
+	}
+
+	return country, true
+}
+
+func postgresASN(ctx context.Context, ip net.IP, source string, dbVersion int) (IpASN, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	asn			:= IpASN{ Source: source }
+
+	sqlString := fmt.Sprintf(`
+		SELECT		"as_number",
+					"as_organisation" 
+					
+		FROM		"%s"."ip_asn"
+		 
+		WHERE		"ip_range_start"	<= $1
+		AND			"ip_range_end"		>= $1
+		AND			"source"			= $2
+		AND			($3 = 0 OR "db_version" = $3)
+		
+		ORDER BY	"ip_range_start" DESC
+		 
+		LIMIT		1`,
+		config().DbSchema)
+	queryCtx, span := traceQuery(ctx, "ip_asn", ipVersion)
+	row := pgDb.QueryRowContext(queryCtx, sqlString, ipString, source, dbVersion)
+	err := row.Scan(&asn.AsNumber, &asn.AsOrganisation)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return asn, false
 		}
 
-		if len(ipStruct.City) > 0 {
-			ipStruct.FoundCity = true
+		panic(err)
+	}
+
+	return asn, true
+}
+
+func postgresReputation(ctx context.Context, ip net.IP, dbVersion int) (IpReputation, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	reputation	:= IpReputation{}
+
+	sqlString := fmt.Sprintf(`
+		SELECT		"is_tor",
+					"is_vpn",
+					"is_hosting",
+					COALESCE("tags", '')
+
+		FROM		"%s"."ip_reputation"
+
+		WHERE		"ip_range_start"	<= $1
+		AND			"ip_range_end"		>= $1
+		AND			($2 = 0 OR "db_version" = $2)
+
+		ORDER BY	"ip_range_start" DESC
+
+		LIMIT		1`,
+		config().DbSchema)
+	queryCtx, span := traceQuery(ctx, "ip_reputation", ipVersion)
+	row := pgDb.QueryRowContext(queryCtx, sqlString, ipString, dbVersion)
+	err := row.Scan(&reputation.IsTor, &reputation.IsVpn, &reputation.IsHosting, &reputation.Tags)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return reputation, false
 		}
+
+		panic(err)
 	}
 
-	if len(ipStruct.CountryCode) > 0 {
-		ipStruct.FoundCountry = true
-	} else {
-		if hasCountryDatabase() {
-			sqlString := fmt.Sprintf(`
-				SELECT		"country_code" 
-							
-				FROM		"%s"."ip_country"
-				 
-				WHERE		"ip_range_start"	<= $1
-				AND			"ip_range_end"		>= $1
-				
-				ORDER BY	"ip_range_start" DESC
-					 
-				LIMIT		1`,
-				os.Getenv("DB_SCHEMA"))
-			row := pgDb.QueryRow(sqlString, ipString)
-			if err := row.Scan(&ipStruct.CountryCode); err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					return ipStruct
-				}
+	return reputation, true
+}
 
-				panic(err)
-			}
+func postgresGeofeed(ctx context.Context, ip net.IP, dbVersion int) (IpGeofeed, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	geofeed		:= IpGeofeed{}
 
-			if len(ipStruct.CountryCode) > 0 {
-				ipStruct.FoundCountry = true
-			}
+	sqlString := fmt.Sprintf(`
+		SELECT		COALESCE("country_code", ''),
+					COALESCE("state1", ''),
+					COALESCE("city", '')
+
+		FROM		"%s"."ip_geofeed"
+
+		WHERE		"ip_range_start"	<= $1
+		AND			"ip_range_end"		>= $1
+		AND			($2 = 0 OR "db_version" = $2)
+
+		ORDER BY	"ip_range_start" DESC
+
+		LIMIT		1`,
+		config().DbSchema)
+	queryCtx, span := traceQuery(ctx, "ip_geofeed", ipVersion)
+	row := pgDb.QueryRowContext(queryCtx, sqlString, ipString, dbVersion)
+	err := row.Scan(&geofeed.CountryCode, &geofeed.State1, &geofeed.City)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return geofeed, false
 		}
+
+		panic(err)
 	}
 
-	if hasASNDatabase() {
-		sqlString := fmt.Sprintf(`
-			SELECT		"as_number",
-						"as_organisation" 
-						
-			FROM		"%s"."ip_asn"
-			 
-			WHERE		"ip_range_start"	<= $1
-			AND			"ip_range_end"		>= $1
-			
-			ORDER BY	"ip_range_start" DESC
-			 
-			LIMIT		1`,
-			os.Getenv("DB_SCHEMA"))
-		row := pgDb.QueryRow(sqlString, ipString)
-		if err := row.Scan(&ipStruct.OrganisationNumber, &ipStruct.OrganisationName); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return ipStruct
-			}
+	return geofeed, true
+}
 
-			panic(err)
+func postgresConsensus(ctx context.Context, ip net.IP, dbVersion int) (IpConsensus, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	consensus	:= IpConsensus{}
+
+	sqlString := fmt.Sprintf(`
+		SELECT		COALESCE("country_code", ''),
+					COALESCE("confidence", 0),
+					COALESCE("dissent", '')
+
+		FROM		"%s"."ip_consensus"
+
+		WHERE		"ip_range_start"	<= $1
+		AND			"ip_range_end"		>= $1
+		AND			($2 = 0 OR "db_version" = $2)
+
+		ORDER BY	"ip_range_start" DESC
+
+		LIMIT		1`,
+		config().DbSchema)
+	queryCtx, span := traceQuery(ctx, "ip_consensus", ipVersion)
+	row := pgDb.QueryRowContext(queryCtx, sqlString, ipString, dbVersion)
+	err := row.Scan(&consensus.CountryCode, &consensus.Confidence, &consensus.Dissent)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return consensus, false
 		}
 
-		if len(ipStruct.OrganisationName) > 0 {
-			ipStruct.FoundASN = true
+		panic(err)
+	}
+
+	return consensus, true
+}
+
+func postgresSampleRanges(table string, source string, ipVersion int, dbVersion int) []SampleRange {
+	sqlString := fmt.Sprintf(`
+		SELECT		HOST("ip_range_start"),
+					HOST("ip_range_end"),
+					COALESCE("%s", '')::varchar
+
+		FROM		"%s"."%s"
+
+		WHERE		"ip_version" = $1
+		AND			"source" = $2
+		AND			($3 = 0 OR "db_version" = $3)
+
+		ORDER BY	"ip_range_start"`,
+		sampleValueColumn(table), config().DbSchema, table)
+	rows, err := pgDb.Query(sqlString, ipVersion, source, dbVersion)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return sampleRangesFromRows(rows)
+}
+
+func postgresDiffRanges(table string, source string, ipVersion int, dbVersion int) []SampleRange {
+	columns		:= diffColumns(table)
+	sqlString	:= `SELECT HOST("ip_range_start"), HOST("ip_range_end")`
+	for _, column := range columns {
+		sqlString += fmt.Sprintf(`, COALESCE("%s"::varchar, '')`, column)
+	}
+	sqlString += fmt.Sprintf(` FROM "%s"."%s" WHERE "ip_version" = $1 AND "db_version" = $2`, config().DbSchema, table)
+	params := []any{ ipVersion, dbVersion }
+	if len(source) > 0 {
+		sqlString += ` AND "source" = $3`
+		params = append(params, source)
+	}
+	sqlString += ` ORDER BY "ip_range_start"`
+
+	rows, err := pgDb.Query(sqlString, params...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return diffRangesFromRows(rows, len(columns))
+}
+
+// Names are only filled in when a localisation source is loaded
+func postgresExport(table string, source string, ipVersion int, dbVersion int, read func(*sql.Rows)) {
+	sqlString := `SELECT HOST("ip_range_start"), HOST("ip_range_end")`
+	for _, column := range exportColumns(table) {
+		if column == "names" {
+			sqlString += `, COALESCE("names", '')`
+		} else {
+			sqlString += fmt.Sprintf(`, "%s"`, column)
 		}
 	}
+	sqlString += fmt.Sprintf(` FROM "%s"."%s" WHERE "ip_version" = $1 AND "source" = $2 AND ($3 = 0 OR "db_version" = $3) ORDER BY "ip_range_start"`, config().DbSchema, table)
+
+	rows, err := pgDb.Query(sqlString, ipVersion, source, dbVersion)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
 
-	return ipStruct
+	read(rows)
 }
 
 func postgresQueryMaxVersion(table string, ipVersion int) int {
@@ -172,7 +367,7 @@ func postgresQueryMaxVersion(table string, ipVersion int) int {
 		ORDER BY	"db_version" DESC
 		
 		LIMIT 1`,
-		os.Getenv("DB_SCHEMA"), table)
+		config().DbSchema, table)
 	row := pgDb.QueryRow(sqlString, ipVersion)
 	if err := row.Scan(&version); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -185,19 +380,25 @@ func postgresQueryMaxVersion(table string, ipVersion int) int {
 	return version
 }
 
-func postgresDropOld(table string, ipVersion int, dbVersion int) {
-	fmt.Printf("Dropping old Postgres data: `%s`.`%s` ipv%d... ", os.Getenv("DB_SCHEMA"), table, ipVersion)
+func postgresDropOld(table string, source string, ipVersion int, dbVersion int) {
+	slog.Info("dropping old data", "db", "postgres", "schema", config().DbSchema, "table", table, "source", source, "ip_version", ipVersion, "db_version", dbVersion)
 	sqlString := fmt.Sprintf(`
 		DELETE FROM	"%s"."%s" 
 		
 		WHERE		"ip_version" = $1
 		AND			"db_version" < $2`,
-		os.Getenv("DB_SCHEMA"), table)
-	_, err := pgDb.Exec(sqlString, ipVersion, dbVersion)
+		config().DbSchema, table)
+	params := []any{ ipVersion, dbVersion }
+	if len(source) > 0 {
+		sqlString += `
+		AND			"source" = $3`
+		params = append(params, source)
+	}
+	_, err := pgDb.Exec(sqlString, params...)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("Complete")
+	slog.Info("dropped old data", "db", "postgres", "schema", config().DbSchema, "table", table, "ip_version", ipVersion)
 }
 
 func postgresSaveCountries(countries []IpCountry) {
@@ -209,13 +410,14 @@ func postgresSaveCountries(countries []IpCountry) {
 			"ip_range_end", 
 			"country_code", 
 			"ip_version", 
-			"db_version"
+			"db_version", 
+			"source"
 		) VALUES `,
-		os.Getenv("DB_SCHEMA"))
+		config().DbSchema)
 
 	for _, country := range countries {
-		sqlString += `($?, $?, $?, $?, $?), `
-		params = append(params, country.IpRangeStart, country.IpRangeEnd, country.CountryCode, country.IpVersion, country.DbVersion)
+		sqlString += `($?, $?, $?, $?, $?, $?), `
+		params = append(params, country.IpRangeStart, country.IpRangeEnd, country.CountryCode, country.IpVersion, country.DbVersion, country.Source)
 	}
 	sqlString = sqlString[0:len(sqlString) - 2]
 	sqlString = fixPostgresVars(sqlString)
@@ -242,13 +444,117 @@ func postgresSaveASNs(ASNs []IpASN) {
 			"as_number", 
 			"as_organisation", 
 			"ip_version", 
-			"db_version"
+			"db_version", 
+			"source"
 		) VALUES `,
-		os.Getenv("DB_SCHEMA"))
+		config().DbSchema)
 
 	for _, asn := range ASNs {
-		sqlString += `($?, $?, $?, $?, $?, $?), `
-		params = append(params, asn.IpRangeStart, asn.IpRangeEnd, asn.AsNumber, asn.AsOrganisation, asn.IpVersion, asn.DbVersion)
+		sqlString += `($?, $?, $?, $?, $?, $?, $?), `
+		params = append(params, asn.IpRangeStart, asn.IpRangeEnd, asn.AsNumber, asn.AsOrganisation, asn.IpVersion, asn.DbVersion, asn.Source)
+	}
+	sqlString = sqlString[0:len(sqlString) - 2]
+	sqlString = fixPostgresVars(sqlString)
+
+	stmt, err := pgDb.Prepare(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(params...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func postgresSaveReputations(reputations []IpReputation) {
+	var params []any
+
+	sqlString := fmt.Sprintf(`
+		INSERT INTO	"%s"."ip_reputation" (
+			"ip_range_start", 
+			"ip_range_end", 
+			"is_tor", 
+			"is_vpn", 
+			"is_hosting", 
+			"tags", 
+			"ip_version", 
+			"db_version"
+		) VALUES `,
+		config().DbSchema)
+
+	for _, reputation := range reputations {
+		sqlString += `($?, $?, $?, $?, $?, $?, $?, $?), `
+		params = append(params, reputation.IpRangeStart, reputation.IpRangeEnd, reputation.IsTor, reputation.IsVpn, reputation.IsHosting, reputation.Tags, reputation.IpVersion, reputation.DbVersion)
+	}
+	sqlString = sqlString[0:len(sqlString) - 2]
+	sqlString = fixPostgresVars(sqlString)
+
+	stmt, err := pgDb.Prepare(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(params...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func postgresSaveGeofeeds(geofeeds []IpGeofeed) {
+	var params []any
+
+	sqlString := fmt.Sprintf(`
+		INSERT INTO	"%s"."ip_geofeed" (
+			"ip_range_start", 
+			"ip_range_end", 
+			"country_code", 
+			"state1", 
+			"city", 
+			"ip_version", 
+			"db_version"
+		) VALUES `,
+		config().DbSchema)
+
+	for _, geofeed := range geofeeds {
+		sqlString += `($?, $?, $?, $?, $?, $?, $?), `
+		params = append(params, geofeed.IpRangeStart, geofeed.IpRangeEnd, geofeed.CountryCode, geofeed.State1, geofeed.City, geofeed.IpVersion, geofeed.DbVersion)
+	}
+	sqlString = sqlString[0:len(sqlString) - 2]
+	sqlString = fixPostgresVars(sqlString)
+
+	stmt, err := pgDb.Prepare(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(params...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func postgresSaveConsensuses(consensuses []IpConsensus) {
+	var params []any
+
+	sqlString := fmt.Sprintf(`
+		INSERT INTO	"%s"."ip_consensus" (
+			"ip_range_start", 
+			"ip_range_end", 
+			"country_code", 
+			"confidence", 
+			"dissent", 
+			"ip_version", 
+			"db_version"
+		) VALUES `,
+		config().DbSchema)
+
+	for _, consensus := range consensuses {
+		sqlString += `($?, $?, $?, $?, $?, $?, $?), `
+		params = append(params, consensus.IpRangeStart, consensus.IpRangeEnd, consensus.CountryCode, consensus.Confidence, consensus.Dissent, consensus.IpVersion, consensus.DbVersion)
 	}
 	sqlString = sqlString[0:len(sqlString) - 2]
 	sqlString = fixPostgresVars(sqlString)
@@ -280,13 +586,15 @@ func postgresSaveCities(cities []IpCity) {
 			"latitude", 
 			"longitude", 
 			"timezone", 
+			"names", 
 			"ip_version", 
-			"db_version"
+			"db_version", 
+			"source"
 		) VALUES `,
-		os.Getenv("DB_SCHEMA"))
+		config().DbSchema)
 	for _, city := range cities {
-		sqlString += `($?, $?, $?, $?, $?, $?, $?, $?, $?, $?, $?, $?), `
-		params = append(params, city.IpRangeStart, city.IpRangeEnd, city.CountryCode, city.State1, city.State2, city.City, city.Postcode, city.Latitude, city.Longitude, city.Timezone, city.IpVersion, city.DbVersion)
+		sqlString += `($?, $?, $?, $?, $?, $?, $?, $?, $?, $?, $?, $?, $?, $?), `
+		params = append(params, city.IpRangeStart, city.IpRangeEnd, city.CountryCode, city.State1, city.State2, city.City, city.Postcode, city.Latitude, city.Longitude, city.Timezone, city.Names.String(), city.IpVersion, city.DbVersion, city.Source)
 	}
 	sqlString = sqlString[0:len(sqlString) - 2]
 	sqlString = fixPostgresVars(sqlString)
@@ -303,6 +611,22 @@ func postgresSaveCities(cities []IpCity) {
 	}
 }
 
+// Rows loaded before sources were recorded came from the (then single) configured source
+func postgresMigrate() {
+	for _, table := range []string{ "ip_country", "ip_city", "ip_asn" } {
+		sources := datasetSources(datasetType(table))
+		if len(sources) == 0 {
+			continue
+		}
+
+		sqlString := fmt.Sprintf(`UPDATE "%s"."%s" SET "source" = $1 WHERE "source" = ''`, config().DbSchema, table)
+		_, err := pgDb.Exec(sqlString, sources[0])
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
 func fixPostgresVars(sqlString string) string {
 	varIncrement := 1;
 	for {
@@ -324,7 +648,7 @@ func postgresFile(sqlPath string) {
 		panic(err)
 	}
 	sqlString := string(sqlBytes)
-	sqlString = strings.Replace(sqlString, "${schema}", os.Getenv("DB_SCHEMA"), -1)
+	sqlString = strings.Replace(sqlString, "${schema}", config().DbSchema, -1)
 
 	sqlStatements := strings.Split(sqlString, ";")
 
@@ -349,4 +673,70 @@ func postgresFile(sqlPath string) {
 	if err != nil {
 		panic(err)
 	}
+}
+
+func postgresOverrides() []Override {
+	sqlString := fmt.Sprintf(`SELECT "id", "network"::varchar, "record" FROM "%s"."ip_override" ORDER BY "id"`, config().DbSchema)
+	rows, err := pgDb.Query(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return overridesFromRows(rows)
+}
+
+func postgresSaveOverride(override Override) Override {
+	if override.Id == 0 {
+		sqlString	:= fmt.Sprintf(`INSERT INTO "%s"."ip_override" ("network", "record") VALUES ($1, $2) RETURNING "id"`, config().DbSchema)
+		row			:= pgDb.QueryRow(sqlString, override.Network, overrideRecord(override))
+		if err := row.Scan(&override.Id); err != nil {
+			panic(err)
+		}
+
+		return override
+	}
+
+	sqlString := fmt.Sprintf(`UPDATE "%s"."ip_override" SET "network" = $1, "record" = $2 WHERE "id" = $3`, config().DbSchema)
+	_, err := pgDb.Exec(sqlString, override.Network, overrideRecord(override), override.Id)
+	if err != nil {
+		panic(err)
+	}
+
+	return override
+}
+
+func postgresDeleteOverride(id int64) {
+	sqlString := fmt.Sprintf(`DELETE FROM "%s"."ip_override" WHERE "id" = $1`, config().DbSchema)
+	_, err := pgDb.Exec(sqlString, id)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func postgresVersions() []DatasetVersion {
+	sqlString := fmt.Sprintf(`SELECT "dataset", "source", "ip_version", "db_version", "loaded_at" FROM "%s"."ip_load"`, config().DbSchema)
+	rows, err := pgDb.Query(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return versionsFromRows(rows)
+}
+
+func postgresSaveVersion(version DatasetVersion) {
+	sqlString := fmt.Sprintf(`INSERT INTO "%s"."ip_load" ("dataset", "source", "ip_version", "db_version", "loaded_at") VALUES ($1, $2, $3, $4, $5)`, config().DbSchema)
+	_, err := pgDb.Exec(sqlString, version.Table, version.Source, version.IpVersion, version.DbVersion, version.LoadedAt.Unix())
+	if err != nil {
+		panic(err)
+	}
+}
+
+func postgresDeleteVersions(table string, source string, ipVersion int, dbVersion int) {
+	sqlString := fmt.Sprintf(`DELETE FROM "%s"."ip_load" WHERE "dataset" = $1 AND "source" = $2 AND "ip_version" = $3 AND "db_version" < $4`, config().DbSchema)
+	_, err := pgDb.Exec(sqlString, table, source, ipVersion, dbVersion)
+	if err != nil {
+		panic(err)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Well known lists that may be used by name in `REPUTATION`
+var availableReputation = map[string]ReputationSource{
+	"tor-exit":		ReputationSource{ "tor-exit", "tor", "https://check.torproject.org/torbulkexitlist" },
+	"aws":			ReputationSource{ "aws", "hosting", "https://ip-ranges.amazonaws.com/ip-ranges.json" },
+	"gcp":			ReputationSource{ "gcp", "hosting", "https://www.gstatic.com/ipranges/cloud.json" },
+	"oracle":		ReputationSource{ "oracle", "hosting", "https://docs.oracle.com/en-us/iaas/tools/public_ip_ranges.json" },
+	"digitalocean":	ReputationSource{ "digitalocean", "hosting", "https://digitalocean.com/geo/google.csv" },
+	"linode":		ReputationSource{ "linode", "hosting", "https://geoip.linode.com/" },
+}
+
+var reputationCategories = []string{ "tor", "vpn", "hosting" }
+
+// Opens or closes a tag's range during the merge
+type reputationEvent struct {
+	position	*big.Int
+	key			string
+	delta		int
+}
+
+func hasReputationDatabase() bool {
+	return len(config().Reputation) > 0
+}
+
+func reputationSources() []ReputationSource {
+	return config().Reputation
+}
+
+// Comma separated list of available names and / or custom lists written as `category:name=file-or-url`
+func reputationSourcesParse(setting string) ([]ReputationSource, error) {
+	var sources []ReputationSource
+	for _, value := range strings.Split(setting, ",") {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+
+		category, custom, isCustom := strings.Cut(value, ":")
+		if !isCustom || strings.HasPrefix(custom, "//") {
+			source, ok := availableReputation[value]
+			if !ok {
+				return nil, errors.New(value + " is not a valid REPUTATION option")
+			}
+
+			sources = append(sources, source)
+			continue
+		}
+
+		name, location, ok := strings.Cut(custom, "=")
+		if !ok || len(name) == 0 || len(location) == 0 || !slices.Contains(reputationCategories, category) {
+			return nil, errors.New(value + " is not a valid REPUTATION option (expected tor|vpn|hosting:name=file-or-url)")
+		}
+
+		sources = append(sources, ReputationSource{ name, category, location })
+	}
+
+	return sources, nil
+}
+
+func (source ReputationSource) isUrl() bool {
+	return strings.HasPrefix(source.Location, "http://") || strings.HasPrefix(source.Location, "https://")
+}
+
+func (source ReputationSource) path(downloadPath string) string {
+	if source.isUrl() {
+		return downloadPath + "/reputation-" + source.Name + ".txt"
+	}
+
+	return source.Location
+}
+
+// All lists are merged into one table, so everything is reloaded if any one of them has changed
+func reputationDataToLoad(ctx context.Context, downloadPath string, missing []string) []DataToLoad {
+	if !hasReputationDatabase() || (len(missing) > 0 && !slices.Contains(missing, "REPUTATION")) {
+		return []DataToLoad{}
+	}
+
+	changed := slices.Contains(missing, "REPUTATION")
+	for _, source := range reputationSources() {
+		if source.isUrl() {
+			sourceChanged, err := downloadFile(ctx, source.path(downloadPath), source.Location)
+			if err != nil {
+				panic(err)
+			}
+			changed = changed || sourceChanged
+		} else {
+			changed = reputationFileChanged(downloadPath, source) || changed
+		}
+	}
+
+	if !changed {
+		slog.Info("reputation lists unchanged, skipping")
+		return []DataToLoad{}
+	}
+
+	download := Download{ "reputation", "txt", "REPUTATION", "", []string{}, nil }
+
+	return []DataToLoad{ DataToLoad{ download, downloadPath, 4 }, DataToLoad{ download, downloadPath, 6 } }
+}
+
+func reputationFileChanged(downloadPath string, source ReputationSource) bool {
+	return fileChanged(downloadPath + "/reputation-" + source.Name + ".etag", source.Location)
+}
+
+func loadReputation(ctx context.Context, dataToLoad DataToLoad) {
+	ctx, span := traceLoad(ctx, "ip_reputation", dataToLoad)
+	defer span.End()
+
+	version		:= dbQueryMaxVersion("ip_reputation", dataToLoad.Version) + 1
+	progress	:= NewLoadProgress("ip_reputation", "", dataToLoad.Version, version)
+	slog.Info("rebuilding", "table", "ip_reputation", "ip_version", dataToLoad.Version, "db_version", version)
+
+	var events []reputationEvent
+	for _, source := range reputationSources() {
+		events = append(events, reputationRead(source, source.path(dataToLoad.Path), dataToLoad.Version)...)
+	}
+
+	reputations := reputationMerge(events, dataToLoad.Version, version)
+	for start := 0; start < len(reputations); start += 100 {
+		batch := reputations[start:min(start + 100, len(reputations))]
+		loadBatch(ctx, "ip_reputation", batch, dbSaveReputations)
+		progress.Saved(len(batch))
+	}
+
+	progress.Complete()
+
+	dbDropOld("ip_reputation", "", dataToLoad.Version, version)
+}
+
+// Plain lists (one address, network or `start-end` range per line, as the first field) or JSON documents (e.g. cloud provider ranges) containing networks anywhere within them
+func reputationRead(source ReputationSource, filePath string, ipVersion int) []reputationEvent {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		panic(err)
+	}
+
+	var values []string
+	trimmed	:= bytes.TrimSpace(content)
+	isJson	:= len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+	if isJson {
+		var document any
+		err := json.Unmarshal(trimmed, &document)
+		if err != nil {
+			panic(err)
+		}
+		values = reputationJsonStrings(document, values)
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+				continue
+			}
+
+			values = append(values, strings.FieldsFunc(line, func(r rune) bool {
+				return r == ' ' || r == '\t' || r == ',' || r == ';'
+			})[0])
+		}
+	}
+
+	key			:= source.Category + ":" + source.Name
+	events		:= []reputationEvent{}
+	skipped		:= 0
+	for _, value := range values {
+		start, end, version, ok := reputationRange(value)
+		if !ok {
+			// JSON documents also contain names, regions etc.
+			if !isJson {
+				skipped++
+			}
+			continue
+		}
+
+		if version == ipVersion {
+			events = append(events, reputationEvent{ start, key, 1 }, reputationEvent{ new(big.Int).Add(end, big.NewInt(1)), key, -1 })
+		}
+	}
+
+	slog.Debug("read reputation list", "name", source.Name, "ip_version", ipVersion, "ranges", len(events) / 2, "skipped", skipped)
+
+	return events
+}
+
+func reputationJsonStrings(document any, values []string) []string {
+	switch typed := document.(type) {
+		case string:
+			values = append(values, typed)
+		case []any:
+			for _, item := range typed {
+				values = reputationJsonStrings(item, values)
+			}
+		case map[string]any:
+			for _, item := range typed {
+				values = reputationJsonStrings(item, values)
+			}
+	}
+
+	return values
+}
+
+// Inclusive numeric range of an address, network or `start-end` range
+func reputationRange(value string) (*big.Int, *big.Int, int, bool) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		prefix	= prefix.Masked()
+		start	:= new(big.Int).SetBytes(prefix.Addr().AsSlice())
+		end		:= new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen() - prefix.Bits()))
+		end.Add(end, start).Sub(end, big.NewInt(1))
+
+		return start, end, reputationVersion(prefix.Addr()), true
+	}
+
+	if address, err := netip.ParseAddr(value); err == nil {
+		address = address.Unmap()
+		number := new(big.Int).SetBytes(address.AsSlice())
+
+		return number, number, reputationVersion(address), true
+	}
+
+	if first, last, ok := strings.Cut(value, "-"); ok {
+		start, errStart	:= netip.ParseAddr(strings.TrimSpace(first))
+		end, errEnd		:= netip.ParseAddr(strings.TrimSpace(last))
+		if errStart == nil && errEnd == nil && start.Unmap().Is4() == end.Unmap().Is4() && !end.Unmap().Less(start.Unmap()) {
+			return new(big.Int).SetBytes(start.Unmap().AsSlice()), new(big.Int).SetBytes(end.Unmap().AsSlice()), reputationVersion(start.Unmap()), true
+		}
+	}
+
+	return nil, nil, 0, false
+}
+
+func reputationVersion(address netip.Addr) int {
+	if address.Is4() {
+		return 4
+	}
+
+	return 6
+}
+
+// Sweeps across the (possibly overlapping) ranges of every list, splitting them into non-overlapping ranges that carry every list covering them
+func reputationMerge(events []reputationEvent, ipVersion int, dbVersion int) []IpReputation {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].position.Cmp(events[j].position) < 0 })
+
+	reputations	:= []IpReputation{}
+	active		:= map[string]int{}
+	var previous *big.Int
+	var previousEnd *big.Int
+
+	for i := 0; i < len(events); {
+		position := events[i].position
+
+		if previous != nil && len(active) > 0 && previous.Cmp(position) < 0 {
+			end			:= new(big.Int).Sub(position, big.NewInt(1))
+			reputation	:= reputationFromKeys(active, previous, end, ipVersion, dbVersion)
+
+			// Adjacent ranges with identical lists are combined
+			last := len(reputations) - 1
+			if last >= 0 && reputationSameLists(reputations[last], reputation) && new(big.Int).Add(previousEnd, big.NewInt(1)).Cmp(previous) == 0 {
+				reputations[last].IpRangeEnd = reputation.IpRangeEnd
+			} else {
+				reputations = append(reputations, reputation)
+			}
+			previousEnd = end
+		}
+
+		for ; i < len(events) && events[i].position.Cmp(position) == 0; i++ {
+			active[events[i].key] += events[i].delta
+			if active[events[i].key] <= 0 {
+				delete(active, events[i].key)
+			}
+		}
+
+		previous = position
+	}
+
+	return reputations
+}
+
+func reputationSameLists(a IpReputation, b IpReputation) bool {
+	return a.Tags == b.Tags && a.IsTor == b.IsTor && a.IsVpn == b.IsVpn && a.IsHosting == b.IsHosting
+}
+
+func reputationFromKeys(active map[string]int, start *big.Int, end *big.Int, ipVersion int, dbVersion int) IpReputation {
+	reputation := IpReputation{ IpRangeStart: reputationIpString(start, ipVersion), IpRangeEnd: reputationIpString(end, ipVersion), IpVersion: ipVersion, DbVersion: dbVersion }
+
+	var tags []string
+	for key := range active {
+		category, name, _ := strings.Cut(key, ":")
+		switch category {
+			case "tor":		reputation.IsTor = true
+			case "vpn":		reputation.IsVpn = true
+			case "hosting":	reputation.IsHosting = true
+		}
+
+		if !slices.Contains(tags, name) {
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+	reputation.Tags = strings.Join(tags, ",")
+
+	return reputation
+}
+
+func reputationIpString(number *big.Int, ipVersion int) string {
+	size := net.IPv6len
+	if ipVersion == 4 {
+		size = net.IPv4len
+	}
+
+	ip := make(net.IP, size)
+	number.FillBytes(ip)
+
+	return ip.String()
+}
+
+func (ip *Ip) enrichReputation(ctx context.Context, address netip.Addr) {
+	if !hasReputationDatabase() {
+		return
+	}
+
+	reputation, ok := dbReputation(ctx, net.IP(address.AsSlice()))
+	if !ok {
+		return
+	}
+
+	ip.IsTor		= reputation.IsTor
+	ip.IsVpn		= reputation.IsVpn
+	ip.IsHosting	= reputation.IsHosting
+	if len(reputation.Tags) > 0 {
+		ip.Tags = strings.Split(reputation.Tags, ",")
+	}
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
 	"net/http"
+	"os"
+	"path"
+	"strings"
 	"strconv"
 	"time"
+
+	"golang.org/x/exp/slices"
 )
 
 func getHome(response http.ResponseWriter, request *http.Request) {
@@ -18,14 +26,108 @@ func getIp(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	// Looks the IP up in the data that was live at the time instead, as far as the retention settings kept it
+	ctx := request.Context()
+	if asOfString := request.URL.Query().Get("as_of"); len(asOfString) > 0 {
+		asOf, err := versionParseAsOf(asOfString)
+		if err != nil {
+			response.Header().Set("Content-Type", "application/json")
+			response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+			return
+		}
+		ctx = versionContext(ctx, asOf)
+	}
+
 	ipString := request.PathValue("ip")
-	jsonBytes, err := fetchIPJson(ipString)
+	jsonBytes, err := fetchIPJson(ctx, ipString, requestLanguages(request))
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+// What every loaded source says about an IP, and how far they agree
+func getCompare(response http.ResponseWriter, request *http.Request) {
+	if !validApiKey(request, false) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires a key" }`))
+		return
+	}
+
+	comparison, err := compareIp(request.Context(), request.PathValue("ip"))
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+		return
+	}
+
+	jsonBytes, err := json.Marshal(comparison)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+func getCountries(response http.ResponseWriter, request *http.Request) {
+	if !validApiKey(request, false) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires a key" }`))
+		return
+	}
+
+	jsonBytes, err := json.Marshal(countryList())
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+// Between two IPs (`/distance/$ipA/$ipB`) or an IP and a point (`/distance/$ip?lat=&lon=`)
+func getDistance(response http.ResponseWriter, request *http.Request) {
+	if !validApiKey(request, false) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires a key" }`))
+		return
+	}
+
+	from, err := distancePointFromIp(request.Context(), request.PathValue("ipA"))
 	if err != nil {
 		response.Header().Set("Content-Type", "application/json")
 		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
 		return
 	}
 
+	var to DistancePoint
+	if ipB := request.PathValue("ipB"); len(ipB) > 0 {
+		to, err = distancePointFromIp(request.Context(), ipB)
+	} else {
+		to, err = distancePointFromStrings(request.URL.Query().Get("lat"), request.URL.Query().Get("lon"))
+	}
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+		return
+	}
+
+	jsonBytes, err := json.Marshal(distanceBetween(from, to))
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
+
 	response.Header().Set("Content-Type", "application/json")
 	response.Write(jsonBytes)
 }
@@ -37,15 +139,27 @@ func getRandomIp(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	var ipString string
-	ipVersion := request.PathValue("ipVersion")
-	if ipVersion == "4" {
-		ipString = randomIpv4()
-	} else {
-		ipString = randomIpv6();
+	filter, err := sampleFilterFromRequest(request)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+		return
+	}
+
+	ipVersion := 6
+	if request.PathValue("ipVersion") == "4" {
+		ipVersion = 4
 	}
 
-	jsonBytes, err := fetchIPJson(ipString)
+	rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	ipString, err := sampleOrRandomIp(request.Context(), rng, ipVersion, filter)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+		return
+	}
+
+	jsonBytes, err := fetchIPJson(request.Context(), ipString, requestLanguages(request))
 	if err != nil {
 		response.Header().Set("Content-Type", "application/json")
 		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
@@ -63,52 +177,353 @@ func getBenchmark(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	var ipString string
+	options := BenchmarkOptions{ IpVersion: 6, Concurrency: 1 }
+	if request.PathValue("ipVersion") == "4" {
+		options.IpVersion = 4
+	}
 
-	ipVersion	:= request.PathValue("ipVersion")
-	times		:= request.PathValue("times")
+	var err error
+	query := request.URL.Query()
 
-	timesInt, err := strconv.Atoi(times)
+	options.Filter, err = sampleFilterFromRequest(request)
 	if err != nil {
 		response.Header().Set("Content-Type", "application/json")
-		response.Write([]byte(`{ "error": "URL must contain a numeric number of times to run" }`))
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
 		return
 	}
 
-	// Don't want this to be part of the benchmark
-	var testIps []string
-	for i := 0; i < timesInt; i++ {
-		if ipVersion == "4" {
-			ipString = randomIpv4()
-		} else {
-			ipString = randomIpv6();
+	if seed := query.Get("seed"); len(seed) > 0 {
+		options.Seed, err = strconv.ParseUint(seed, 10, 64)
+		if err != nil {
+			response.Header().Set("Content-Type", "application/json")
+			response.Write([]byte(`{ "error": "seed must be a positive integer" }`))
+			return
 		}
+	}
 
-		testIps = append(testIps, ipString)
+	options.Corpus = query.Get("corpus")
+
+	if times := request.PathValue("times"); len(times) > 0 {
+		options.Times, err = strconv.Atoi(times)
+		if err != nil {
+			response.Header().Set("Content-Type", "application/json")
+			response.Write([]byte(`{ "error": "URL must contain a numeric number of times to run" }`))
+			return
+		}
+	}
+
+	if duration := query.Get("duration"); len(duration) > 0 {
+		options.Duration, err = time.ParseDuration(duration)
+		if err != nil {
+			response.Header().Set("Content-Type", "application/json")
+			response.Write([]byte(`{ "error": "duration must be a valid duration, e.g. 10s" }`))
+			return
+		}
+	}
+
+	if concurrency := query.Get("concurrency"); len(concurrency) > 0 {
+		options.Concurrency, err = strconv.Atoi(concurrency)
+		if err != nil {
+			response.Header().Set("Content-Type", "application/json")
+			response.Write([]byte(`{ "error": "concurrency must be numeric" }`))
+			return
+		}
 	}
 
-	start := time.Now()
-	for _, ipString := range testIps {
-		_, err := fetchIP(ipString)
+	if warmup := query.Get("warmup"); len(warmup) > 0 {
+		options.Warmup, err = strconv.Atoi(warmup)
 		if err != nil {
 			response.Header().Set("Content-Type", "application/json")
-			response.Write([]byte(`{ "error": "Error encountered during run (` + ipString + `)" }`))
+			response.Write([]byte(`{ "error": "warmup must be numeric" }`))
 			return
 		}
 	}
 
-	ms := int(time.Now().Sub(start).Milliseconds())
-	us := int(time.Now().Sub(start).Microseconds())
+	report, err := benchmarkRun(request.Context(), options)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+		return
+	}
 
-	msPerOp := ms / timesInt;
-	usPerOp := us / timesInt;
+	jsonBytes, err := json.Marshal(report)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
 
 	response.Header().Set("Content-Type", "application/json")
-	response.Write([]byte(`{
-		"times": `		+ strconv.Itoa(timesInt) + `, 
-		"ms": `			+ strconv.Itoa(ms) + `, 
-		"μs": `			+ strconv.Itoa(us) + `, 
-		"ms_per_op": `	+ strconv.Itoa(msPerOp) + `, 
-		"μs_per_op": `	+ strconv.Itoa(usPerOp) + ` 
-	}`))
+	response.Write(jsonBytes)
+}
+
+func getOverrides(response http.ResponseWriter, request *http.Request) {
+	if !validAdminKey(request) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires an admin key" }`))
+		return
+	}
+
+	jsonBytes, err := json.Marshal(overrideList())
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+// The retained versions of each table (and source), which `as_of` lookups can be answered from
+func getVersions(response http.ResponseWriter, request *http.Request) {
+	if !validAdminKey(request) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires an admin key" }`))
+		return
+	}
+
+	jsonBytes, err := json.Marshal(versionList())
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+func getDiffs(response http.ResponseWriter, request *http.Request) {
+	if !validAdminKey(request) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires an admin key" }`))
+		return
+	}
+
+	// Only known names are echoed back, as they can't break the JSON
+	dataset := request.PathValue("dataset")
+	if _, ok := availableSources()[dataset]; !ok && !slices.Contains(sourceReservedNames, dataset) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "unknown dataset" }`))
+		return
+	}
+
+	reports := diffList(dataset)
+	if len(reports) == 0 {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "no diff report for ` + dataset + ` since the last restart" }`))
+		return
+	}
+
+	jsonBytes, err := json.Marshal(reports)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+func getWebhooks(response http.ResponseWriter, request *http.Request) {
+	if !validAdminKey(request) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires an admin key" }`))
+		return
+	}
+
+	jsonBytes, err := json.Marshal(webhookDeliveries())
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+// CSV and MMDB files hold one IP version (`?ip_version=6`, defaults to 4), SQLite files hold both unless one is given
+func getExport(response http.ResponseWriter, request *http.Request) {
+	if !validAdminKey(request) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires an admin key" }`))
+		return
+	}
+
+	format		:= request.PathValue("format")
+	ipVersions	:= []int{ 4 }
+	switch request.URL.Query().Get("ip_version") {
+		case "4":
+		case "6":	ipVersions = []int{ 6 }
+		case "":
+			if format == "sqlite" {
+				ipVersions = []int{ 4, 6 }
+			}
+		default:
+			response.Header().Set("Content-Type", "application/json")
+			response.Write([]byte(`{ "error": "ip_version must be 4 or 6" }`))
+			return
+	}
+
+	directory, err := os.MkdirTemp("", "ip-location-export")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	filePaths, err := exportSource(format, request.PathValue("source"), ipVersions, directory)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+		return
+	}
+
+	response.Header().Set("Content-Disposition", `attachment; filename="` + path.Base(filePaths[0]) + `"`)
+	http.ServeFile(response, request, filePaths[0])
+}
+
+// Nothing is changed when the configuration has problems, they're all listed
+func postReload(response http.ResponseWriter, request *http.Request) {
+	if !validAdminKey(request) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires an admin key" }`))
+		return
+	}
+
+	var jsonBytes []byte
+	reload, problems := configReload()
+	if len(problems) > 0 {
+		jsonBytes, _ = json.Marshal(map[string]any{ "error": "invalid configuration, nothing was reloaded", "problems": problems })
+	} else {
+		jsonBytes, _ = json.Marshal(reload)
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+func getOverride(response http.ResponseWriter, request *http.Request) {
+	if !validAdminKey(request) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires an admin key" }`))
+		return
+	}
+
+	id, _ := strconv.ParseInt(request.PathValue("id"), 10, 64)
+	override, ok := overrideFind(id)
+	if !ok {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "override not found" }`))
+		return
+	}
+
+	jsonBytes, err := json.Marshal(override)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+func postOverride(response http.ResponseWriter, request *http.Request) {
+	saveOverride(response, request, 0)
+}
+
+func putOverride(response http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseInt(request.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "override not found" }`))
+		return
+	}
+
+	saveOverride(response, request, id)
+}
+
+// A PUT replaces the whole override, so any field left out of the body is no longer overridden
+func saveOverride(response http.ResponseWriter, request *http.Request, id int64) {
+	if !validAdminKey(request) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires an admin key" }`))
+		return
+	}
+
+	var override Override
+	decoder := json.NewDecoder(http.MaxBytesReader(response, request.Body, 64 * 1024))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&override); err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "the body must be a JSON override using only the documented fields" }`))
+		return
+	}
+	override.Id = id
+
+	override, err := overrideSave(override)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+		return
+	}
+
+	jsonBytes, err := json.Marshal(override)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "system error" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(jsonBytes)
+}
+
+func deleteOverride(response http.ResponseWriter, request *http.Request) {
+	if !validAdminKey(request) {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "Sorry, this API requires an admin key" }`))
+		return
+	}
+
+	id, _ := strconv.ParseInt(request.PathValue("id"), 10, 64)
+	err := overrideDelete(id)
+	if err != nil {
+		response.Header().Set("Content-Type", "application/json")
+		response.Write([]byte(`{ "error": "` + err.Error() + `" }`))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Write([]byte(`{ "message": "override deleted" }`))
+}
+
+// Shared `country`, `asn` and `mode` query parameters of the random / benchmark routes
+func sampleFilterFromRequest(request *http.Request) (SampleFilter, error) {
+	var filter SampleFilter
+	query := request.URL.Query()
+
+	filter.CountryCode = strings.ToUpper(query.Get("country"))
+	if len(filter.CountryCode) > 0 && len(filter.CountryCode) != 2 {
+		return filter, errors.New("country must be a 2 letter ISO code")
+	}
+
+	if asn := strings.TrimPrefix(strings.ToUpper(query.Get("asn")), "AS"); len(asn) > 0 {
+		asNumber, err := strconv.ParseInt(asn, 10, 64)
+		if err != nil || asNumber < 1 {
+			return filter, errors.New("asn must be a positive AS number")
+		}
+		filter.AsNumber = asNumber
+	}
+
+	switch query.Get("mode") {
+		case "", "uniform":	filter.Weighted = false
+		case "weighted":	filter.Weighted = true
+		default:			return filter, errors.New("mode must be uniform or weighted")
+	}
+
+	return filter, nil
 }
\ No newline at end of file
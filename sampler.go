@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/big"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Indexes of the loaded ranges, built lazily per table / IP version and discarded whenever new data is loaded
+var samplerIndexes	= map[string]*samplerEntry{}
+var samplerMutex	sync.Mutex
+
+// Each index is built under its own lock, so reading a large table doesn't hold up sampling from the others
+type samplerEntry struct {
+	index	*SampleIndex
+	mutex	sync.Mutex
+}
+
+type SampleIndex struct {
+	Ranges		[]SampleRange
+	cumulative	[]*big.Int
+	total		*big.Int
+	filtered	map[string]*SampleIndex
+	mutex		sync.Mutex
+}
+
+func NewSampleIndex(ranges []SampleRange) *SampleIndex {
+	index := &SampleIndex{ Ranges: ranges, total: big.NewInt(0), filtered: map[string]*SampleIndex{} }
+
+	for _, sampleRange := range ranges {
+		index.total = new(big.Int).Add(index.total, sampleRange.Size)
+		index.cumulative = append(index.cumulative, index.total)
+	}
+
+	return index
+}
+
+// Most filtered subsets memoised per index, as the values come from requests
+const samplerFilteredLimit = 1000
+
+// Subset of the index whose ranges carry `value` (a country code or AS number), memoised as they're reused heavily by benchmarks.
+// Values with no ranges aren't kept, and nothing more is once the limit is reached.
+func (index *SampleIndex) filter(value string) *SampleIndex {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	subset, ok := index.filtered[value]
+	if !ok {
+		var ranges []SampleRange
+		for _, sampleRange := range index.Ranges {
+			if sampleRange.Value == value {
+				ranges = append(ranges, sampleRange)
+			}
+		}
+
+		subset = NewSampleIndex(ranges)
+		if len(ranges) > 0 && len(index.filtered) < samplerFilteredLimit {
+			index.filtered[value] = subset
+		}
+	}
+
+	return subset
+}
+
+// Uniform picks every range with equal probability, weighted picks proportionally to the range size
+func (index *SampleIndex) pick(rng *rand.Rand, weighted bool) SampleRange {
+	if !weighted {
+		return index.Ranges[rng.IntN(len(index.Ranges))]
+	}
+
+	target := randomBigInt(rng, index.total)
+	position := sort.Search(len(index.cumulative), func(i int) bool {
+		return index.cumulative[i].Cmp(target) > 0
+	})
+
+	return index.Ranges[position]
+}
+
+func (filter SampleFilter) empty() bool {
+	return len(filter.CountryCode) == 0 && filter.AsNumber == 0
+}
+
+func samplerReset() {
+	samplerMutex.Lock()
+	defer samplerMutex.Unlock()
+
+	samplerIndexes = map[string]*samplerEntry{}
+}
+
+func samplerIndex(table string, ipVersion int) *SampleIndex {
+	key := table + "ipv" + strconv.Itoa(ipVersion)
+
+	samplerMutex.Lock()
+	entry, ok := samplerIndexes[key]
+	if !ok {
+		entry = &samplerEntry{}
+		samplerIndexes[key] = entry
+	}
+	samplerMutex.Unlock()
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if entry.index == nil {
+		// Sampling from the first source is enough, the others cover the same address space
+		entry.index = NewSampleIndex(dbSampleRanges(table, datasetSources(datasetType(table))[0], ipVersion))
+	}
+
+	return entry.index
+}
+
+// Chooses the narrowest loaded table able to satisfy the filter
+func samplerTable(filter SampleFilter) string {
+	if filter.AsNumber > 0 {
+		if hasASNDatabase() {
+			return "ip_asn"
+		}
+
+		return ""
+	}
+
+	if hasCountryDatabase() {
+		return "ip_country"
+	}
+
+	if hasCityDatabase() {
+		return "ip_city"
+	}
+
+	if len(filter.CountryCode) == 0 && hasASNDatabase() {
+		return "ip_asn"
+	}
+
+	return ""
+}
+
+func sampleIp(ctx context.Context, rng *rand.Rand, ipVersion int, filter SampleFilter) (string, error) {
+	table := samplerTable(filter)
+	if len(table) == 0 {
+		return "", errors.New("no loaded dataset can satisfy the requested filter")
+	}
+
+	index := samplerIndex(table, ipVersion)
+	switch {
+		case filter.AsNumber > 0:			index = index.filter(strconv.FormatInt(filter.AsNumber, 10))
+		case len(filter.CountryCode) > 0:	index = index.filter(filter.CountryCode)
+	}
+
+	if len(index.Ranges) == 0 {
+		return "", errors.New("no loaded ranges match the requested filter")
+	}
+
+	// Country and ASN data are separate, so an ASN range is only kept if the address also resolves to the country
+	attempts := 1
+	if filter.AsNumber > 0 && len(filter.CountryCode) > 0 {
+		attempts = 100
+	}
+
+	for i := 0; i < attempts; i++ {
+		ipString := randomIpInRange(rng, index.pick(rng, filter.Weighted), ipVersion)
+		if attempts == 1 {
+			return ipString, nil
+		}
+
+		ipResult, err := fetchIP(ctx, ipString)
+		if err == nil && ipResult.CountryCode == filter.CountryCode {
+			return ipString, nil
+		}
+	}
+
+	return "", errors.New("no loaded ranges match the requested filter")
+}
+
+// Falls back to the generated addresses when nothing has been loaded yet
+func sampleOrRandomIp(ctx context.Context, rng *rand.Rand, ipVersion int, filter SampleFilter) (string, error) {
+	ipString, err := sampleIp(ctx, rng, ipVersion, filter)
+	if err != nil && filter.empty() {
+		if ipVersion == 4 {
+			return randomIpv4(), nil
+		}
+
+		return randomIpv6(), nil
+	}
+
+	return ipString, err
+}
+
+func randomIpInRange(rng *rand.Rand, sampleRange SampleRange, ipVersion int) string {
+	number := new(big.Int).Add(sampleRange.Start, randomBigInt(rng, sampleRange.Size))
+
+	size := 16
+	if ipVersion == 4 {
+		size = 4
+	}
+
+	ip := make(net.IP, size)
+	number.FillBytes(ip)
+
+	return ip.String()
+}
+
+// Uniform random number in [0, max) by rejection sampling on the bit length
+func randomBigInt(rng *rand.Rand, max *big.Int) *big.Int {
+	if max.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	bits	:= max.BitLen()
+	bytes	:= make([]byte, (bits + 7) / 8)
+	excess	:= uint(len(bytes) * 8 - bits)
+
+	for {
+		for i := range bytes {
+			bytes[i] = byte(rng.Uint32())
+		}
+		bytes[0] &= byte(0xff >> excess)
+
+		number := new(big.Int).SetBytes(bytes)
+		if number.Cmp(max) < 0 {
+			return number
+		}
+	}
+}
+
+func sampleValueColumn(table string) string {
+	if table == "ip_asn" {
+		return "as_number"
+	}
+
+	return "country_code"
+}
+
+func sampleRangesFromRows(rows *sql.Rows) []SampleRange {
+	ranges := []SampleRange{}
+	for rows.Next() {
+		var ipRangeStart, ipRangeEnd, value string
+		err := rows.Scan(&ipRangeStart, &ipRangeEnd, &value)
+		if err != nil {
+			panic(err)
+		}
+
+		if len(value) > 0 && value != "0" {
+			ranges = append(ranges, NewSampleRangeFromStrings(ipRangeStart, ipRangeEnd, value))
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return ranges
+}
+
+func ipToBigInt(ipString string) *big.Int {
+	ip := net.ParseIP(ipString)
+	if ip == nil {
+		return big.NewInt(0)
+	}
+
+	if ipv4 := ip.To4(); ipv4 != nil && !strings.Contains(ipString, ":") {
+		return new(big.Int).SetBytes(ipv4)
+	}
+
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+func NewSampleRangeFromStrings(ipRangeStart string, ipRangeEnd string, value string) SampleRange {
+	start	:= ipToBigInt(ipRangeStart)
+	end		:= ipToBigInt(ipRangeEnd)
+	size	:= new(big.Int).Add(new(big.Int).Sub(end, start), big.NewInt(1))
+
+	return SampleRange{ start, size, value }
+}
+
+func NewSampleRangeFromNetwork(network *net.IPNet, value string) SampleRange {
+	ones, bits := network.Mask.Size()
+
+	ip := network.IP.To16()
+	if bits == 32 {
+		ip = network.IP.To4()
+	}
+
+	start	:= new(big.Int).SetBytes(ip)
+	size	:= new(big.Int).Lsh(big.NewInt(1), uint(bits - ones))
+
+	return SampleRange{ start, size, value }
+}
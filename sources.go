@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/exp/slices"
+)
+
+// Used for the data of the reputation lists, geofeeds and consensus, so they can't be source names
+var sourceReservedNames = []string{ "reputation", "geofeed", "consensus" }
+
+var sourceOptions = []string{ "type", "url", "compression", "delimiter", "header", "columns", "licenses" }
+
+// Fields a source's columns are mapped onto, in the order of the ip-location-db files
+func sourceFields(sourceType string) []string {
+	switch sourceType {
+		case "CITY":	return []string{ "ip_range_start", "ip_range_end", "country_code", "state1", "state2", "city", "postcode", "latitude", "longitude", "timezone" }
+		case "ASN":		return []string{ "ip_range_start", "ip_range_end", "as_number", "as_organisation" }
+	}
+
+	return []string{ "ip_range_start", "ip_range_end", "country_code" }
+}
+
+// City sources may also map localised place names, e.g. `city.de` or `state1.pt-BR`
+var sourceNamePattern = regexp.MustCompile(`^(city|state1|state2)\.([A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*)$`)
+
+func sourceRequiredFields(sourceType string) []string {
+	switch sourceType {
+		case "ASN":		return []string{ "ip_range_start", "ip_range_end", "as_number" }
+	}
+
+	return []string{ "ip_range_start", "ip_range_end", "country_code" }
+}
+
+// Parses the `SOURCES` definitions (JSON, or a map in the configuration file) keyed by source name, reporting every invalid source
+func sourcesParse(setting string) (map[string]Download, []string) {
+	definitions := map[string]map[string]any{}
+	err := json.Unmarshal([]byte(setting), &definitions)
+	if err != nil {
+		return nil, []string{ "SOURCES: must map each source name to its options" }
+	}
+
+	var names []string
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	sources := map[string]Download{}
+	for _, name := range names {
+		download, err := sourceParse(name, definitions[name])
+		if err != nil {
+			problems = append(problems, "SOURCES: " + name + ": " + err.Error())
+			continue
+		}
+
+		sources[name] = download
+	}
+
+	return sources, problems
+}
+
+func sourceParse(name string, definition map[string]any) (Download, error) {
+	if !regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`).MatchString(name) {
+		return Download{}, errors.New("names may only contain lowercase letters, digits, dots, dashes and underscores")
+	}
+
+	if _, ok := available[name]; ok || slices.Contains(sourceReservedNames, name) {
+		return Download{}, errors.New("is already used by a built-in source")
+	}
+
+	for option := range definition {
+		if !slices.Contains(sourceOptions, option) {
+			return Download{}, errors.New(option + " is not one of " + strings.Join(sourceOptions, ", "))
+		}
+	}
+
+	sourceType, err := sourceString(definition, "type")
+	if err != nil {
+		return Download{}, err
+	}
+
+	sourceType = strings.ToUpper(sourceType)
+	if !slices.Contains([]string{ "COUNTRY", "CITY", "ASN" }, sourceType) {
+		return Download{}, errors.New("type must be country, city or asn")
+	}
+
+	url, err := sourceString(definition, "url")
+	if err != nil {
+		return Download{}, err
+	}
+
+	layout := &SourceLayout{ Url: url, Delimiter: ',', Columns: map[string]int{}, HeaderColumns: map[string]string{} }
+	if !strings.HasPrefix(layout.Url, "http://") && !strings.HasPrefix(layout.Url, "https://") {
+		return Download{}, errors.New("url must be an http(s) URL, with {ip_version} in place of 4 or 6 when there's a file per IP version")
+	}
+
+	compression, err := sourceString(definition, "compression")
+	if err != nil {
+		return Download{}, err
+	}
+
+	format := "csv"
+	switch compression {
+		case "", "none":
+		case "gz", "gzip":	format = "gz"
+		default:			return Download{}, errors.New("compression must be none or gz")
+	}
+
+	delimiter, err := sourceString(definition, "delimiter")
+	if err != nil {
+		return Download{}, err
+	}
+
+	if len(delimiter) > 0 {
+		layout.Delimiter, _ = utf8.DecodeRuneInString(delimiter)
+		if utf8.RuneCountInString(delimiter) != 1 || layout.Delimiter == '"' || layout.Delimiter == '\r' || layout.Delimiter == '\n' {
+			return Download{}, errors.New("delimiter must be a single character, e.g. \",\" or \"\\t\"")
+		}
+	}
+
+	if header, ok := definition["header"]; ok {
+		layout.Header, ok = header.(bool)
+		if !ok {
+			return Download{}, errors.New("header must be true or false")
+		}
+	}
+
+	if columns, ok := definition["columns"]; ok {
+		err := sourceColumns(layout, sourceType, columns)
+		if err != nil {
+			return Download{}, err
+		}
+	}
+
+	licenses := []string{}
+	if list, ok := definition["licenses"]; ok {
+		items, ok := list.([]any)
+		if !ok {
+			return Download{}, errors.New("licenses must be a list of file names")
+		}
+
+		for _, item := range items {
+			license, ok := item.(string)
+			if !ok || len(strings.TrimSpace(license)) == 0 {
+				return Download{}, errors.New("licenses must be a list of file names")
+			}
+			licenses = append(licenses, strings.TrimSpace(license))
+		}
+	}
+
+	return Download{ name, format, sourceType, "", licenses, layout }, nil
+}
+
+// Each field maps to a column number (counting from 0) or, for files with a header row, the column's name
+func sourceColumns(layout *SourceLayout, sourceType string, columns any) error {
+	mapping, ok := columns.(map[string]any)
+	if !ok {
+		return errors.New("columns must map fields to column numbers or names")
+	}
+
+	fields := sourceFields(sourceType)
+	for field, column := range mapping {
+		if !slices.Contains(fields, field) && (sourceType != "CITY" || !sourceNamePattern.MatchString(field)) {
+			expected := strings.Join(fields, ", ")
+			if sourceType == "CITY" {
+				expected += " or a localised name (city.<language>, state1.<language> or state2.<language>)"
+			}
+
+			return errors.New(field + " is not a " + strings.ToLower(sourceType) + " field, expected one of " + expected)
+		}
+
+		switch typed := column.(type) {
+			case float64:
+				if typed < 0 || typed != float64(int(typed)) {
+					return errors.New("the " + field + " column number must be a whole number of at least 0")
+				}
+				layout.Columns[field] = int(typed)
+			case string:
+				if !layout.Header {
+					return errors.New("the " + field + " column can only be named when header is true")
+				}
+				layout.HeaderColumns[field] = typed
+			default:
+				return errors.New("the " + field + " column must be a number or name")
+		}
+	}
+
+	for _, field := range sourceRequiredFields(sourceType) {
+		_, numbered	:= layout.Columns[field]
+		_, named	:= layout.HeaderColumns[field]
+		if !numbered && !named {
+			return errors.New("columns must include " + field)
+		}
+	}
+
+	return nil
+}
+
+func sourceString(definition map[string]any, option string) (string, error) {
+	value, ok := definition[option]
+	if !ok {
+		return "", nil
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return "", errors.New(option + " must be text")
+	}
+
+	return strings.TrimSpace(text), nil
+}
+
+// Either a file per IP version, or a single file that's loaded once for each
+func sourceDataToLoad(ctx context.Context, download Download, downloadPath string, missing []string) []DataToLoad {
+	compression := ""
+	if download.Format == "gz" {
+		compression = ".gz"
+	}
+
+	var dataToLoad []DataToLoad
+	if strings.Contains(download.Layout.Url, "{ip_version}") {
+		for _, ipVersion := range []int{ 4, 6 } {
+			url			:= strings.ReplaceAll(download.Layout.Url, "{ip_version}", strconv.Itoa(ipVersion))
+			filePath	:= fmt.Sprintf("%s/%s-ipv%d.csv%s", downloadPath, download.Folder, ipVersion, compression)
+
+			loadPath := downloadDataFile(ctx, download, url, filePath, compression, missing)
+			if loadPath != "" {
+				dataToLoad = append(dataToLoad, DataToLoad{ download, loadPath, ipVersion })
+			}
+		}
+
+		return dataToLoad
+	}
+
+	filePath := fmt.Sprintf("%s/%s.csv%s", downloadPath, download.Folder, compression)
+	loadPath := downloadDataFile(ctx, download, download.Layout.Url, filePath, compression, missing)
+	if loadPath != "" {
+		dataToLoad = append(dataToLoad, DataToLoad{ download, loadPath, 4 }, DataToLoad{ download, loadPath, 6 })
+	}
+
+	return dataToLoad
+}
+
+// Reads a source's rows as its type's fields in the ip-location-db order, so every source loads the same way
+type sourceReader struct {
+	reader		*csv.Reader
+	columns		[]int
+	ipVersion	int
+	names		[]string
+}
+
+// Errors when a header row can't be read or lacks a named column
+func newSourceReader(file io.Reader, dataToLoad DataToLoad) (*sourceReader, error) {
+	download	:= dataToLoad.Download
+	fields		:= sourceFields(download.Type)
+	reader		:= &sourceReader{ csv.NewReader(file), make([]int, len(fields)), 0, nil }
+	for i := range fields {
+		reader.columns[i] = i
+	}
+
+	layout := download.Layout
+	if layout == nil {
+		return reader, nil
+	}
+
+	reader.reader.Comma				= layout.Delimiter
+	reader.reader.FieldsPerRecord	= -1
+	if !strings.Contains(layout.Url, "{ip_version}") {
+		// Rows of the other IP version are skipped
+		reader.ipVersion = dataToLoad.Version
+	}
+
+	var header []string
+	if layout.Header {
+		record, err := reader.reader.Read()
+		if err != nil {
+			return nil, errors.New("the header could not be read: " + err.Error())
+		}
+
+		for i, name := range record {
+			if i == 0 {
+				name = strings.TrimPrefix(name, "\ufeff")
+			}
+			header = append(header, strings.TrimSpace(name))
+		}
+	}
+
+	if len(layout.Columns) == 0 && len(layout.HeaderColumns) == 0 {
+		return reader, nil
+	}
+
+	for i, field := range fields {
+		reader.columns[i] = -1
+		if column, ok := layout.Columns[field]; ok {
+			reader.columns[i] = column
+		}
+
+		if name, ok := layout.HeaderColumns[field]; ok {
+			reader.columns[i] = slices.Index(header, name)
+			if reader.columns[i] == -1 {
+				return nil, errors.New("the header has no " + name + " column")
+			}
+		}
+	}
+
+	// The localised names are read after the type's fields
+	for field := range layout.Columns {
+		if sourceNamePattern.MatchString(field) {
+			reader.names = append(reader.names, field)
+		}
+	}
+	for field := range layout.HeaderColumns {
+		if sourceNamePattern.MatchString(field) {
+			reader.names = append(reader.names, field)
+		}
+	}
+	sort.Strings(reader.names)
+
+	for _, field := range reader.names {
+		column, ok := layout.Columns[field]
+		if name, named := layout.HeaderColumns[field]; named {
+			column, ok = slices.Index(header, name), true
+			if column == -1 {
+				return nil, errors.New("the header has no " + name + " column")
+			}
+		}
+
+		if ok {
+			reader.columns = append(reader.columns, column)
+		}
+	}
+
+	return reader, nil
+}
+
+// Unmapped fields (and columns missing from a row) are empty
+func (reader *sourceReader) Read() ([]string, error) {
+	for {
+		record, err := reader.reader.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		fields := make([]string, len(reader.columns))
+		for i, column := range reader.columns {
+			if column >= 0 && column < len(record) {
+				fields[i] = strings.TrimSpace(record[column])
+			}
+		}
+
+		if reader.ipVersion == 0 || getIpVersion(fields[0]) == reader.ipVersion {
+			return fields, nil
+		}
+	}
+}
+
+// The non-empty localised names of a row read as city fields
+func (reader *sourceReader) localisedNames(record []string) LocalisedNames {
+	names	:= LocalisedNames{}
+	offset	:= len(record) - len(reader.names)
+	for i, field := range reader.names {
+		value := record[offset + i]
+		if len(value) == 0 {
+			continue
+		}
+
+		place, language, _ := strings.Cut(field, ".")
+		switch place {
+			case "city":	names.City		= localisedNameAdd(names.City, language, value)
+			case "state1":	names.State1	= localisedNameAdd(names.State1, language, value)
+			case "state2":	names.State2	= localisedNameAdd(names.State2, language, value)
+		}
+	}
+
+	return names
+}
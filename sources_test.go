@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewSourceReaderProblems(t *testing.T) {
+	countryColumns := `"ip_range_start": "start", "ip_range_end": "end", "country_code": "country"`
+
+	tests := []struct {
+		sourceType	string
+		contents	string
+		columns		string
+		expected	string
+	}{
+		{ "country", "start,end,country\n1.0.0.0,1.0.0.255,US\n", countryColumns, "" },
+		{ "country", "\ufeffstart, end ,country\n", countryColumns, "" },
+		{ "country", "", countryColumns, "the header could not be read: EOF" },
+		{ "country", "start,end,cc\n", countryColumns, "the header has no country column" },
+		{ "city", "start,end,country,stadt\n", countryColumns + `, "city.de": "stadt"`, "" },
+		{ "city", "start,end,country\n", countryColumns + `, "city.de": "stadt"`, "the header has no stadt column" },
+	}
+
+	for _, test := range tests {
+		sources, problems := sourcesParse(`{ "mine": { "type": "` + test.sourceType + `", "url": "https://example.com/data.csv", "header": true, "columns": { ` + test.columns + ` } } }`)
+		if len(problems) > 0 {
+			t.Fatalf("%s: unexpected problems: %q", test.columns, problems)
+		}
+
+		_, err := newSourceReader(strings.NewReader(test.contents), DataToLoad{ sources["mine"], "", 4 })
+		if len(test.expected) == 0 && err != nil {
+			t.Errorf("%q: unexpected error: %s", test.contents, err)
+		}
+		if len(test.expected) > 0 && (err == nil || err.Error() != test.expected) {
+			t.Errorf("%q: got %v, expected %s", test.contents, err, test.expected)
+		}
+	}
+}
+
+// A source whose header lacks a named column is skipped, and the sources after it still load
+func TestLoadDataSkipsUnreadableSource(t *testing.T) {
+	directory := t.TempDir()
+
+	brokenPath	:= filepath.Join(directory, "broken.csv")
+	minePath	:= filepath.Join(directory, "mine.csv")
+	err := os.WriteFile(brokenPath, []byte("start,end,cc\n1.0.0.0,1.0.0.255,FR\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(minePath, []byte("start,end,country\n1.0.0.0,1.0.0.255,US\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := `"header": true, "columns": { "ip_range_start": "start", "ip_range_end": "end", "country_code": "country" }`
+	loaded, problems := configParse(map[string]string{
+		"DB_TYPE":	"sqlite",
+		"DB_FILE":	filepath.Join(directory, "ip.db"),
+		"COUNTRY":	"broken,mine",
+		"SOURCES":	`{ "broken": { "type": "country", "url": "https://example.com/broken.csv", ` + columns + ` },
+			"mine": { "type": "country", "url": "https://example.com/mine.csv", ` + columns + ` } }`,
+	})
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	configCurrent.Store(loaded)
+
+	dbConnect()
+	defer dbClose()
+	prepare()
+
+	loadData(context.Background(), []DataToLoad{ { loaded.Sources["broken"], brokenPath, 4 }, { loaded.Sources["mine"], minePath, 4 } })
+
+	request := httptest.NewRequest(http.MethodGet, "/ip/1.0.0.1", nil)
+	request.SetPathValue("ip", "1.0.0.1")
+	recorder := httptest.NewRecorder()
+	getIp(recorder, request)
+
+	var result map[string]any
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("%s (%s)", err, recorder.Body.String())
+	}
+
+	if result["country_code"] != "US" {
+		t.Errorf("got %v, expected US from the source after the skipped one", result["country_code"])
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/netip"
+)
+
+// IANA IPv4 / IPv6 Special-Purpose Address Registries (plus multicast), the most specific match wins
+var specialRanges = []SpecialRange{
+	NewSpecialRange("0.0.0.0/8",			"this-network",		false),
+	NewSpecialRange("10.0.0.0/8",			"private",			false),
+	NewSpecialRange("100.64.0.0/10",		"cgnat",			false),
+	NewSpecialRange("127.0.0.0/8",			"loopback",			false),
+	NewSpecialRange("169.254.0.0/16",		"link-local",		false),
+	NewSpecialRange("172.16.0.0/12",		"private",			false),
+	NewSpecialRange("192.0.0.0/24",			"ietf-protocol",	false),
+	NewSpecialRange("192.0.0.0/29",			"ds-lite",			false),
+	NewSpecialRange("192.0.0.8/32",			"dummy",			false),
+	NewSpecialRange("192.0.0.9/32",			"anycast",			true),
+	NewSpecialRange("192.0.0.10/32",		"anycast",			true),
+	NewSpecialRange("192.0.0.170/31",		"nat64-discovery",	false),
+	NewSpecialRange("192.0.2.0/24",			"documentation",	false),
+	NewSpecialRange("192.31.196.0/24",		"as112",			true),
+	NewSpecialRange("192.52.193.0/24",		"amt",				true),
+	NewSpecialRange("192.88.99.0/24",		"6to4-relay",		false),
+	NewSpecialRange("192.168.0.0/16",		"private",			false),
+	NewSpecialRange("192.175.48.0/24",		"as112",			true),
+	NewSpecialRange("198.18.0.0/15",		"benchmarking",		false),
+	NewSpecialRange("198.51.100.0/24",		"documentation",	false),
+	NewSpecialRange("203.0.113.0/24",		"documentation",	false),
+	NewSpecialRange("224.0.0.0/4",			"multicast",		false),
+	NewSpecialRange("240.0.0.0/4",			"reserved",			false),
+	NewSpecialRange("255.255.255.255/32",	"broadcast",		false),
+
+	NewSpecialRange("::/128",				"unspecified",		false),
+	NewSpecialRange("::1/128",				"loopback",			false),
+	NewSpecialRange("::ffff:0:0/96",		"ipv4-mapped",		false),
+	NewSpecialRange("64:ff9b::/96",			"nat64",			true),
+	NewSpecialRange("64:ff9b:1::/48",		"nat64",			false),
+	NewSpecialRange("100::/64",				"discard",			false),
+	NewSpecialRange("2001::/23",			"ietf-protocol",	false),
+	NewSpecialRange("2001::/32",			"teredo",			false),
+	NewSpecialRange("2001:1::1/128",		"anycast",			true),
+	NewSpecialRange("2001:1::2/128",		"anycast",			true),
+	NewSpecialRange("2001:1::3/128",		"anycast",			true),
+	NewSpecialRange("2001:2::/48",			"benchmarking",		false),
+	NewSpecialRange("2001:3::/32",			"amt",				true),
+	NewSpecialRange("2001:4:112::/48",		"as112",			true),
+	NewSpecialRange("2001:10::/28",			"orchid",			false),
+	NewSpecialRange("2001:20::/28",			"orchid",			true),
+	NewSpecialRange("2001:30::/28",			"drone-remote-id",	true),
+	NewSpecialRange("2001:db8::/32",		"documentation",	false),
+	NewSpecialRange("2002::/16",			"6to4",				true),
+	NewSpecialRange("2620:4f:8000::/48",	"as112",			true),
+	NewSpecialRange("3fff::/20",			"documentation",	false),
+	NewSpecialRange("5f00::/16",			"srv6",				false),
+	NewSpecialRange("fc00::/7",				"unique-local",		false),
+	NewSpecialRange("fe80::/10",			"link-local",		false),
+	NewSpecialRange("ff00::/8",				"multicast",		false),
+}
+
+// Registry type of the address (`public` if it isn't special-purpose) and whether it is globally reachable
+func addressClassify(address netip.Addr) (string, bool) {
+	var match *SpecialRange
+	for i, specialRange := range specialRanges {
+		// Prefixes never contain addresses of the other family, so `::ffff:10.0.0.1` isn't treated as `10.0.0.1` here
+		if specialRange.Prefix.Contains(address) && (match == nil || specialRange.Prefix.Bits() > match.Prefix.Bits()) {
+			match = &specialRanges[i]
+		}
+	}
+
+	if match == nil {
+		return "public", true
+	}
+
+	return match.AddressType, match.Global
+}
+
+// Special-purpose addresses are returned (without location data) unless `REJECT_SPECIAL_ADDRESSES` is set
+func rejectSpecialAddresses() bool {
+	return config().RejectSpecialAddresses
+}
+
+// Transition prefixes with an embedded IPv4 address
+var specialPrefix6to4		= netip.MustParsePrefix("2002::/16")
+var specialPrefixTeredo		= netip.MustParsePrefix("2001::/32")
+var specialPrefixNat64		= netip.MustParsePrefix("64:ff9b::/96")
+
+// IPv4 address carried inside an IPv6 transition address, and the mechanism used (empty if there isn't one)
+func addressEmbeddedIpv4(address netip.Addr) (netip.Addr, string) {
+	if address.Is4In6() {
+		return address.Unmap(), "ipv4-mapped"
+	}
+
+	if !address.Is6() {
+		return address, ""
+	}
+
+	bytes := address.As16()
+	switch {
+		// 2002:AABB:CCDD::/48 (RFC 3056)
+		case specialPrefix6to4.Contains(address):
+			return netip.AddrFrom4([4]byte{ bytes[2], bytes[3], bytes[4], bytes[5] }), "6to4"
+
+		// The client address is held (inverted) in the last 32 bits (RFC 4380)
+		case specialPrefixTeredo.Contains(address):
+			return netip.AddrFrom4([4]byte{ bytes[12] ^ 0xff, bytes[13] ^ 0xff, bytes[14] ^ 0xff, bytes[15] ^ 0xff }), "teredo"
+
+		// Well-known prefix only, the last 32 bits (RFC 6052)
+		case specialPrefixNat64.Contains(address):
+			return netip.AddrFrom4([4]byte{ bytes[12], bytes[13], bytes[14], bytes[15] }), "nat64"
+	}
+
+	return address, ""
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestAddressClassify(t *testing.T) {
+	tests := []struct {
+		address		string
+		addressType	string
+		global		bool
+	}{
+		{ "8.8.8.8",							"public",			true },
+		{ "0.1.2.3",							"this-network",		false },
+		{ "10.1.2.3",							"private",			false },
+		{ "172.31.255.255",						"private",			false },
+		{ "172.32.0.0",							"public",			true },
+		{ "192.168.1.1",						"private",			false },
+		{ "100.64.0.1",							"cgnat",			false },
+		{ "127.0.0.1",							"loopback",			false },
+		{ "169.254.1.1",						"link-local",		false },
+		{ "192.0.0.1",							"ds-lite",			false },
+		{ "192.0.0.8",							"dummy",			false },
+		{ "192.0.0.9",							"anycast",			true },
+		{ "192.0.0.100",						"ietf-protocol",	false },
+		{ "192.0.2.1",							"documentation",	false },
+		{ "198.19.255.255",						"benchmarking",		false },
+		{ "224.0.0.1",							"multicast",		false },
+		{ "240.0.0.1",							"reserved",			false },
+		{ "255.255.255.255",					"broadcast",		false },
+		{ "2606:4700::1111",					"public",			true },
+		{ "::",									"unspecified",		false },
+		{ "::1",								"loopback",			false },
+		{ "::ffff:10.0.0.1",					"ipv4-mapped",		false },
+		{ "64:ff9b::808:808",					"nat64",			true },
+		{ "64:ff9b:1::1",						"nat64",			false },
+		{ "2001:0:4136:e378::1",				"teredo",			false },
+		{ "2001:1::1",							"anycast",			true },
+		{ "2001:1::4",							"ietf-protocol",	false },
+		{ "2001:db8::1",						"documentation",	false },
+		{ "2002:c000:204::1",					"6to4",				true },
+		{ "fd00::1",							"unique-local",		false },
+		{ "fe80::1",							"link-local",		false },
+		{ "ff02::1",							"multicast",		false },
+	}
+
+	for _, test := range tests {
+		addressType, global := addressClassify(netip.MustParseAddr(test.address))
+		if addressType != test.addressType || global != test.global {
+			t.Errorf("%s: got %s / %t, expected %s / %t", test.address, addressType, global, test.addressType, test.global)
+		}
+	}
+}
+
+func TestAddressEmbeddedIpv4(t *testing.T) {
+	tests := []struct {
+		address		string
+		embedded	string
+		mechanism	string
+	}{
+		{ "::ffff:8.8.8.8",						"8.8.8.8",					"ipv4-mapped" },
+		{ "2002:c000:204::1",					"192.0.2.4",				"6to4" },
+		{ "2002:0808:0808:1::2",				"8.8.8.8",					"6to4" },
+		{ "2001:0:4136:e378:8000:63bf:3fff:fdd2",	"192.0.2.45",			"teredo" },
+		{ "64:ff9b::808:808",					"8.8.8.8",					"nat64" },
+		{ "64:ff9b::c000:221",					"192.0.2.33",				"nat64" },
+		// Only the well-known NAT64 prefix is decoded
+		{ "64:ff9b:1::808:808",					"64:ff9b:1::808:808",		"" },
+		{ "2606:4700::1111",					"2606:4700::1111",			"" },
+		{ "8.8.8.8",							"8.8.8.8",					"" },
+	}
+
+	for _, test := range tests {
+		embedded, mechanism := addressEmbeddedIpv4(netip.MustParseAddr(test.address))
+		if embedded.String() != test.embedded || mechanism != test.mechanism {
+			t.Errorf("%s: got %s / %q, expected %s / %q", test.address, embedded, mechanism, test.embedded, test.mechanism)
+		}
+	}
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
-	"os"
 	"strconv"
 	"strings"
 
@@ -15,7 +16,7 @@ import (
 var sqliteDb *sql.DB
 
 func sqliteConnect() {
-	connStr		:= fmt.Sprintf(os.Getenv("DB_FILE"))
+	connStr		:= config().DbFile
 	conn, err	:= sql.Open("sqlite", connStr)
 	if err != nil {
 		panic(err)
@@ -31,24 +32,32 @@ func sqliteClose() {
 	}
 }
 
-func sqliteInitialised(key string) bool {
+func sqliteInitialised(key string, source string) bool {
 	var table string
 	switch key {
 		case "COUNTRY": table = "ipv4_country"
 		case "ASN": 	table = "ipv4_asn"
 		case "CITY": 	table = "ipv4_city"
+		case "REPUTATION":	table = "ipv4_reputation"
+		case "GEOFEED":		table = "ipv4_geofeed"
+		case "CONSENSUS":	table = "ipv4_consensus"
 	}
 
 	schema := sqliteGetOptionalSchema()
 
 	var total int
+	var params []any
 	sqlString := fmt.Sprintf(`
 		SELECT		COUNT(*) AS "total"
 		
 		FROM		%s"%s"
 		`,
 		schema, table)
-	row := sqliteDb.QueryRow(sqlString)
+	if len(source) > 0 {
+		sqlString += `WHERE "source" = ?`
+		params = append(params, source)
+	}
+	row := sqliteDb.QueryRow(sqlString, params...)
 	if err := row.Scan(&total); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false
@@ -60,105 +69,328 @@ func sqliteInitialised(key string) bool {
 	return total > 0
 }
 
-func sqliteIp(ip net.IP) *Ip {
+func sqliteCity(ctx context.Context, ip net.IP, source string, dbVersion int) (IpCity, bool) {
 	ipString	:= ip.String();
 	ipVersion	:= getIpVersion(ipString)
 	ipNumber	:= sqliteGetIpNumber(ipVersion, ipString)
-	ipStruct	:= NewIp(ipString, ipVersion)
 	schema		:= sqliteGetOptionalSchema()
+	city			:= IpCity{ Source: source }
 
-	if hasCityDatabase() {
-		sqlString := fmt.Sprintf(`
-			SELECT		"country_code", 
-						"state1", 
-						"state2", 
-						"city", 
-						"postcode", 
-						"latitude", 
-						"longitude", 
-						"timezone"
+	sqlString := fmt.Sprintf(`
+		SELECT		"country_code", 
+					"state1", 
+					"state2", 
+					"city", 
+					"postcode", 
+					"latitude", 
+					"longitude", 
+					"timezone",
+					"names"
+					
+		FROM		(
+						SELECT		*
 						
-			FROM		%s"ipv%d_city"
-			 
-			WHERE		"ip_number_start" <= ? 
-
-			ORDER BY	"ip_number_start" DESC
-				 
-			LIMIT		1`,
-			schema, ipVersion)
-
-		row := sqliteDb.QueryRow(sqlString, ipNumber)
-		if err := row.Scan(&ipStruct.CountryCode, &ipStruct.State1, &ipStruct.State2, &ipStruct.City, &ipStruct.Postcode, &ipStruct.Latitude, &ipStruct.Longitude, &ipStruct.Timezone); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return ipStruct
-			}
+						FROM		%s"ipv%d_city"
+						
+						WHERE		"source" = ?
+						AND			"ip_number_start" <= ?
+						AND			(? = 0 OR "db_version" = ?)
+						
+						ORDER BY	"ip_number_start" DESC
+						
+						LIMIT		1
+					) AS "latest"
 
-			panic(err)
+		WHERE		"ip_number_end" >= ?`,
+		schema, ipVersion)
+
+	var names string
+	queryCtx, span := traceQuery(ctx, "ip_city", ipVersion)
+	row := sqliteDb.QueryRowContext(queryCtx, sqlString, source, ipNumber, dbVersion, dbVersion, ipNumber)
+	err := row.Scan(&city.CountryCode, &city.State1, &city.State2, &city.City, &city.Postcode, &city.Latitude, &city.Longitude, &city.Timezone, &names)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return city, false
 		}
 
-		if len(ipStruct.City) > 0 {
-			ipStruct.FoundCity = true
+		panic(err)
+	}
+
+	city.Names = parseLocalisedNames(names)
+
+	return city, true
+}
+
+func sqliteCountry(ctx context.Context, ip net.IP, source string, dbVersion int) (IpCountry, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	ipNumber	:= sqliteGetIpNumber(ipVersion, ipString)
+	schema		:= sqliteGetOptionalSchema()
+	country		:= IpCountry{ Source: source }
+
+	sqlString := fmt.Sprintf(`
+		SELECT		"country_code" 
+					
+		FROM		(
+						SELECT		*
+						
+						FROM		%s"ipv%d_country"
+						
+						WHERE		"source" = ?
+						AND			"ip_number_start" <= ?
+						AND			(? = 0 OR "db_version" = ?)
+						
+						ORDER BY	"ip_number_start" DESC
+						
+						LIMIT		1
+					) AS "latest"
+
+		WHERE		"ip_number_end" >= ?`,
+		schema, ipVersion)
+	queryCtx, span := traceQuery(ctx, "ip_country", ipVersion)
+	row := sqliteDb.QueryRowContext(queryCtx, sqlString, source, ipNumber, dbVersion, dbVersion, ipNumber)
+	err := row.Scan(&country.CountryCode)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return country, false
 		}
+
+		panic(err)
 	}
 
-	if len(ipStruct.CountryCode) > 0 {
-		ipStruct.FoundCountry = true
-	} else {
-		if hasCountryDatabase() {
-			sqlString := fmt.Sprintf(`
-				SELECT		"country_code" 
-							
-				FROM		%s"ipv%d_country"
-				 
-				WHERE		"ip_number_start" <= ? 
-				
-				ORDER BY	"ip_number_start" DESC
-					 
-				LIMIT		1`,
-				schema, ipVersion)
-			row := sqliteDb.QueryRow(sqlString, ipNumber)
-			if err := row.Scan(&ipStruct.CountryCode); err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					return ipStruct
-				}
+	return country, true
+}
 
-				panic(err)
-			}
+func sqliteASN(ctx context.Context, ip net.IP, source string, dbVersion int) (IpASN, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	ipNumber	:= sqliteGetIpNumber(ipVersion, ipString)
+	schema		:= sqliteGetOptionalSchema()
+	asn			:= IpASN{ Source: source }
 
-			if len(ipStruct.CountryCode) > 0 {
-				ipStruct.FoundCountry = true
-			}
+	sqlString := fmt.Sprintf(`
+		SELECT		"as_number",
+					"as_organisation" 
+					
+		FROM		(
+						SELECT		*
+						
+						FROM		%s"ipv%d_asn"
+						
+						WHERE		"source" = ?
+						AND			"ip_number_start" <= ?
+						AND			(? = 0 OR "db_version" = ?)
+						
+						ORDER BY	"ip_number_start" DESC
+						
+						LIMIT		1
+					) AS "latest"
+
+		WHERE		"ip_number_end" >= ?`,
+		schema, ipVersion)
+	queryCtx, span := traceQuery(ctx, "ip_asn", ipVersion)
+	row := sqliteDb.QueryRowContext(queryCtx, sqlString, source, ipNumber, dbVersion, dbVersion, ipNumber)
+	err := row.Scan(&asn.AsNumber, &asn.AsOrganisation)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return asn, false
 		}
+
+		panic(err)
 	}
 
-	if hasASNDatabase() {
-		sqlString := fmt.Sprintf(`
-			SELECT		"as_number",
-						"as_organisation" 
+	return asn, true
+}
+
+func sqliteReputation(ctx context.Context, ip net.IP, dbVersion int) (IpReputation, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	ipNumber	:= sqliteGetIpNumber(ipVersion, ipString)
+	schema		:= sqliteGetOptionalSchema()
+	reputation	:= IpReputation{}
+
+	sqlString := fmt.Sprintf(`
+		SELECT		"is_tor",
+					"is_vpn",
+					"is_hosting",
+					"tags"
+
+		FROM		(
+						SELECT		*
 						
-			FROM		%s"ipv%d_asn"
-			 
-			WHERE		"ip_number_start" <= ? 
-
-			ORDER BY	"ip_number_start" DESC
-						 
-			LIMIT		1`,
-			schema, ipVersion)
-		row := sqliteDb.QueryRow(sqlString, ipNumber)
-		if err := row.Scan(&ipStruct.OrganisationNumber, &ipStruct.OrganisationName); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return ipStruct
-			}
+						FROM		%s"ipv%d_reputation"
+						
+						WHERE		"ip_number_start" <= ?
+						AND			(? = 0 OR "db_version" = ?)
+						
+						ORDER BY	"ip_number_start" DESC
+						
+						LIMIT		1
+					) AS "latest"
+
+		WHERE		"ip_number_end" >= ?`,
+		schema, ipVersion)
+	queryCtx, span := traceQuery(ctx, "ip_reputation", ipVersion)
+	row := sqliteDb.QueryRowContext(queryCtx, sqlString, ipNumber, dbVersion, dbVersion, ipNumber)
+	err := row.Scan(&reputation.IsTor, &reputation.IsVpn, &reputation.IsHosting, &reputation.Tags)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return reputation, false
+		}
 
-			panic(err)
+		panic(err)
+	}
+
+	return reputation, true
+}
+
+func sqliteGeofeed(ctx context.Context, ip net.IP, dbVersion int) (IpGeofeed, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	ipNumber	:= sqliteGetIpNumber(ipVersion, ipString)
+	schema		:= sqliteGetOptionalSchema()
+	geofeed		:= IpGeofeed{}
+
+	sqlString := fmt.Sprintf(`
+		SELECT		"country_code",
+					"state1",
+					"city"
+
+		FROM		(
+						SELECT		*
+						
+						FROM		%s"ipv%d_geofeed"
+						
+						WHERE		"ip_number_start" <= ?
+						AND			(? = 0 OR "db_version" = ?)
+						
+						ORDER BY	"ip_number_start" DESC
+						
+						LIMIT		1
+					) AS "latest"
+
+		WHERE		"ip_number_end" >= ?`,
+		schema, ipVersion)
+	queryCtx, span := traceQuery(ctx, "ip_geofeed", ipVersion)
+	row := sqliteDb.QueryRowContext(queryCtx, sqlString, ipNumber, dbVersion, dbVersion, ipNumber)
+	err := row.Scan(&geofeed.CountryCode, &geofeed.State1, &geofeed.City)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return geofeed, false
 		}
 
-		if len(ipStruct.OrganisationName) > 0 {
-			ipStruct.FoundASN = true
+		panic(err)
+	}
+
+	return geofeed, true
+}
+
+func sqliteConsensus(ctx context.Context, ip net.IP, dbVersion int) (IpConsensus, bool) {
+	ipString	:= ip.String();
+	ipVersion	:= getIpVersion(ipString)
+	ipNumber	:= sqliteGetIpNumber(ipVersion, ipString)
+	schema		:= sqliteGetOptionalSchema()
+	consensus	:= IpConsensus{}
+
+	sqlString := fmt.Sprintf(`
+		SELECT		"country_code",
+					"confidence",
+					"dissent"
+
+		FROM		(
+						SELECT		*
+						
+						FROM		%s"ipv%d_consensus"
+						
+						WHERE		"ip_number_start" <= ?
+						AND			(? = 0 OR "db_version" = ?)
+						
+						ORDER BY	"ip_number_start" DESC
+						
+						LIMIT		1
+					) AS "latest"
+
+		WHERE		"ip_number_end" >= ?`,
+		schema, ipVersion)
+	queryCtx, span := traceQuery(ctx, "ip_consensus", ipVersion)
+	row := sqliteDb.QueryRowContext(queryCtx, sqlString, ipNumber, dbVersion, dbVersion, ipNumber)
+	err := row.Scan(&consensus.CountryCode, &consensus.Confidence, &consensus.Dissent)
+	traceEnd(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return consensus, false
 		}
+
+		panic(err)
+	}
+
+	return consensus, true
+}
+
+func sqliteSampleRanges(table string, source string, ipVersion int, dbVersion int) []SampleRange {
+	schema := sqliteGetOptionalSchema()
+	column := sampleValueColumn(table)
+	table = strings.Replace(table, "ip_", "ipv" + strconv.Itoa(ipVersion) + "_", 1)
+
+	sqlString := fmt.Sprintf(`SELECT "ip_range_start", "ip_range_end", CAST("%s" AS TEXT) FROM %s"%s" WHERE "ip_version" = ? AND "source" = ? AND (? = 0 OR "db_version" = ?) ORDER BY "ip_number_start"`, column, schema, table)
+	rows, err := sqliteDb.Query(sqlString, ipVersion, source, dbVersion, dbVersion)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return sampleRangesFromRows(rows)
+}
+
+func sqliteDiffRanges(table string, source string, ipVersion int, dbVersion int) []SampleRange {
+	schema	:= sqliteGetOptionalSchema()
+	columns	:= diffColumns(table)
+	table = strings.Replace(table, "ip_", "ipv" + strconv.Itoa(ipVersion) + "_", 1)
+
+	sqlString := `SELECT "ip_range_start", "ip_range_end"`
+	for _, column := range columns {
+		sqlString += fmt.Sprintf(`, CAST("%s" AS TEXT)`, column)
+	}
+	sqlString += fmt.Sprintf(` FROM %s"%s" WHERE "db_version" = ?`, schema, table)
+	params := []any{ dbVersion }
+	if len(source) > 0 {
+		sqlString += ` AND "source" = ?`
+		params = append(params, source)
+	}
+	sqlString += ` ORDER BY "ip_number_start"`
+
+	rows, err := sqliteDb.Query(sqlString, params...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return diffRangesFromRows(rows, len(columns))
+}
+
+func sqliteExport(table string, source string, ipVersion int, dbVersion int, read func(*sql.Rows)) {
+	schema	:= sqliteGetOptionalSchema()
+	columns	:= exportColumns(table)
+	table = strings.Replace(table, "ip_", "ipv" + strconv.Itoa(ipVersion) + "_", 1)
+
+	sqlString := `SELECT "ip_range_start", "ip_range_end"`
+	for _, column := range columns {
+		sqlString += fmt.Sprintf(`, "%s"`, column)
 	}
+	sqlString += fmt.Sprintf(` FROM %s"%s" WHERE "source" = ? AND (? = 0 OR "db_version" = ?) ORDER BY "ip_number_start"`, schema, table)
 
-	return ipStruct
+	rows, err := sqliteDb.Query(sqlString, source, dbVersion, dbVersion)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	read(rows)
 }
 
 func sqliteQueryMaxVersion(table string, ipVersion int) int {
@@ -180,23 +412,31 @@ func sqliteQueryMaxVersion(table string, ipVersion int) int {
 	return version
 }
 
-func sqliteDropOld(table string, ipVersion int, dbVersion int) {
+func sqliteDropOld(table string, source string, ipVersion int, dbVersion int) {
 	schema := sqliteGetOptionalSchema()
 	table = strings.Replace(table, "ip_", "ipv" + strconv.Itoa(ipVersion) + "_", 1)
 
-	fmt.Printf(`Dropping old SQLite data: %s"%s" ipv%d... `, schema, table, ipVersion)
-	sqlString := fmt.Sprintf(`DELETE FROM %s"%s" WHERE "ip_version" = ? AND "db_version" < ?`, schema, table)
-	_, err := sqliteDb.Exec(sqlString, ipVersion, dbVersion)
+	slog.Info("dropping old data", "db", "sqlite", "schema", config().DbSchema, "table", table, "source", source, "ip_version", ipVersion, "db_version", dbVersion)
+	sqlString	:= fmt.Sprintf(`DELETE FROM %s"%s" WHERE "ip_version" = ? AND "db_version" < ?`, schema, table)
+	params		:= []any{ ipVersion, dbVersion }
+	if len(source) > 0 {
+		sqlString += ` AND "source" = ?`
+		params = append(params, source)
+	}
+	_, err := sqliteDb.Exec(sqlString, params...)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("Complete")
+	slog.Info("dropped old data", "db", "sqlite", "schema", config().DbSchema, "table", table, "ip_version", ipVersion)
 }
 
 func sqliteSaveCountries(countries []IpCountry) {
-	var params []any
+	sqliteInsertCountries(sqliteDb, sqliteGetOptionalSchema(), countries)
+}
 
-	schema := sqliteGetOptionalSchema()
+// Also used to write exported files, which have their own connection
+func sqliteInsertCountries(db *sql.DB, schema string, countries []IpCountry) {
+	var params []any
 
 	sqlString := fmt.Sprintf(
 		`INSERT INTO %s"ipv%d_country" (
@@ -206,7 +446,8 @@ func sqliteSaveCountries(countries []IpCountry) {
 			"ip_number_end", 
 			"country_code", 
 			"ip_version", 
-			"db_version"
+			"db_version", 
+			"source"
 		) VALUES `,
 		schema, countries[0].IpVersion)
 
@@ -214,7 +455,7 @@ func sqliteSaveCountries(countries []IpCountry) {
 		ipNumberStart	:= sqliteGetIpNumber(country.IpVersion, country.IpRangeStart)
 		ipNumberEnd		:= sqliteGetIpNumber(country.IpVersion, country.IpRangeEnd)
 
-		sqlString += `(?, ?, ?, ?, ?, ?, ?), `
+		sqlString += `(?, ?, ?, ?, ?, ?, ?, ?), `
 		params = append(params,
 			country.IpRangeStart,
 			country.IpRangeEnd,
@@ -223,12 +464,13 @@ func sqliteSaveCountries(countries []IpCountry) {
 			country.CountryCode,
 			country.IpVersion,
 			country.DbVersion,
+			country.Source,
 		)
 	}
 	sqlString = sqlString[0:len(sqlString) - 2]
 	sqlString = fixPostgresVars(sqlString)
 
-	stmt, err := sqliteDb.Prepare(sqlString)
+	stmt, err := db.Prepare(sqlString)
 	if err != nil {
 		panic(err)
 	}
@@ -241,9 +483,11 @@ func sqliteSaveCountries(countries []IpCountry) {
 }
 
 func sqliteSaveASNs(ASNs []IpASN) {
-	var params []any
+	sqliteInsertASNs(sqliteDb, sqliteGetOptionalSchema(), ASNs)
+}
 
-	schema := sqliteGetOptionalSchema()
+func sqliteInsertASNs(db *sql.DB, schema string, ASNs []IpASN) {
+	var params []any
 
 	sqlString := fmt.Sprintf(
 		`INSERT INTO %s"ipv%d_asn" (
@@ -254,7 +498,8 @@ func sqliteSaveASNs(ASNs []IpASN) {
 			"as_number", 
 			"as_organisation", 
 			"ip_version", 
-			"db_version"
+			"db_version", 
+			"source"
 		) VALUES `,
 		schema, ASNs[0].IpVersion)
 
@@ -262,7 +507,7 @@ func sqliteSaveASNs(ASNs []IpASN) {
 		ipNumberStart	:= sqliteGetIpNumber(asn.IpVersion, asn.IpRangeStart)
 		ipNumberEnd		:= sqliteGetIpNumber(asn.IpVersion, asn.IpRangeEnd)
 
-		sqlString += `(?, ?, ?, ?, ?, ?, ?, ?), `
+		sqlString += `(?, ?, ?, ?, ?, ?, ?, ?, ?), `
 		params = append(params,
 			asn.IpRangeStart,
 			asn.IpRangeEnd,
@@ -272,12 +517,13 @@ func sqliteSaveASNs(ASNs []IpASN) {
 			asn.AsOrganisation,
 			asn.IpVersion,
 			asn.DbVersion,
+			asn.Source,
 		)
 	}
 	sqlString = sqlString[0:len(sqlString) - 2]
 	sqlString = fixPostgresVars(sqlString)
 
-	stmt, err := sqliteDb.Prepare(sqlString)
+	stmt, err := db.Prepare(sqlString)
 	if err != nil {
 		panic(err)
 	}
@@ -290,9 +536,11 @@ func sqliteSaveASNs(ASNs []IpASN) {
 }
 
 func sqliteSaveCities(cities []IpCity) {
-	var params []any
+	sqliteInsertCities(sqliteDb, sqliteGetOptionalSchema(), cities)
+}
 
-	schema := sqliteGetOptionalSchema()
+func sqliteInsertCities(db *sql.DB, schema string, cities []IpCity) {
+	var params []any
 
 	sqlString := fmt.Sprintf(
 		`INSERT INTO %s"ipv%d_city" (
@@ -308,8 +556,10 @@ func sqliteSaveCities(cities []IpCity) {
 			"latitude", 
 			"longitude", 
 			"timezone", 
+			"names", 
 			"ip_version", 
-			"db_version"
+			"db_version", 
+			"source"
 		) VALUES `,
 		schema, cities[0].IpVersion)
 
@@ -317,7 +567,7 @@ func sqliteSaveCities(cities []IpCity) {
 		ipNumberStart	:= sqliteGetIpNumber(city.IpVersion, city.IpRangeStart)
 		ipNumberEnd		:= sqliteGetIpNumber(city.IpVersion, city.IpRangeEnd)
 
-		sqlString += `(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?), `
+		sqlString += `(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?), `
 		params = append(params,
 			city.IpRangeStart,
 			city.IpRangeEnd,
@@ -331,8 +581,165 @@ func sqliteSaveCities(cities []IpCity) {
 			city.Latitude,
 			city.Longitude,
 			city.Timezone,
+			city.Names.String(),
 			city.IpVersion,
 			city.DbVersion,
+			city.Source,
+		)
+	}
+	sqlString = sqlString[0:len(sqlString) - 2]
+	sqlString = fixPostgresVars(sqlString)
+
+	stmt, err := db.Prepare(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(params...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func sqliteSaveReputations(reputations []IpReputation) {
+	var params []any
+
+	schema := sqliteGetOptionalSchema()
+
+	sqlString := fmt.Sprintf(
+		`INSERT INTO %s"ipv%d_reputation" (
+			"ip_range_start", 
+			"ip_range_end", 
+			"ip_number_start", 
+			"ip_number_end", 
+			"is_tor", 
+			"is_vpn", 
+			"is_hosting", 
+			"tags", 
+			"ip_version", 
+			"db_version"
+		) VALUES `,
+		schema, reputations[0].IpVersion)
+
+	for _, reputation := range reputations {
+		ipNumberStart	:= sqliteGetIpNumber(reputation.IpVersion, reputation.IpRangeStart)
+		ipNumberEnd		:= sqliteGetIpNumber(reputation.IpVersion, reputation.IpRangeEnd)
+
+		sqlString += `(?, ?, ?, ?, ?, ?, ?, ?, ?, ?), `
+		params = append(params,
+			reputation.IpRangeStart,
+			reputation.IpRangeEnd,
+			ipNumberStart,
+			ipNumberEnd,
+			reputation.IsTor,
+			reputation.IsVpn,
+			reputation.IsHosting,
+			reputation.Tags,
+			reputation.IpVersion,
+			reputation.DbVersion,
+		)
+	}
+	sqlString = sqlString[0:len(sqlString) - 2]
+	sqlString = fixPostgresVars(sqlString)
+
+	stmt, err := sqliteDb.Prepare(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(params...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func sqliteSaveGeofeeds(geofeeds []IpGeofeed) {
+	var params []any
+
+	schema := sqliteGetOptionalSchema()
+
+	sqlString := fmt.Sprintf(
+		`INSERT INTO %s"ipv%d_geofeed" (
+			"ip_range_start", 
+			"ip_range_end", 
+			"ip_number_start", 
+			"ip_number_end", 
+			"country_code", 
+			"state1", 
+			"city", 
+			"ip_version", 
+			"db_version"
+		) VALUES `,
+		schema, geofeeds[0].IpVersion)
+
+	for _, geofeed := range geofeeds {
+		ipNumberStart	:= sqliteGetIpNumber(geofeed.IpVersion, geofeed.IpRangeStart)
+		ipNumberEnd		:= sqliteGetIpNumber(geofeed.IpVersion, geofeed.IpRangeEnd)
+
+		sqlString += `(?, ?, ?, ?, ?, ?, ?, ?, ?), `
+		params = append(params,
+			geofeed.IpRangeStart,
+			geofeed.IpRangeEnd,
+			ipNumberStart,
+			ipNumberEnd,
+			geofeed.CountryCode,
+			geofeed.State1,
+			geofeed.City,
+			geofeed.IpVersion,
+			geofeed.DbVersion,
+		)
+	}
+	sqlString = sqlString[0:len(sqlString) - 2]
+	sqlString = fixPostgresVars(sqlString)
+
+	stmt, err := sqliteDb.Prepare(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(params...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func sqliteSaveConsensuses(consensuses []IpConsensus) {
+	var params []any
+
+	schema := sqliteGetOptionalSchema()
+
+	sqlString := fmt.Sprintf(
+		`INSERT INTO %s"ipv%d_consensus" (
+			"ip_range_start", 
+			"ip_range_end", 
+			"ip_number_start", 
+			"ip_number_end", 
+			"country_code", 
+			"confidence", 
+			"dissent", 
+			"ip_version", 
+			"db_version"
+		) VALUES `,
+		schema, consensuses[0].IpVersion)
+
+	for _, consensus := range consensuses {
+		ipNumberStart	:= sqliteGetIpNumber(consensus.IpVersion, consensus.IpRangeStart)
+		ipNumberEnd		:= sqliteGetIpNumber(consensus.IpVersion, consensus.IpRangeEnd)
+
+		sqlString += `(?, ?, ?, ?, ?, ?, ?, ?, ?), `
+		params = append(params,
+			consensus.IpRangeStart,
+			consensus.IpRangeEnd,
+			ipNumberStart,
+			ipNumberEnd,
+			consensus.CountryCode,
+			consensus.Confidence,
+			consensus.Dissent,
+			consensus.IpVersion,
+			consensus.DbVersion,
 		)
 	}
 	sqlString = sqlString[0:len(sqlString) - 2]
@@ -350,15 +757,78 @@ func sqliteSaveCities(cities []IpCity) {
 	}
 }
 
+func sqliteMigrate() {
+	for _, ipVersion := range []int{ 4, 6 } {
+		sqliteAddColumn(fmt.Sprintf("ipv%d_city", ipVersion), "names", `TEXT NOT NULL DEFAULT ''`)
+
+		for _, table := range []string{ "ip_country", "ip_city", "ip_asn" } {
+			sqliteAddSource(strings.Replace(table, "ip_", "ipv" + strconv.Itoa(ipVersion) + "_", 1), datasetType(table))
+		}
+	}
+}
+
+// Rows loaded before sources were recorded came from the (then single) configured source
+func sqliteAddSource(table string, key string) {
+	schema := sqliteGetOptionalSchema()
+
+	if sqliteAddColumn(table, "source", `TEXT NOT NULL DEFAULT ''`) && len(datasetSources(key)) > 0 {
+		_, err := sqliteDb.Exec(fmt.Sprintf(`UPDATE %s"%s" SET "source" = ? WHERE "source" = ''`, schema, table), datasetSources(key)[0])
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	indexName := "I:" + table + ":source"
+	if len(config().DbSchema) > 0 {
+		indexName = "I:" + config().DbSchema + ":" + table + ":source"
+	}
+
+	sqlString := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s"%s" ON "%s" ("source", "ip_number_start")`, schema, indexName, table)
+	_, err := sqliteDb.Exec(sqlString)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// SQLite has no `ADD COLUMN IF NOT EXISTS`
+func sqliteAddColumn(table string, column string, definition string) bool {
+	schemaName := config().DbSchema
+	if len(schemaName) == 0 {
+		schemaName = "main"
+	}
+
+	var total int
+	row := sqliteDb.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?, ?) WHERE "name" = ?`, table, schemaName, column)
+	if err := row.Scan(&total); err != nil {
+		panic(err)
+	}
+
+	if total == 0 {
+		slog.Info("adding column", "db", "sqlite", "schema", config().DbSchema, "table", table, "column", column)
+		_, err := sqliteDb.Exec(fmt.Sprintf(`ALTER TABLE %s"%s" ADD COLUMN "%s" %s`, sqliteGetOptionalSchema(), table, column, definition))
+		if err != nil {
+			panic(err)
+		}
+
+		return true
+	}
+
+	return false
+}
+
 func sqliteFile(sqlPath string) {
+	sqliteStructure(sqliteDb, sqlPath, config().DbSchema)
+}
+
+func sqliteStructure(db *sql.DB, sqlPath string, schemaName string) {
 	sqlBytes, err := dbStructures.ReadFile(sqlPath)
 	//sqlBytes, err := os.ReadFile(sqlPath)
 	if err != nil {
 		panic(err)
 	}
 	sqlString := string(sqlBytes)
-	if len(os.Getenv("DB_SCHEMA")) > 0 {
-		sqlString = strings.Replace(sqlString, "${schema}", os.Getenv("DB_SCHEMA"), -1)
+	if len(schemaName) > 0 {
+		sqlString = strings.Replace(sqlString, "${schema}", schemaName, -1)
 	} else {
 		sqlString = strings.Replace(sqlString, `"${schema}".`, "", -1)
 		sqlString = strings.Replace(sqlString, `${schema}:`, "", -1)
@@ -366,7 +836,7 @@ func sqliteFile(sqlPath string) {
 
 	sqlStatements := strings.Split(sqlString, ";")
 
-	transaction, err := sqliteDb.Begin()
+	transaction, err := db.Begin()
 	if err != nil {
 		panic(err)
 	}
@@ -391,8 +861,8 @@ func sqliteFile(sqlPath string) {
 
 func sqliteGetOptionalSchema() string {
 	schema := ""
-	if len(os.Getenv("DB_SCHEMA")) > 0 {
-		schema = fmt.Sprintf(`"%s".`, os.Getenv("DB_SCHEMA"))
+	if len(config().DbSchema) > 0 {
+		schema = fmt.Sprintf(`"%s".`, config().DbSchema)
 	}
 
 	return schema
@@ -408,4 +878,77 @@ func sqliteGetIpNumber(ipVersion int, ipString string) any {
 	}
 
 	return ipNumber
+}
+
+func sqliteOverrides() []Override {
+	sqlString := fmt.Sprintf(`SELECT "id", "network", "record" FROM %s"ip_override" ORDER BY "id"`, sqliteGetOptionalSchema())
+	rows, err := sqliteDb.Query(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return overridesFromRows(rows)
+}
+
+func sqliteSaveOverride(override Override) Override {
+	schema := sqliteGetOptionalSchema()
+
+	if override.Id == 0 {
+		sqlString := fmt.Sprintf(`INSERT INTO %s"ip_override" ("network", "record") VALUES (?, ?)`, schema)
+		result, err := sqliteDb.Exec(sqlString, override.Network, overrideRecord(override))
+		if err != nil {
+			panic(err)
+		}
+
+		override.Id, err = result.LastInsertId()
+		if err != nil {
+			panic(err)
+		}
+
+		return override
+	}
+
+	sqlString := fmt.Sprintf(`UPDATE %s"ip_override" SET "network" = ?, "record" = ? WHERE "id" = ?`, schema)
+	_, err := sqliteDb.Exec(sqlString, override.Network, overrideRecord(override), override.Id)
+	if err != nil {
+		panic(err)
+	}
+
+	return override
+}
+
+func sqliteDeleteOverride(id int64) {
+	sqlString := fmt.Sprintf(`DELETE FROM %s"ip_override" WHERE "id" = ?`, sqliteGetOptionalSchema())
+	_, err := sqliteDb.Exec(sqlString, id)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func sqliteVersions() []DatasetVersion {
+	sqlString := fmt.Sprintf(`SELECT "dataset", "source", "ip_version", "db_version", "loaded_at" FROM %s"ip_load"`, sqliteGetOptionalSchema())
+	rows, err := sqliteDb.Query(sqlString)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	return versionsFromRows(rows)
+}
+
+func sqliteSaveVersion(version DatasetVersion) {
+	sqlString := fmt.Sprintf(`INSERT INTO %s"ip_load" ("dataset", "source", "ip_version", "db_version", "loaded_at") VALUES (?, ?, ?, ?, ?)`, sqliteGetOptionalSchema())
+	_, err := sqliteDb.Exec(sqlString, version.Table, version.Source, version.IpVersion, version.DbVersion, version.LoadedAt.Unix())
+	if err != nil {
+		panic(err)
+	}
+}
+
+func sqliteDeleteVersions(table string, source string, ipVersion int, dbVersion int) {
+	sqlString := fmt.Sprintf(`DELETE FROM %s"ip_load" WHERE "dataset" = ? AND "source" = ? AND "ip_version" = ? AND "db_version" < ?`, sqliteGetOptionalSchema())
+	_, err := sqliteDb.Exec(sqlString, table, source, ipVersion, dbVersion)
+	if err != nil {
+		panic(err)
+	}
 }
\ No newline at end of file
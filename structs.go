@@ -1,11 +1,103 @@
 package main
 
+import (
+	"math"
+	"math/big"
+	"net/netip"
+	"time"
+)
+
+// Settings merged from the configuration file, environment and flags (see config.go), already parsed and validated
+type Config struct {
+	ServerHost				string
+	ServerPort				int
+	ApiKey					string
+	AdminApiKey				string
+	DbType					string
+	DbHost					string
+	DbPort					int
+	DbName					string
+	DbSchema				string
+	DbUser					string
+	DbPass					string
+	DbFile					string
+	Country					[]string
+	City					[]string
+	ASN						[]string
+	Reputation				[]ReputationSource
+	Geofeed					[]GeofeedSource
+	CountryConsensus		bool
+	CountryConsensusTrust	map[string]float64
+	UpdateTime				string
+	LoadLogFreq				int
+	RetainVersions			int
+	RetainDays				int
+	DiffReports				bool
+	DiffDir					string
+	WebhookUrl				[]string
+	WebhookSecret			string
+	WebhookEvents			[]string
+	WebhookRetries			int
+	ExportDir				string
+	RejectSpecialAddresses	bool
+	TimezoneInfer			bool
+	LogLevel				string
+	LogFormat				string
+	BenchmarkMaxTimes		int
+	BenchmarkMaxConcurrency	int
+	BenchmarkMaxDuration	time.Duration
+	BenchmarkCorpusDir		string
+	TraceEndpoint			string
+	TraceInsecure			bool
+	TraceServiceName		string
+	TraceSampleRatio		float64
+	Sources					map[string]Download
+	values					map[string]string
+}
+func NewConfig() *Config {
+	return &Config{
+		ServerPort:					8080,
+		LoadLogFreq:				1000,
+		WebhookRetries:				3,
+		ExportDir:					"exports",
+		TimezoneInfer:				true,
+		BenchmarkMaxTimes:			100000,
+		BenchmarkMaxConcurrency:	32,
+		BenchmarkMaxDuration:		time.Minute,
+		BenchmarkCorpusDir:			"./corpus",
+		TraceServiceName:			"ip-location-api",
+		TraceSampleRatio:			1,
+	}
+}
+
+// Settings are named but their values never shown, as some are secrets
+type ConfigReload struct {
+	Applied		[]string	`json:"applied"`
+	Rejected	[]string	`json:"rejected"`
+	Loading		[]string	`json:"loading"`
+}
+func NewConfigReload() *ConfigReload {
+	return &ConfigReload{ []string{}, []string{}, []string{} }
+}
+
+// `Layout` is only set for the sources defined in `SOURCES`, the built-in ones are all in the ip-location-db layout
 type Download struct {
 	Folder		string
 	Format		string
 	Type		string
 	CDN			string
 	Licenses	[]string
+	Layout		*SourceLayout
+}
+
+// Where a custom source's file(s) are found and how they're laid out. Fields are read from the `Columns` index or the
+// header column named in `HeaderColumns`.
+type SourceLayout struct {
+	Url				string
+	Delimiter		rune
+	Header			bool
+	Columns			map[string]int
+	HeaderColumns	map[string]string
 }
 
 type DataToLoad struct {
@@ -14,6 +106,139 @@ type DataToLoad struct {
 	Version		int
 }
 
+type LoadProgress struct {
+	Table		string
+	Source		string
+	IpVersion	int
+	DbVersion	int
+	Rows		int
+	Start		time.Time
+	Elapsed		time.Duration
+	frequency	int
+	logged		int
+}
+func NewLoadProgress(table string, source string, ipVersion int, dbVersion int) *LoadProgress {
+	return &LoadProgress{ table, source, ipVersion, dbVersion, 0, time.Now(), 0, config().LoadLogFreq, 0 }
+}
+
+type BenchmarkOptions struct {
+	IpVersion	int
+	Times		int
+	Duration	time.Duration
+	Concurrency	int
+	Warmup		int
+	Seed		uint64
+	Corpus		string
+	Filter		SampleFilter
+}
+
+type BenchmarkLatency struct {
+	Count	int		`json:"count"`
+	Mean	float64	`json:"mean_μs"`
+	P50		float64	`json:"p50_μs"`
+	P90		float64	`json:"p90_μs"`
+	P99		float64	`json:"p99_μs"`
+	Max		float64	`json:"max_μs"`
+}
+
+type BenchmarkReport struct {
+	IpVersion		int							`json:"ip_version"`
+	Times			int							`json:"times"`
+	Concurrency		int							`json:"concurrency"`
+	Warmup			int							`json:"warmup"`
+	Seed			uint64						`json:"seed"`
+	Corpus			string						`json:"corpus,omitempty"`
+	Errors			int							`json:"errors"`
+	Milliseconds	int64						`json:"ms"`
+	Microseconds	int64						`json:"μs"`
+	MsPerOp			float64						`json:"ms_per_op"`
+	UsPerOp			float64						`json:"μs_per_op"`
+	Throughput		float64						`json:"ops_per_sec"`
+	Latency			BenchmarkLatency			`json:"latency"`
+	Queries			map[string]BenchmarkLatency	`json:"queries"`
+}
+func NewBenchmarkReport(options BenchmarkOptions, times int, errors int, elapsed time.Duration) *BenchmarkReport {
+	report := &BenchmarkReport{
+		IpVersion:		options.IpVersion,
+		Times:			times,
+		Concurrency:	options.Concurrency,
+		Warmup:			options.Warmup,
+		Seed:			options.Seed,
+		Corpus:			options.Corpus,
+		Errors:			errors,
+		Milliseconds:	elapsed.Milliseconds(),
+		Microseconds:	elapsed.Microseconds(),
+		Queries:		map[string]BenchmarkLatency{},
+	}
+
+	if times > 0 {
+		report.UsPerOp	= microseconds(elapsed / time.Duration(times))
+		report.MsPerOp	= math.Round(report.UsPerOp * 100) / 100000
+	}
+
+	if elapsed > 0 {
+		report.Throughput = math.Round(float64(times) / elapsed.Seconds() * 100) / 100
+	}
+
+	return report
+}
+
+// One end of a distance calculation, either an IP's city location or a passed point
+type DistancePoint struct {
+	IP			string	`json:"ip,omitempty"`
+	Latitude	float64	`json:"lat"`
+	Longitude	float64	`json:"lon"`
+	City		string	`json:"city,omitempty"`
+	CountryCode	string	`json:"country_code,omitempty"`
+}
+
+type Distance struct {
+	From		DistancePoint	`json:"from"`
+	To			DistancePoint	`json:"to"`
+	Kilometres	float64			`json:"km"`
+	Miles		float64			`json:"miles"`
+	Bearing		float64			`json:"bearing"`
+	Compass		string			`json:"bearing_compass"`
+}
+func NewDistance(from DistancePoint, to DistancePoint, kilometres float64, bearing float64) *Distance {
+	return &Distance{
+		From:		from,
+		To:			to,
+		Kilometres:	math.Round(kilometres * 100) / 100,
+		Miles:		math.Round(kilometres / 1.609344 * 100) / 100,
+		Bearing:	math.Round(bearing * 10) / 10,
+		Compass:	compassPoint(bearing),
+	}
+}
+
+type SpecialRange struct {
+	Prefix		netip.Prefix
+	AddressType	string
+	Global		bool
+}
+func NewSpecialRange(cidr string, addressType string, global bool) SpecialRange {
+	return SpecialRange{ netip.MustParsePrefix(cidr), addressType, global }
+}
+
+type SampleRange struct {
+	Start	*big.Int
+	Size	*big.Int
+	Value	string
+}
+
+type SampleFilter struct {
+	CountryCode	string
+	AsNumber	int64
+	Weighted	bool
+}
+
+// Place names in languages other than the default (English) ones, keyed by language code
+type LocalisedNames struct {
+	City			map[string]string	`json:"city,omitempty"`
+	State1			map[string]string	`json:"state1,omitempty"`
+	State2			map[string]string	`json:"state2,omitempty"`
+}
+
 type IpCity struct {
 	IpRangeStart	string
 	IpRangeEnd		string
@@ -25,8 +250,10 @@ type IpCity struct {
 	Latitude		float64
 	Longitude		float64
 	Timezone		string
+	Names			LocalisedNames
 	IpVersion		int
 	DbVersion		int
+	Source			string
 }
 
 type IpASN struct {
@@ -36,6 +263,7 @@ type IpASN struct {
 	AsOrganisation	string
 	IpVersion		int
 	DbVersion		int
+	Source			string
 }
 
 type IpCountry struct {
@@ -44,15 +272,267 @@ type IpCountry struct {
 	CountryCode		string
 	IpVersion		int
 	DbVersion		int
+	Source			string
+}
+
+// A list of addresses / networks, `Category` is one of `tor`, `vpn` or `hosting`
+type ReputationSource struct {
+	Name		string
+	Category	string
+	Location	string
+}
+
+// Merged, non-overlapping range carrying every list that contains it
+type IpReputation struct {
+	IpRangeStart	string
+	IpRangeEnd		string
+	IsTor			bool
+	IsVpn			bool
+	IsHosting		bool
+	Tags			string
+	IpVersion		int
+	DbVersion		int
+}
+
+// An RFC 8805 geofeed, `Location` is a local file or URL
+type GeofeedSource struct {
+	Name		string
+	Location	string
+}
+
+// Non-overlapping range of a geofeed, `State1` is the ISO 3166-2 region code (e.g. `US-CA`)
+type IpGeofeed struct {
+	IpRangeStart	string
+	IpRangeEnd		string
+	CountryCode		string
+	State1			string
+	City			string
+	IpVersion		int
+	DbVersion		int
+}
+
+// Non-overlapping range voted on by the country sources, `Dissent` lists the sources that answered differently
+type IpConsensus struct {
+	IpRangeStart	string
+	IpRangeEnd		string
+	CountryCode		string
+	Confidence		float64
+	Dissent			string
+	IpVersion		int
+	DbVersion		int
+}
+
+// A completed load of a table (and source), it stays live until the next load of the same table and source completes
+type DatasetVersion struct {
+	Table		string		`json:"table"`
+	Source		string		`json:"source,omitempty"`
+	IpVersion	int			`json:"ip_version"`
+	DbVersion	int			`json:"db_version"`
+	LoadedAt	time.Time	`json:"loaded_at"`
+}
+func NewDatasetVersion(table string, source string, ipVersion int, dbVersion int, loadedAt time.Time) DatasetVersion {
+	return DatasetVersion{ table, source, ipVersion, dbVersion, loadedAt }
+}
+
+// Manually set location / ASN data for a network, only the fields that are present replace the dataset values
+type DatasetStatus struct {
+	Dataset		string				`json:"dataset"`
+	Type		string				`json:"type"`
+	Loaded		bool				`json:"loaded"`
+	Versions	[]DatasetVersion	`json:"versions"`
+}
+
+type Status struct {
+	DbType		string			`json:"db_type"`
+	Datasets	[]DatasetStatus	`json:"datasets"`
+}
+func NewStatus(dbType string) *Status {
+	return &Status{ dbType, []DatasetStatus{} }
+}
+
+type Override struct {
+	Id				int64		`json:"id"`
+	Network			string		`json:"network"`
+	CountryCode		*string		`json:"country_code,omitempty"`
+	State1			*string		`json:"state,omitempty"`
+	State2			*string		`json:"state_2,omitempty"`
+	City			*string		`json:"city,omitempty"`
+	Postcode		*string		`json:"postcode,omitempty"`
+	Latitude		*float64	`json:"lat,omitempty"`
+	Longitude		*float64	`json:"lon,omitempty"`
+	Timezone		*string		`json:"timezone,omitempty"`
+	AsNumber		*int64		`json:"as_number,omitempty"`
+	AsOrganisation	*string		`json:"as_organisation,omitempty"`
+	Note			string		`json:"note,omitempty"`
+	UpdatedAt		time.Time	`json:"updated_at"`
+	prefix			netip.Prefix
+}
+
+// One source's answer in a comparison, only the fields its dataset type holds are filled
+type ComparisonResult struct {
+	Source			string	`json:"source"`
+	Type			string	`json:"type"`
+	Configured		bool	`json:"configured"`
+	Found			bool	`json:"found"`
+	CountryCode		string	`json:"country_code,omitempty"`
+	State1			string	`json:"state,omitempty"`
+	City			string	`json:"city,omitempty"`
+	Latitude		float64	`json:"lat,omitempty"`
+	Longitude		float64	`json:"lon,omitempty"`
+	AsNumber		int		`json:"as_number,omitempty"`
+	AsOrganisation	string	`json:"as_organisation,omitempty"`
+}
+
+// The most common answer amongst the sources that gave one, `Values` lists the sources behind every answer
+type ComparisonAgreement struct {
+	Value		string				`json:"value"`
+	Agreeing	int					`json:"agreeing"`
+	Answered	int					`json:"answered"`
+	Unanimous	bool				`json:"unanimous"`
+	Values		map[string][]string	`json:"values"`
+}
+
+type Comparison struct {
+	IP				string							`json:"ip"`
+	EffectiveIP		string							`json:"effective_ip"`
+	Results			[]ComparisonResult				`json:"results"`
+	Agreement		map[string]ComparisonAgreement	`json:"agreement"`
+	NotLoaded		[]string						`json:"not_loaded"`
+	Milliseconds	int64							`json:"ms_taken"`
+	Microseconds	int64							`json:"μs_taken"`
+}
+func NewComparison(ipString string, effectiveIp string) *Comparison {
+	return &Comparison{ ipString, effectiveIp, []ComparisonResult{}, map[string]ComparisonAgreement{}, []string{}, 0, 0 }
+}
+
+// One piece of the address space whose value changed between two versions
+type DiffChange struct {
+	IpRangeStart	string		`json:"ip_range_start"`
+	IpRangeEnd		string		`json:"ip_range_end"`
+	From			string		`json:"from"`
+	To				string		`json:"to"`
+	Addresses		string		`json:"addresses"`
+	from			string
+	to				string
+	addresses		*big.Int
+}
+
+type DiffPair struct {
+	From			string		`json:"from"`
+	To				string		`json:"to"`
+	Ranges			int			`json:"ranges"`
+	Addresses		string		`json:"addresses"`
+	addresses		*big.Int
+}
+
+// What moved when a new version of a dataset replaced the previous one
+type DiffReport struct {
+	Dataset				string			`json:"dataset"`
+	Table				string			`json:"table"`
+	IpVersion			int				`json:"ip_version"`
+	FromVersion			int				`json:"from_version"`
+	ToVersion			int				`json:"to_version"`
+	GeneratedAt			time.Time		`json:"generated_at"`
+	RangesChanged		int				`json:"ranges_changed"`
+	AddressesChanged	string			`json:"addresses_changed"`
+	CountryChanges		int				`json:"country_changes"`
+	CityChanges			int				`json:"city_changes"`
+	AsnChanges			int				`json:"asn_changes"`
+	CountryPairs		[]DiffPair		`json:"country_pairs"`
+	Changes				[]DiffChange	`json:"changes"`
+	Truncated			bool			`json:"truncated"`
+	addresses			*big.Int
+	pairs				map[string]*DiffPair
+}
+func NewDiffReport(dataset string, table string, ipVersion int, fromVersion int, toVersion int) *DiffReport {
+	return &DiffReport{
+		Dataset:		dataset,
+		Table:			table,
+		IpVersion:		ipVersion,
+		FromVersion:	fromVersion,
+		ToVersion:		toVersion,
+		GeneratedAt:	time.Now().UTC().Truncate(time.Second),
+		CountryPairs:	[]DiffPair{},
+		Changes:		[]DiffChange{},
+		addresses:		big.NewInt(0),
+		pairs:			map[string]*DiffPair{},
+	}
+}
+
+type WebhookDataset struct {
+	Dataset		string	`json:"dataset"`
+	Table		string	`json:"table"`
+	IpVersion	int		`json:"ip_version"`
+	DbVersion	int		`json:"db_version"`
+	Rows		int		`json:"rows"`
+	DurationMs	int64	`json:"duration_ms"`
+}
+func NewWebhookDataset(progress LoadProgress) WebhookDataset {
+	return WebhookDataset{ datasetName(progress.Table, progress.Source), progress.Table, progress.IpVersion, progress.DbVersion, progress.Rows, progress.Elapsed.Milliseconds() }
+}
+
+type WebhookEvent struct {
+	Id			string				`json:"id"`
+	Event		string				`json:"event"`
+	Text		string				`json:"text"`
+	Time		time.Time			`json:"time"`
+	Datasets	[]WebhookDataset	`json:"datasets"`
+	Error		string				`json:"error,omitempty"`
+	DurationMs	int64				`json:"duration_ms"`
+}
+
+type WebhookDelivery struct {
+	EventId		string		`json:"event_id"`
+	Event		string		`json:"event"`
+	Url			string		`json:"url"`
+	Attempts	int			`json:"attempts"`
+	Status		int			`json:"status"`
+	Delivered	bool		`json:"delivered"`
+	Error		string		`json:"error,omitempty"`
+	Time		time.Time	`json:"time"`
+}
+
+type Country struct {
+	CountryCode		string		`json:"country_code"`
+	CountryName		string		`json:"country_name"`
+	ContinentCode	string		`json:"continent_code"`
+	ContinentName	string		`json:"continent_name"`
+	IsEu			bool		`json:"is_eu"`
+	Currency		string		`json:"currency"`
+	CallingCode		string		`json:"calling_code"`
+	Languages		[]string	`json:"languages"`
+}
+
+// The source that answered each part of a lookup
+type IpSources struct {
+	Country		string	`json:"country"`
+	City		string	`json:"city"`
+	ASN			string	`json:"asn"`
 }
 
 type Ip struct {
 	IP					string	`json:"ip"`
 	IPVersion			int		`json:"ip_version"`
+	EffectiveIP			string	`json:"effective_ip"`
+	TransitionMechanism	string	`json:"transition_mechanism"`
+	AddressType			string	`json:"address_type"`
+	IsGlobal			bool	`json:"is_global"`
 	FoundCountry		bool	`json:"found_country"`
 	FoundCity			bool	`json:"found_city"`
 	FoundASN			bool	`json:"found_asn"`
+	FoundGeofeed		bool	`json:"found_geofeed"`
+	Overridden			bool	`json:"overridden"`
+	Sources				IpSources	`json:"sources"`
 	CountryCode			string	`json:"country_code"`
+	Confidence			float64	`json:"confidence,omitempty"`
+	DissentingSources	[]string	`json:"dissenting_sources,omitempty"`
+	CountryName			string	`json:"country_name"`
+	ContinentCode		string	`json:"continent_code"`
+	ContinentName		string	`json:"continent_name"`
+	IsEu				bool	`json:"is_eu"`
+	Currency			string	`json:"currency"`
+	CallingCode			string	`json:"calling_code"`
+	Languages			[]string	`json:"languages"`
 	State1				string	`json:"state"`
 	State2				string	`json:"state_2"`
 	City				string	`json:"city"`
@@ -60,13 +540,25 @@ type Ip struct {
 	Latitude			float64	`json:"lat"`
 	Longitude			float64	`json:"lon"`
 	Timezone			string	`json:"timezone"`
+	TimezoneInferred	bool	`json:"timezone_inferred"`
+	UtcOffset			string	`json:"utc_offset"`
+	IsDst				bool	`json:"is_dst"`
+	LocalTime			string	`json:"local_time"`
+	TzAbbreviation		string	`json:"tz_abbreviation"`
 	OrganisationNumber	int64	`json:"as_number"`
 	OrganisationName	string	`json:"as_organisation"`
+	IsTor				bool	`json:"is_tor"`
+	IsVpn				bool	`json:"is_vpn"`
+	IsHosting			bool	`json:"is_hosting"`
+	Tags				[]string	`json:"tags"`
+	AsOf				string	`json:"as_of,omitempty"`
+	Language			string	`json:"language,omitempty"`
 	Milliseconds		int64	`json:"ms_taken"`
 	Microseconds		int64	`json:"μs_taken"`
+	names				LocalisedNames
 }
 func NewIp(ipString string, ipVersion int) *Ip {
-	return &Ip{ ipString, ipVersion, false, false, false, "", "", "", "", "", 0, 0, "", 0, "", 0, 0 }
+	return &Ip{ ipString, ipVersion, ipString, "", "", false, false, false, false, false, false, IpSources{}, "", 0, nil, "", "", "", false, "", "", []string{}, "", "", "", "", 0, 0, "", false, "", false, "", "", 0, "", false, false, false, []string{}, "", "", 0, 0, LocalisedNames{} }
 }
 
 type MmdbCountry struct {
@@ -80,11 +572,28 @@ type MmdbASN struct {
 	AsOrganisation	string		`maxminddb:"autonomous_system_organization"`
 }
 
+type MmdbReputation struct {
+	IsTor			bool		`maxminddb:"is_tor"`
+	IsVpn			bool		`maxminddb:"is_vpn"`
+	IsHosting		bool		`maxminddb:"is_hosting"`
+	Tags			[]string	`maxminddb:"tags"`
+}
+
+type MmdbGeofeed struct {
+	CountryCode		string		`maxminddb:"country_code"`
+	State1			string		`maxminddb:"state1"`
+	City			string		`maxminddb:"city"`
+}
+
+type MmdbConsensus struct {
+	CountryCode		string		`maxminddb:"country_code"`
+	Confidence		float64		`maxminddb:"confidence"`
+	Dissent			[]string	`maxminddb:"dissent"`
+}
+
 type MmdbCity struct {
 	City			struct {
-		Names		struct {
-			Value	string		`maxminddb:"en"`
-		}						`maxminddb:"names"`
+		Names		map[string]string	`maxminddb:"names"`
 		Postcode	string		`maxminddb:"postcode"`
 		Timezone	string		`maxminddb:"timezone"`
 	}							`maxminddb:"city"`
@@ -95,7 +604,7 @@ type MmdbCity struct {
 		Names		struct {
 			Value	string 		`maxminddb:"en"`
 		}						`maxminddb:"names"`
-	}							`maxminddb:"continent"`
+	}							`maxminddb:"country"`
 	Continent		struct {
 		Code		string		`maxminddb:"code"`
 		GeonameID	int64		`maxminddb:"geoname_id"`
@@ -108,8 +617,6 @@ type MmdbCity struct {
 		Longitude	float64		`maxminddb:"longitude"`
 	}							`maxminddb:"location"`
 	Subdivisions	[]struct {
-		Names		struct {
-			Value	string		`maxminddb:"en"`
-		}						`maxminddb:"names"`
+		Names		map[string]string	`maxminddb:"names"`
 	}							`maxminddb:"subdivisions"`
 }
\ No newline at end of file
@@ -11,14 +11,17 @@ CREATE TABLE IF NOT EXISTS `ip_city` (
 	`latitude`			decimal(11,8) NOT NULL,
 	`longitude`			decimal(11,8) NOT NULL,
 	`timezone`			varchar(20) NOT NULL,
+	`names`				text NULL,
 	`ip_version`		int(10) NOT NULL DEFAULT 4,
 	`db_version`		int(10) NOT NULL DEFAULT 1,
+	`source`			varchar(64) NOT NULL DEFAULT '',
 
 	KEY `ip_number_start` (`ip_number_start`),
 	KEY `ip_number_end` (`ip_number_end`),
 	KEY `country_code` (`country_code`),
 	KEY `ip_version` (`ip_version`),
-	KEY `db_version` (`db_version`)
+	KEY `db_version` (`db_version`),
+	KEY `source` (`source`, `ip_number_start`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_general_ci;
 
 CREATE TABLE IF NOT EXISTS `ip_asn` (
@@ -30,11 +33,13 @@ CREATE TABLE IF NOT EXISTS `ip_asn` (
 	`as_organisation`	varchar(100) NOT NULL,
 	`ip_version`		int(10) NOT NULL DEFAULT 4,
 	`db_version`		int(10) NOT NULL DEFAULT 1,
+	`source`			varchar(64) NOT NULL DEFAULT '',
 
 	KEY `ip_number_start` (`ip_number_start`),
 	KEY `ip_number_end` (`ip_number_end`),
 	KEY `ip_version` (`ip_version`),
-	KEY `db_version` (`db_version`)
+	KEY `db_version` (`db_version`),
+	KEY `source` (`source`, `ip_number_start`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_general_ci;
 
 CREATE TABLE IF NOT EXISTS `ip_country` (
@@ -45,10 +50,82 @@ CREATE TABLE IF NOT EXISTS `ip_country` (
 	`country_code`		varchar(2) NOT NULL,
 	`ip_version`		int(10) NOT NULL DEFAULT 4,
 	`db_version`		int(10) NOT NULL DEFAULT 1,
+	`source`			varchar(64) NOT NULL DEFAULT '',
 
 	KEY `ip_number_start` (`ip_number_start`),
 	KEY `ip_number_end` (`ip_number_end`),
 	KEY `country_code` (`country_code`),
 	KEY `ip_version` (`ip_version`),
+	KEY `db_version` (`db_version`),
+	KEY `source` (`source`, `ip_number_start`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_general_ci;
+
+CREATE TABLE IF NOT EXISTS `ip_reputation` (
+	`ip_range_start`	varchar(45) NOT NULL,
+	`ip_range_end`		varchar(45) NOT NULL,
+	`ip_number_start`	varbinary(16) NOT NULL,
+	`ip_number_end`		varbinary(16) NOT NULL,
+	`is_tor`			tinyint(1) NOT NULL DEFAULT 0,
+	`is_vpn`			tinyint(1) NOT NULL DEFAULT 0,
+	`is_hosting`		tinyint(1) NOT NULL DEFAULT 0,
+	`tags`				varchar(255) NOT NULL DEFAULT '',
+	`ip_version`		int(10) NOT NULL DEFAULT 4,
+	`db_version`		int(10) NOT NULL DEFAULT 1,
+
+	KEY `ip_number_start` (`ip_number_start`),
+	KEY `ip_number_end` (`ip_number_end`),
+	KEY `ip_version` (`ip_version`),
+	KEY `db_version` (`db_version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_general_ci;
+
+CREATE TABLE IF NOT EXISTS `ip_geofeed` (
+	`ip_range_start`	varchar(45) NOT NULL,
+	`ip_range_end`		varchar(45) NOT NULL,
+	`ip_number_start`	varbinary(16) NOT NULL,
+	`ip_number_end`		varbinary(16) NOT NULL,
+	`country_code`		varchar(2) NOT NULL,
+	`state1`			varchar(6) NOT NULL,
+	`city`				varchar(255) NOT NULL,
+	`ip_version`		int(10) NOT NULL DEFAULT 4,
+	`db_version`		int(10) NOT NULL DEFAULT 1,
+
+	KEY `ip_number_start` (`ip_number_start`),
+	KEY `ip_number_end` (`ip_number_end`),
+	KEY `ip_version` (`ip_version`),
+	KEY `db_version` (`db_version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_general_ci;
+
+CREATE TABLE IF NOT EXISTS `ip_consensus` (
+	`ip_range_start`	varchar(45) NOT NULL,
+	`ip_range_end`		varchar(45) NOT NULL,
+	`ip_number_start`	varbinary(16) NOT NULL,
+	`ip_number_end`		varbinary(16) NOT NULL,
+	`country_code`		varchar(2) NOT NULL,
+	`confidence`		decimal(5,4) NOT NULL,
+	`dissent`			varchar(255) NOT NULL,
+	`ip_version`		int(10) NOT NULL DEFAULT 4,
+	`db_version`		int(10) NOT NULL DEFAULT 1,
+
+	KEY `ip_number_start` (`ip_number_start`),
+	KEY `ip_number_end` (`ip_number_end`),
+	KEY `ip_version` (`ip_version`),
 	KEY `db_version` (`db_version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_general_ci;
+
+CREATE TABLE IF NOT EXISTS `ip_override` (
+	`id`				int(10) unsigned NOT NULL AUTO_INCREMENT,
+	`network`			varchar(49) NOT NULL,
+	`record`			text NOT NULL,
+
+	PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_general_ci;
+
+CREATE TABLE IF NOT EXISTS `ip_load` (
+	`dataset`			varchar(32) NOT NULL,
+	`source`			varchar(64) NOT NULL DEFAULT '',
+	`ip_version`		int(10) NOT NULL,
+	`db_version`		int(10) NOT NULL,
+	`loaded_at`			bigint(20) NOT NULL,
+
+	KEY `dataset` (`dataset`, `source`, `ip_version`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_general_ci;
\ No newline at end of file
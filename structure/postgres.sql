@@ -10,15 +10,21 @@ CREATE TABLE IF NOT EXISTS "${schema}"."ip_city"
 	"latitude"			numeric,
 	"longitude"			numeric,
 	"timezone"			varchar,
+	"names"				varchar,
 	"ip_version"		int DEFAULT 4,
-	"db_version"		int DEFAULT 1
+	"db_version"		int DEFAULT 1,
+	"source"			varchar NOT NULL DEFAULT ''
 );
 
+ALTER TABLE "${schema}"."ip_city" ADD COLUMN IF NOT EXISTS "names" varchar;
+ALTER TABLE "${schema}"."ip_city" ADD COLUMN IF NOT EXISTS "source" varchar NOT NULL DEFAULT '';
+
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_city:ip_range_start" ON "${schema}"."ip_city" ("ip_range_start");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_city:ip_range_end" ON "${schema}"."ip_city" ("ip_range_end");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_city:country_code" ON "${schema}"."ip_city" ("country_code");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_city:ip_version" ON "${schema}"."ip_city" ("ip_version");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_city:db_version" ON "${schema}"."ip_city" ("db_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_city:source" ON "${schema}"."ip_city" ("source", "ip_range_start");
 
 CREATE TABLE IF NOT EXISTS "${schema}"."ip_asn"
 (
@@ -27,13 +33,17 @@ CREATE TABLE IF NOT EXISTS "${schema}"."ip_asn"
 	"as_number"			varchar,
 	"as_organisation"	varchar,
 	"ip_version"		int DEFAULT 4,
-	"db_version"		int DEFAULT 1
+	"db_version"		int DEFAULT 1,
+	"source"			varchar NOT NULL DEFAULT ''
 );
 
+ALTER TABLE "${schema}"."ip_asn" ADD COLUMN IF NOT EXISTS "source" varchar NOT NULL DEFAULT '';
+
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_asn:ip_range_start" ON "${schema}"."ip_asn" ("ip_range_start");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_asn:ip_range_end" ON "${schema}"."ip_asn" ("ip_range_end");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_asn:ip_version" ON "${schema}"."ip_asn" ("ip_version");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_asn:db_version" ON "${schema}"."ip_asn" ("db_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_asn:source" ON "${schema}"."ip_asn" ("source", "ip_range_start");
 
 CREATE TABLE IF NOT EXISTS "${schema}"."ip_country"
 (
@@ -41,11 +51,80 @@ CREATE TABLE IF NOT EXISTS "${schema}"."ip_country"
 	"ip_range_end"		inet not null,
 	"country_code"		varchar,
 	"ip_version"		int DEFAULT 4,
-	"db_version"		int DEFAULT 1
+	"db_version"		int DEFAULT 1,
+	"source"			varchar NOT NULL DEFAULT ''
 );
 
+ALTER TABLE "${schema}"."ip_country" ADD COLUMN IF NOT EXISTS "source" varchar NOT NULL DEFAULT '';
+
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_country:ip_range_start" ON "${schema}"."ip_country" ("ip_range_start");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_country:ip_range_end" ON "${schema}"."ip_country" ("ip_range_end");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_country:country_code" ON "${schema}"."ip_country" ("country_code");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ip_country:ip_version" ON "${schema}"."ip_country" ("ip_version");
-CREATE INDEX IF NOT EXISTS "I:${schema}:ip_country:db_version" ON "${schema}"."ip_country" ("db_version");
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_country:db_version" ON "${schema}"."ip_country" ("db_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_country:source" ON "${schema}"."ip_country" ("source", "ip_range_start");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ip_reputation"
+(
+	"ip_range_start"	inet not null,
+	"ip_range_end"		inet not null,
+	"is_tor"			boolean DEFAULT false,
+	"is_vpn"			boolean DEFAULT false,
+	"is_hosting"		boolean DEFAULT false,
+	"tags"				varchar,
+	"ip_version"		int DEFAULT 4,
+	"db_version"		int DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_reputation:ip_range_start" ON "${schema}"."ip_reputation" ("ip_range_start");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_reputation:ip_range_end" ON "${schema}"."ip_reputation" ("ip_range_end");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_reputation:ip_version" ON "${schema}"."ip_reputation" ("ip_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_reputation:db_version" ON "${schema}"."ip_reputation" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ip_geofeed"
+(
+	"ip_range_start"	inet not null,
+	"ip_range_end"		inet not null,
+	"country_code"		varchar(2),
+	"state1"			varchar,
+	"city"				varchar,
+	"ip_version"		int DEFAULT 4,
+	"db_version"		int DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_geofeed:ip_range_start" ON "${schema}"."ip_geofeed" ("ip_range_start");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_geofeed:ip_range_end" ON "${schema}"."ip_geofeed" ("ip_range_end");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_geofeed:ip_version" ON "${schema}"."ip_geofeed" ("ip_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_geofeed:db_version" ON "${schema}"."ip_geofeed" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ip_consensus"
+(
+	"ip_range_start"	inet not null,
+	"ip_range_end"		inet not null,
+	"country_code"		varchar(2),
+	"confidence"		numeric,
+	"dissent"			varchar,
+	"ip_version"		int DEFAULT 4,
+	"db_version"		int DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_consensus:ip_range_start" ON "${schema}"."ip_consensus" ("ip_range_start");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_consensus:ip_range_end" ON "${schema}"."ip_consensus" ("ip_range_end");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_consensus:ip_version" ON "${schema}"."ip_consensus" ("ip_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ip_consensus:db_version" ON "${schema}"."ip_consensus" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ip_override"
+(
+	"id"				serial PRIMARY KEY,
+	"network"			cidr not null,
+	"record"			varchar not null
+);
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ip_load"
+(
+	"dataset"			varchar not null,
+	"source"			varchar not null DEFAULT '',
+	"ip_version"		int not null,
+	"db_version"		int not null,
+	"loaded_at"			bigint not null
+);
\ No newline at end of file
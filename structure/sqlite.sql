@@ -11,8 +11,10 @@ CREATE TABLE IF NOT EXISTS "${schema}"."ipv4_city" (
 	"latitude"			REAL NOT NULL,
 	"longitude"			REAL NOT NULL,
 	"timezone"			TEXT NOT NULL,
+	"names"				TEXT NOT NULL DEFAULT '',
 	"ip_version"		INTEGER NOT NULL DEFAULT 4,
-	"db_version"		INTEGER NOT NULL DEFAULT 1
+	"db_version"		INTEGER NOT NULL DEFAULT 1,
+	"source"			TEXT NOT NULL DEFAULT ''
 );
 
 CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_city:ip_number_start" ON "${schema}"."ipv4_city" ("ip_number_start");
@@ -34,8 +36,10 @@ CREATE TABLE IF NOT EXISTS "${schema}"."ipv6_city" (
 	"latitude"			REAL NOT NULL,
 	"longitude"			REAL NOT NULL,
 	"timezone"			TEXT NOT NULL,
+	"names"				TEXT NOT NULL DEFAULT '',
 	"ip_version"		INTEGER NOT NULL DEFAULT 4,
-	"db_version"		INTEGER NOT NULL DEFAULT 1
+	"db_version"		INTEGER NOT NULL DEFAULT 1,
+	"source"			TEXT NOT NULL DEFAULT ''
 );
 
 CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_city:ip_number_start" ON "${schema}"."ipv6_city" ("ip_number_start");
@@ -52,7 +56,8 @@ CREATE TABLE IF NOT EXISTS "${schema}"."ipv4_asn" (
 	"as_number"			INTEGER NOT NULL DEFAULT 0,
 	"as_organisation"	TEXT NOT NULL,
 	"ip_version"		INTEGER NOT NULL DEFAULT 4,
-	"db_version"		INTEGER NOT NULL DEFAULT 1
+	"db_version"		INTEGER NOT NULL DEFAULT 1,
+	"source"			TEXT NOT NULL DEFAULT ''
 );
 
 CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_asn:ip_number_start" ON "${schema}"."ipv4_asn" ("ip_number_start");
@@ -68,7 +73,8 @@ CREATE TABLE IF NOT EXISTS "${schema}"."ipv6_asn" (
 	"as_number"			INTEGER NOT NULL DEFAULT 0,
 	"as_organisation"	TEXT NOT NULL,
 	"ip_version"		INTEGER NOT NULL DEFAULT 4,
-	"db_version"		INTEGER NOT NULL DEFAULT 1
+	"db_version"		INTEGER NOT NULL DEFAULT 1,
+	"source"			TEXT NOT NULL DEFAULT ''
 );
 
 CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_asn:ip_number_start" ON "${schema}"."ipv6_asn" ("ip_number_start");
@@ -83,7 +89,8 @@ CREATE TABLE IF NOT EXISTS "${schema}"."ipv4_country" (
 	"ip_number_end"		INTEGER NOT NULL,
 	"country_code"		TEXT NOT NULL,
 	"ip_version"		INTEGER NOT NULL DEFAULT 4,
-	"db_version"		INTEGER NOT NULL DEFAULT 1
+	"db_version"		INTEGER NOT NULL DEFAULT 1,
+	"source"			TEXT NOT NULL DEFAULT ''
 );
 
 CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_country:ip_number_start" ON "${schema}"."ipv4_country" ("ip_number_start");
@@ -99,11 +106,130 @@ CREATE TABLE IF NOT EXISTS "${schema}"."ipv6_country" (
 	"ip_number_end"		NUMERIC NOT NULL,
 	"country_code"		TEXT NOT NULL,
 	"ip_version"		INTEGER NOT NULL DEFAULT 4,
-	"db_version"		INTEGER NOT NULL DEFAULT 1
+	"db_version"		INTEGER NOT NULL DEFAULT 1,
+	"source"			TEXT NOT NULL DEFAULT ''
 );
 
 CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_country:ip_number_start" ON "${schema}"."ipv6_country" ("ip_number_start");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_country:ip_number_end" ON "${schema}"."ipv6_country" ("ip_number_end");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_country:country_code" ON "${schema}"."ipv6_country" ("country_code");
 CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_country:ip_version" ON "${schema}"."ipv6_country" ("ip_version");
-CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_country:db_version" ON "${schema}"."ipv6_country" ("db_version");
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_country:db_version" ON "${schema}"."ipv6_country" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ipv4_reputation" (
+	"ip_range_start"	TEXT NOT NULL,
+	"ip_range_end"		TEXT NOT NULL,
+	"ip_number_start"	INTEGER NOT NULL,
+	"ip_number_end"		INTEGER NOT NULL,
+	"is_tor"			INTEGER NOT NULL DEFAULT 0,
+	"is_vpn"			INTEGER NOT NULL DEFAULT 0,
+	"is_hosting"		INTEGER NOT NULL DEFAULT 0,
+	"tags"				TEXT NOT NULL DEFAULT '',
+	"ip_version"		INTEGER NOT NULL DEFAULT 4,
+	"db_version"		INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_reputation:ip_number_start" ON "${schema}"."ipv4_reputation" ("ip_number_start");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_reputation:ip_number_end" ON "${schema}"."ipv4_reputation" ("ip_number_end");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_reputation:ip_version" ON "${schema}"."ipv4_reputation" ("ip_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_reputation:db_version" ON "${schema}"."ipv4_reputation" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ipv6_reputation" (
+	"ip_range_start"	TEXT NOT NULL,
+	"ip_range_end"		TEXT NOT NULL,
+	"ip_number_start"	NUMERIC NOT NULL,
+	"ip_number_end"		NUMERIC NOT NULL,
+	"is_tor"			INTEGER NOT NULL DEFAULT 0,
+	"is_vpn"			INTEGER NOT NULL DEFAULT 0,
+	"is_hosting"		INTEGER NOT NULL DEFAULT 0,
+	"tags"				TEXT NOT NULL DEFAULT '',
+	"ip_version"		INTEGER NOT NULL DEFAULT 4,
+	"db_version"		INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_reputation:ip_number_start" ON "${schema}"."ipv6_reputation" ("ip_number_start");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_reputation:ip_number_end" ON "${schema}"."ipv6_reputation" ("ip_number_end");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_reputation:ip_version" ON "${schema}"."ipv6_reputation" ("ip_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_reputation:db_version" ON "${schema}"."ipv6_reputation" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ipv4_geofeed" (
+	"ip_range_start"	TEXT NOT NULL,
+	"ip_range_end"		TEXT NOT NULL,
+	"ip_number_start"	INTEGER NOT NULL,
+	"ip_number_end"		INTEGER NOT NULL,
+	"country_code"		TEXT NOT NULL,
+	"state1"			TEXT NOT NULL,
+	"city"				TEXT NOT NULL,
+	"ip_version"		INTEGER NOT NULL DEFAULT 4,
+	"db_version"		INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_geofeed:ip_number_start" ON "${schema}"."ipv4_geofeed" ("ip_number_start");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_geofeed:ip_number_end" ON "${schema}"."ipv4_geofeed" ("ip_number_end");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_geofeed:ip_version" ON "${schema}"."ipv4_geofeed" ("ip_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_geofeed:db_version" ON "${schema}"."ipv4_geofeed" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ipv6_geofeed" (
+	"ip_range_start"	TEXT NOT NULL,
+	"ip_range_end"		TEXT NOT NULL,
+	"ip_number_start"	NUMERIC NOT NULL,
+	"ip_number_end"		NUMERIC NOT NULL,
+	"country_code"		TEXT NOT NULL,
+	"state1"			TEXT NOT NULL,
+	"city"				TEXT NOT NULL,
+	"ip_version"		INTEGER NOT NULL DEFAULT 4,
+	"db_version"		INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_geofeed:ip_number_start" ON "${schema}"."ipv6_geofeed" ("ip_number_start");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_geofeed:ip_number_end" ON "${schema}"."ipv6_geofeed" ("ip_number_end");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_geofeed:ip_version" ON "${schema}"."ipv6_geofeed" ("ip_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_geofeed:db_version" ON "${schema}"."ipv6_geofeed" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ipv4_consensus" (
+	"ip_range_start"	TEXT NOT NULL,
+	"ip_range_end"		TEXT NOT NULL,
+	"ip_number_start"	INTEGER NOT NULL,
+	"ip_number_end"		INTEGER NOT NULL,
+	"country_code"		TEXT NOT NULL,
+	"confidence"		REAL NOT NULL,
+	"dissent"			TEXT NOT NULL,
+	"ip_version"		INTEGER NOT NULL DEFAULT 4,
+	"db_version"		INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_consensus:ip_number_start" ON "${schema}"."ipv4_consensus" ("ip_number_start");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_consensus:ip_number_end" ON "${schema}"."ipv4_consensus" ("ip_number_end");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_consensus:ip_version" ON "${schema}"."ipv4_consensus" ("ip_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv4_consensus:db_version" ON "${schema}"."ipv4_consensus" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ipv6_consensus" (
+	"ip_range_start"	TEXT NOT NULL,
+	"ip_range_end"		TEXT NOT NULL,
+	"ip_number_start"	NUMERIC NOT NULL,
+	"ip_number_end"		NUMERIC NOT NULL,
+	"country_code"		TEXT NOT NULL,
+	"confidence"		REAL NOT NULL,
+	"dissent"			TEXT NOT NULL,
+	"ip_version"		INTEGER NOT NULL DEFAULT 4,
+	"db_version"		INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_consensus:ip_number_start" ON "${schema}"."ipv6_consensus" ("ip_number_start");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_consensus:ip_number_end" ON "${schema}"."ipv6_consensus" ("ip_number_end");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_consensus:ip_version" ON "${schema}"."ipv6_consensus" ("ip_version");
+CREATE INDEX IF NOT EXISTS "I:${schema}:ipv6_consensus:db_version" ON "${schema}"."ipv6_consensus" ("db_version");
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ip_override" (
+	"id"				INTEGER PRIMARY KEY AUTOINCREMENT,
+	"network"			TEXT NOT NULL,
+	"record"			TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "${schema}"."ip_load" (
+	"dataset"			TEXT NOT NULL,
+	"source"			TEXT NOT NULL DEFAULT '',
+	"ip_version"		INTEGER NOT NULL,
+	"db_version"		INTEGER NOT NULL,
+	"loaded_at"			INTEGER NOT NULL
+);
\ No newline at end of file
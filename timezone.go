@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ringsaturn/tzf"
+)
+
+// Loading a location reads the zone database each time, so they're kept once loaded
+var timezoneLocations sync.Map
+
+func timezoneLocation(name string) (*time.Location, bool) {
+	if location, ok := timezoneLocations.Load(name); ok {
+		return location.(*time.Location), location.(*time.Location) != nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		location = nil
+	}
+	timezoneLocations.Store(name, location)
+
+	return location, location != nil
+}
+
+// Fills in the current offset / DST state / local time for the resolved timezone
+func (ip *Ip) enrichTimezone(now time.Time) {
+	if len(ip.Timezone) == 0 {
+		return
+	}
+
+	location, ok := timezoneLocation(ip.Timezone)
+	if !ok {
+		return
+	}
+
+	local				:= now.In(location)
+	abbreviation, _		:= local.Zone()
+
+	ip.UtcOffset		= utcOffset(local)
+	ip.IsDst			= local.IsDST()
+	ip.LocalTime		= local.Format(time.RFC3339)
+	ip.TzAbbreviation	= abbreviation
+}
+
+func utcOffset(local time.Time) string {
+	_, offset := local.Zone()
+
+	sign := "+"
+	if offset < 0 {
+		sign	= "-"
+		offset	= -offset
+	}
+
+	return fmt.Sprintf("%s%02d:%02d", sign, offset / 3600, (offset % 3600) / 60)
+}
+
+// Timezone boundary polygons are only loaded the first time an inference is needed
+var timezoneFinder		tzf.F
+var timezoneFinderOnce	sync.Once
+
+func timezoneInferEnabled() bool {
+	return config().TimezoneInfer
+}
+
+// Name of the timezone containing the coordinates, empty if they fall outside every zone
+func timezoneInfer(latitude float64, longitude float64) string {
+	timezoneFinderOnce.Do(func() {
+		finder, err := tzf.NewDefaultFinder()
+		if err != nil {
+			panic(err)
+		}
+		timezoneFinder = finder
+	})
+
+	name := timezoneFinder.GetTimezoneName(longitude, latitude)
+	if strings.HasPrefix(name, "Etc/") {
+		return ""
+	}
+
+	return name
+}
+
+// Some sources (e.g. the ip-location-db city files) don't carry a timezone, so it's derived from the city location
+func (ip *Ip) inferTimezone() {
+	if len(ip.Timezone) > 0 || !ip.FoundCity || !timezoneInferEnabled() {
+		return
+	}
+
+	if ip.Latitude == 0 && ip.Longitude == 0 {
+		return
+	}
+
+	ip.Timezone = timezoneInfer(ip.Latitude, ip.Longitude)
+	ip.TimezoneInferred = len(ip.Timezone) > 0
+}
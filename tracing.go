@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/paul-norman/ip-location-api")
+
+// Returns a shutdown function that flushes any buffered spans; tracing is a no-op unless `TRACE_ENDPOINT` is set
+func tracingInit() func() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	endpoint := config().TraceEndpoint
+	if len(endpoint) == 0 {
+		return func() {}
+	}
+
+	var options []otlptracehttp.Option
+	if strings.Contains(endpoint, "://") {
+		options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+	} else {
+		options = append(options, otlptracehttp.WithEndpoint(endpoint))
+		if config().TraceInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		panic(err)
+	}
+
+	serviceName := config().TraceServiceName
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config().TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	slog.Info("tracing enabled", "endpoint", endpoint, "service", serviceName)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+		defer cancel()
+
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			slog.Error("error shutting down tracing", "error", err)
+		}
+	}
+}
+
+// Continues any incoming W3C trace context and wraps each request in a server span
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		ctx, span := tracer.Start(ctx, request.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		recorder	:= &statusRecorder{ ResponseWriter: response }
+		request		= request.WithContext(ctx)
+
+		next.ServeHTTP(recorder, request)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		// The mux fills in the matched pattern, so the span can only be named afterwards
+		if len(request.Pattern) > 0 {
+			span.SetName(request.Pattern)
+		}
+
+		span.SetAttributes(
+			attribute.String("http.request.method", request.Method),
+			attribute.String("url.path", request.URL.Path),
+			attribute.String("http.route", request.Pattern),
+			attribute.Int("http.response.status_code", recorder.status),
+		)
+
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+func traceQuery(ctx context.Context, table string, ipVersion int) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, "query " + table, trace.WithAttributes(
+		attribute.String("db.system", config().DbType),
+		attribute.String("db.table", table),
+		attribute.Int("ip_version", ipVersion),
+	))
+
+	return ctx, benchmarkTimeSpan(ctx, table, span)
+}
+
+// Ends the span, recording anything other than a simple "no match" as an error
+func traceEnd(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+func traceId(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if spanContext.HasTraceID() {
+		return spanContext.TraceID().String()
+	}
+
+	return ""
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Stands in for an OTLP/HTTP collector, passing on each span received with its service name
+func tracingCollector(t *testing.T, spans chan<- *tracepb.Span, services chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/v1/traces" {
+			t.Errorf("spans sent to %s rather than /v1/traces", request.URL.Path)
+		}
+
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		export := &collectortrace.ExportTraceServiceRequest{}
+		err = proto.Unmarshal(body, export)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		for _, resourceSpans := range export.ResourceSpans {
+			service := ""
+			for _, attribute := range resourceSpans.Resource.GetAttributes() {
+				if attribute.Key == "service.name" {
+					service = attribute.Value.GetStringValue()
+				}
+			}
+
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				for _, span := range scopeSpans.Spans {
+					services <- service
+					spans <- span
+				}
+			}
+		}
+
+		response.Header().Set("Content-Type", "application/x-protobuf")
+		response.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestTraceRequestsExportsSpans(t *testing.T) {
+	spans		:= make(chan *tracepb.Span, 10)
+	services	:= make(chan string, 10)
+	collector	:= tracingCollector(t, spans, services)
+	defer collector.Close()
+
+	testConfig := NewConfig()
+	testConfig.TraceEndpoint	= collector.URL + "/v1/traces"
+	testConfig.TraceServiceName	= "ip-location-api-test"
+	configCurrent.Store(testConfig)
+
+	shutdownTracing := tracingInit()
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /ip/{ip}", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	})
+
+	server := httptest.NewServer(traceRequests(router))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/ip/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	// Flushes the batched span to the collector
+	shutdownTracing()
+
+	select {
+		case span := <-spans:
+			if service := <-services; service != "ip-location-api-test" {
+				t.Errorf("service.name = %q, expected ip-location-api-test", service)
+			}
+
+			if span.Name != "GET /ip/{ip}" {
+				t.Errorf("span name = %q, expected GET /ip/{ip}", span.Name)
+			}
+
+			if span.Kind != tracepb.Span_SPAN_KIND_SERVER {
+				t.Errorf("span kind = %v, expected server", span.Kind)
+			}
+
+			attributes := map[string]*commonpb.AnyValue{}
+			for _, attribute := range span.Attributes {
+				attributes[attribute.Key] = attribute.Value
+			}
+
+			expected := map[string]string{
+				"http.request.method":	"GET",
+				"url.path":				"/ip/192.0.2.1",
+				"http.route":			"GET /ip/{ip}",
+			}
+			for key, value := range expected {
+				if attributes[key].GetStringValue() != value {
+					t.Errorf("%s = %q, expected %q", key, attributes[key].GetStringValue(), value)
+				}
+			}
+
+			if status := attributes["http.response.status_code"].GetIntValue(); status != http.StatusNotFound {
+				t.Errorf("http.response.status_code = %d, expected %d", status, http.StatusNotFound)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no span reached the collector")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Every retained load is checked on each lookup, so they're held in memory and reloaded from the backend after each load.
+// Both are replaced rather than changed, so the slices handed out are never written to.
+var datasetVersions			[]DatasetVersion
+var datasetVersionsIndex	map[versionKey][]DatasetVersion
+var datasetVersionsMutex	sync.RWMutex
+
+type versionKey struct {
+	table		string
+	source		string
+	ipVersion	int
+}
+
+type versionAsOfKey struct{}
+
+// Sorted newest first within each table, source and IP version, and indexed by them for the lookups
+func versionsLoad() {
+	loaded := dbVersions()
+
+	sort.SliceStable(loaded, func(i, j int) bool {
+		if loaded[i].Table != loaded[j].Table {
+			return loaded[i].Table < loaded[j].Table
+		}
+		if loaded[i].Source != loaded[j].Source {
+			return loaded[i].Source < loaded[j].Source
+		}
+		if loaded[i].IpVersion != loaded[j].IpVersion {
+			return loaded[i].IpVersion < loaded[j].IpVersion
+		}
+
+		return loaded[i].DbVersion > loaded[j].DbVersion
+	})
+
+	index := map[versionKey][]DatasetVersion{}
+	for _, version := range loaded {
+		key := versionKey{ version.Table, version.Source, version.IpVersion }
+		index[key] = append(index[key], version)
+	}
+
+	// Clipped, so appending to one copies it rather than writing into the shared array
+	for key, versions := range index {
+		index[key] = slices.Clip(versions)
+	}
+
+	datasetVersionsMutex.Lock()
+	defer datasetVersionsMutex.Unlock()
+
+	datasetVersions			= slices.Clip(loaded)
+	datasetVersionsIndex	= index
+}
+
+func versionsFromRows(rows *sql.Rows) []DatasetVersion {
+	versions := []DatasetVersion{}
+
+	for rows.Next() {
+		var version DatasetVersion
+		var loadedAt int64
+
+		err := rows.Scan(&version.Table, &version.Source, &version.IpVersion, &version.DbVersion, &loadedAt)
+		if err != nil {
+			panic(err)
+		}
+		version.LoadedAt = time.Unix(loadedAt, 0).UTC()
+
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	return versions
+}
+
+// All retained versions, newest first within each table, source and IP version
+func versionList() []DatasetVersion {
+	datasetVersionsMutex.RLock()
+	defer datasetVersionsMutex.RUnlock()
+
+	return datasetVersions
+}
+
+func versionsOf(table string, source string, ipVersion int) []DatasetVersion {
+	datasetVersionsMutex.RLock()
+	defer datasetVersionsMutex.RUnlock()
+
+	return datasetVersionsIndex[versionKey{ table, source, ipVersion }]
+}
+
+// Number of earlier versions to keep and / or how many days back they must have been live, both default to 0 (only the latest)
+func versionRetention() (int, int) {
+	return config().RetainVersions, config().RetainDays
+}
+
+func versionRecord(table string, source string, ipVersion int, dbVersion int) {
+	dbSaveVersion(NewDatasetVersion(table, source, ipVersion, dbVersion, time.Now().UTC().Truncate(time.Second)))
+	versionsLoad()
+}
+
+// Each version was live until the next one was loaded, so it's kept if that was recent enough or it's within the count kept
+func versionOldestRetained(table string, source string, ipVersion int) int {
+	versions := versionsOf(table, source, ipVersion)
+	if len(versions) == 0 {
+		return 0
+	}
+
+	keepVersions, keepDays	:= versionRetention()
+	cutoff					:= time.Now().AddDate(0, 0, -keepDays)
+	oldest					:= versions[0].DbVersion
+
+	for i := 1; i < len(versions); i++ {
+		if i <= keepVersions || (keepDays > 0 && versions[i - 1].LoadedAt.After(cutoff)) {
+			oldest = versions[i].DbVersion
+		}
+	}
+
+	return oldest
+}
+
+// The version served by default, 0 for data loaded before versions were recorded (which holds a single version)
+func versionCurrent(table string, source string, ipVersion int) int {
+	versions := versionsOf(table, source, ipVersion)
+	if len(versions) == 0 {
+		return 0
+	}
+
+	return versions[0].DbVersion
+}
+
+// The version live at the time requested for the lookup (if any), false when nothing retained was loaded by then
+func versionLive(ctx context.Context, table string, source string, ipVersion int) (int, bool) {
+	asOf, ok := versionAsOf(ctx)
+	if !ok {
+		return versionCurrent(table, source, ipVersion), true
+	}
+
+	for _, version := range versionsOf(table, source, ipVersion) {
+		if !version.LoadedAt.After(asOf) {
+			return version.DbVersion, true
+		}
+	}
+
+	return 0, false
+}
+
+func versionContext(ctx context.Context, asOf time.Time) context.Context {
+	return context.WithValue(ctx, versionAsOfKey{}, asOf)
+}
+
+func versionAsOf(ctx context.Context) (time.Time, bool) {
+	asOf, ok := ctx.Value(versionAsOfKey{}).(time.Time)
+
+	return asOf, ok
+}
+
+// A date (taken as the end of that day, UTC) or an RFC 3339 time, which mustn't be before the oldest retained load
+func versionParseAsOf(value string) (time.Time, error) {
+	asOf, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		asOf, err = time.Parse(time.DateOnly, value)
+		if err != nil {
+			return asOf, errors.New("as_of must be a date (YYYY-MM-DD) or an RFC 3339 time")
+		}
+
+		// Includes everything loaded during the day
+		asOf = asOf.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	var oldest time.Time
+	for _, version := range versionList() {
+		if oldest.IsZero() || version.LoadedAt.Before(oldest) {
+			oldest = version.LoadedAt
+		}
+	}
+
+	if oldest.IsZero() {
+		return asOf, errors.New("no earlier data is retained")
+	}
+
+	if asOf.Before(oldest) {
+		return asOf, errors.New("as_of is before the oldest retained data, loaded at " + oldest.Format(time.RFC3339))
+	}
+
+	return asOf.UTC(), nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVersionParseAsOf(t *testing.T) {
+	datasetVersions = []DatasetVersion{
+		{ Table: "ip_country", Source: "dbip-country", IpVersion: 4, DbVersion: 2, LoadedAt: time.Date(2026, 10, 1, 14, 30, 0, 0, time.UTC) },
+		{ Table: "ip_country", Source: "dbip-country", IpVersion: 4, DbVersion: 1, LoadedAt: time.Date(2026, 9, 1, 3, 0, 0, 0, time.UTC) },
+	}
+
+	tests := []struct {
+		value		string
+		expected	string
+	}{
+		// A date includes everything loaded during it
+		{ "2026-10-01",				"2026-10-01T23:59:59Z" },
+		{ "2026-09-01",				"2026-09-01T23:59:59Z" },
+		{ "2026-10-01T14:30:00Z",	"2026-10-01T14:30:00Z" },
+		{ "2026-10-01T16:30:00+02:00",	"2026-10-01T14:30:00Z" },
+		{ "2026-09-01T02:59:59Z",	"" },
+		{ "2026-08-31",				"" },
+		{ "yesterday",				"" },
+	}
+
+	for _, test := range tests {
+		asOf, err := versionParseAsOf(test.value)
+		if len(test.expected) == 0 {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", test.value, asOf.Format(time.RFC3339))
+			}
+			continue
+		}
+
+		if err != nil || asOf.Format(time.RFC3339) != test.expected {
+			t.Errorf("%s: got %s (%v), expected %s", test.value, asOf.Format(time.RFC3339), err, test.expected)
+		}
+	}
+
+	datasetVersions = nil
+	if _, err := versionParseAsOf("2026-10-01"); err == nil {
+		t.Error("expected an error when nothing is retained")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Only the latest deliveries are kept, newest last
+const webhookLogSize = 100
+
+var webhookEventNames	= []string{ "started", "completed", "failed", "no_change" }
+var webhookClient		= &http.Client{ Timeout: 10 * time.Second }
+var webhookLog			[]WebhookDelivery
+var webhookLogMutex		sync.RWMutex
+
+// Every event is sent unless `WEBHOOK_EVENTS` lists the ones wanted
+func webhookWanted(event string) bool {
+	events := config().WebhookEvents
+
+	return len(events) == 0 || slices.Contains(events, event)
+}
+
+// Sends the event to every configured URL, the update waits for the deliveries so events arrive in order
+func webhookNotify(event string, start time.Time, completed []LoadProgress, failure string) {
+	urls := config().WebhookUrl
+	if len(urls) == 0 || !slices.Contains(webhookEventNames, event) || !webhookWanted(event) {
+		return
+	}
+
+	payload := WebhookEvent{
+		Id:			fmt.Sprintf("%016x", rand.Uint64()),
+		Event:		event,
+		Time:		time.Now().UTC().Truncate(time.Second),
+		Datasets:	[]WebhookDataset{},
+		Error:		failure,
+		DurationMs:	time.Since(start).Milliseconds(),
+	}
+
+	rows := 0
+	for _, progress := range completed {
+		payload.Datasets = append(payload.Datasets, NewWebhookDataset(progress))
+		rows += progress.Rows
+	}
+
+	// Chat services (e.g. Slack) only display the `text`
+	switch event {
+		case "started":		payload.Text = "IP data update started"
+		case "completed":	payload.Text = fmt.Sprintf("IP data update completed: %d table(s) loaded, %d rows in %s", len(completed), rows, time.Since(start).Round(time.Second))
+		case "failed":		payload.Text = "IP data update failed: " + failure
+		case "no_change":	payload.Text = "IP data update found no new data"
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, url := range urls {
+		webhookDeliver(url, payload, body)
+	}
+}
+
+// Failed attempts are retried after 1, 2, 4... seconds
+func webhookDeliver(url string, payload WebhookEvent, body []byte) {
+	delivery	:= WebhookDelivery{ EventId: payload.Id, Event: payload.Event, Url: url }
+	retries		:= config().WebhookRetries
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1 << (attempt - 1)) * time.Second)
+		}
+
+		status, err := webhookPost(url, payload.Event, body)
+		delivery.Attempts	= attempt + 1
+		delivery.Status		= status
+		if err == nil {
+			delivery.Delivered	= true
+			delivery.Error		= ""
+			break
+		}
+
+		delivery.Error = err.Error()
+		slog.Warn("webhook delivery failed", "url", url, "event", payload.Event, "attempt", delivery.Attempts, "error", err)
+	}
+
+	delivery.Time = time.Now().UTC().Truncate(time.Second)
+	if delivery.Delivered {
+		slog.Info("webhook delivered", "url", url, "event", payload.Event, "attempts", delivery.Attempts)
+	}
+
+	webhookLogMutex.Lock()
+	webhookLog = append(webhookLog, delivery)
+	if len(webhookLog) > webhookLogSize {
+		webhookLog = webhookLog[len(webhookLog) - webhookLogSize:]
+	}
+	webhookLogMutex.Unlock()
+}
+
+// Signed with `WEBHOOK_SECRET` (when set) as `X-Signature-256: sha256=<hex HMAC of the body>`
+func webhookPost(url string, event string, body []byte) (int, error) {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Event", event)
+
+	if secret := config().WebhookSecret; len(secret) > 0 {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		request.Header.Set("X-Signature-256", "sha256=" + hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, errors.New("unexpected response status " + response.Status)
+	}
+
+	return response.StatusCode, nil
+}
+
+// Newest first
+func webhookDeliveries() []WebhookDelivery {
+	webhookLogMutex.RLock()
+	defer webhookLogMutex.RUnlock()
+
+	deliveries := []WebhookDelivery{}
+	for i := len(webhookLog) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, webhookLog[i])
+	}
+
+	return deliveries
+}